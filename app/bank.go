@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// builtinLibraries are the example libraries compiled into the server. None is
+// registered unless named with --functions.
+var builtinLibraries = map[string]*Library{
+	"bank": bankLibrary,
+}
+
+// bankLibrary keeps account balances in string keys. Debiting checks the
+// balance and updates it atomically, without WATCH retries.
+var bankLibrary = &Library{
+	Name: "bank",
+	Functions: []*Function{
+		{
+			Name:        "balance",
+			Description: "Returns the balance of an account",
+			Flags:       []string{"no-writes"},
+			Run:         bankBalance,
+		},
+		{
+			Name:        "debit",
+			Description: "Takes an amount from an account if its balance allows it",
+			Run:         bankDebit,
+		},
+	},
+}
+
+func bankBalance(redis *Redis, keys, args []string) resp.Value {
+	if len(keys) != 1 || len(args) != 0 {
+		return resp.Err("ERR balance expects one key and no arguments")
+	}
+
+	balance, err := readBalance(redis, keys[0])
+	if err != nil {
+		return resp.Err(err.Error())
+	}
+
+	return resp.Int(balance)
+}
+
+func bankDebit(redis *Redis, keys, args []string) resp.Value {
+	if len(keys) != 1 || len(args) != 1 {
+		return resp.Err("ERR debit expects one key and one amount")
+	}
+
+	amount, err := strconv.Atoi(args[0])
+	if err != nil || amount < 0 {
+		return resp.Err("ERR amount must be a non-negative integer")
+	}
+
+	balance, err := readBalance(redis, keys[0])
+	if err != nil {
+		return resp.Err(err.Error())
+	}
+
+	if balance < amount {
+		return resp.Err("ERR insufficient funds")
+	}
+
+	if ret := redis.Call("SET", keys[0], strconv.Itoa(balance-amount)); ret.Typ == resp.ErrorType {
+		return ret
+	}
+
+	return resp.Int(balance - amount)
+}
+
+// readBalance returns the balance stored at key, or 0 when the account
+// doesn't exist.
+func readBalance(redis *Redis, key string) (int, error) {
+	ret := redis.Call("GET", key)
+	switch ret.Typ {
+	case resp.ErrorType:
+		return 0, errors.New(ret.String())
+	case resp.BulkType:
+		balance, err := strconv.Atoi(ret.String())
+		if err != nil {
+			return 0, errors.New("ERR balance is not an integer")
+		}
+
+		return balance, nil
+	default:
+		return 0, nil
+	}
+}
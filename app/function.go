@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/glob"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// Library is a named group of functions implemented in Go. Libraries are
+// registered when the server starts and loaded with FUNCTION LOAD.
+type Library struct {
+	Name      string
+	Functions []*Function
+}
+
+// Function is called by FCALL with its declared keys and the remaining
+// arguments, and talks to the keyspace through redis.
+type Function struct {
+	Name        string
+	Description string
+	// Flags holds the function flags. Only functions flagged "no-writes" can
+	// be called with FCALL_RO.
+	Flags []string
+	Run   func(redis *Redis, keys, args []string) resp.Value
+}
+
+// Redis lets a function run commands, like redis.call does in scripts.
+// Commands may only access the keys given to FCALL, and may not write when
+// the function was called with FCALL_RO or is flagged "no-writes".
+type Redis struct {
+	readOnly bool
+	keys     []string
+}
+
+// Call runs a command and returns its reply. Errors are returned as error
+// replies, leaving it to the function to decide whether to abort.
+func (r *Redis) Call(args ...string) resp.Value {
+	if len(args) == 0 {
+		return resp.Err("ERR Please specify at least one argument for this redis lib call")
+	}
+
+	command := strings.ToUpper(args[0])
+	handler, ok := Handlers[command]
+	if !ok || slices.Contains(noScriptCommands, command) {
+		return resp.Err("ERR This Redis command is not allowed from script")
+	}
+
+	values := make([]resp.Value, len(args))
+	for i, arg := range args {
+		values[i] = resp.Bulk(arg)
+	}
+
+	isWrite := isWriteCommand(values) || slices.Contains(PublishCommands, command)
+	if isWrite && r.readOnly {
+		return resp.Err("ERR Write commands are not allowed from read-only scripts.")
+	}
+
+	if !checkArity(command, len(values)) {
+		return resp.Errf("ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+	}
+
+	for _, key := range commandKeys(values) {
+		if !slices.Contains(r.keys, key) {
+			return resp.Errf("ERR Script attempted to access key '%s' that was not declared in the keys of the call", key)
+		}
+	}
+
+	ret := handler(values[1:])
+	if isWrite {
+		keyspace.effects = append(keyspace.effects, resp.Array(values...))
+	}
+
+	return ret
+}
+
+// noScriptCommands can't be called from a function.
+var noScriptCommands = []string{"FCALL", "FCALL_RO", "FUNCTION", "PSYNC", "REPLCONF", "WAIT", "AUTH", "ACL", "UNWATCH"}
+
+// Functions is the registry of libraries. Registered libraries are available
+// to FUNCTION LOAD, loaded ones can be called with FCALL.
+type Functions struct {
+	mu         sync.RWMutex
+	registered map[string]*Library
+	libraries  map[string]*Library
+	functions  map[string]*Function
+}
+
+func NewFunctions() *Functions {
+	return &Functions{
+		registered: make(map[string]*Library),
+		libraries:  make(map[string]*Library),
+		functions:  make(map[string]*Function),
+	}
+}
+
+// Register makes lib available and loads it.
+func (f *Functions) Register(lib *Library) error {
+	f.mu.Lock()
+	f.registered[lib.Name] = lib
+	f.mu.Unlock()
+
+	return f.Load(lib.Name, false)
+}
+
+func (f *Functions) Load(name string, replace bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lib, ok := f.registered[name]
+	if !ok {
+		return fmt.Errorf("ERR Library '%s' is not registered", name)
+	}
+
+	if _, ok := f.libraries[name]; ok && !replace {
+		return fmt.Errorf("ERR Library '%s' already exists", name)
+	}
+
+	for _, fn := range lib.Functions {
+		if other, ok := f.functions[fn.Name]; ok && !slices.Contains(lib.Functions, other) {
+			return fmt.Errorf("ERR Function %s already exists", fn.Name)
+		}
+	}
+
+	f.delete(name)
+	f.libraries[name] = lib
+	for _, fn := range lib.Functions {
+		f.functions[fn.Name] = fn
+	}
+
+	return nil
+}
+
+func (f *Functions) Delete(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.libraries[name]; !ok {
+		return fmt.Errorf("ERR Library not found")
+	}
+
+	f.delete(name)
+	return nil
+}
+
+// delete unloads the library name. The caller must hold f.mu.
+func (f *Functions) delete(name string) {
+	lib, ok := f.libraries[name]
+	if !ok {
+		return
+	}
+
+	for _, fn := range lib.Functions {
+		delete(f.functions, fn.Name)
+	}
+
+	delete(f.libraries, name)
+}
+
+func (f *Functions) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	clear(f.libraries)
+	clear(f.functions)
+}
+
+func (f *Functions) Get(name string) (*Function, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	fn, ok := f.functions[name]
+	return fn, ok
+}
+
+func function(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Err("ERR wrong number of arguments for 'function' command")
+	}
+
+	subcommand := strings.ToUpper(args[0].String())
+	switch subcommand {
+	case "LOAD":
+		return functionLoad(args[1:])
+	case "LIST":
+		return functionList(args[1:])
+	case "DELETE":
+		if len(args) != 2 {
+			return resp.Err("ERR wrong number of arguments for 'function|delete' command")
+		}
+
+		if err := server.functions.Delete(args[1].String()); err != nil {
+			return resp.Err(err.Error())
+		}
+
+		return resp.OK()
+	case "FLUSH":
+		if len(args) > 2 {
+			return resp.Err("ERR wrong number of arguments for 'function|flush' command")
+		}
+
+		if len(args) == 2 {
+			mode := strings.ToUpper(args[1].String())
+			if mode != "SYNC" && mode != "ASYNC" {
+				return resp.Err("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+			}
+		}
+
+		server.functions.Flush()
+		return resp.OK()
+	case "STATS":
+		if len(args) != 1 {
+			return resp.Err("ERR wrong number of arguments for 'function|stats' command")
+		}
+
+		return functionStats()
+	default:
+		return resp.Errf("ERR unknown subcommand '%v'. Try FUNCTION HELP.", args[0].String())
+	}
+}
+
+// functionLoad loads a registered library. Functions are compiled into the
+// server, so the payload is the library name instead of its source code.
+func functionLoad(args []resp.Value) resp.Value {
+	replace := len(args) == 2 && strings.ToUpper(args[0].String()) == "REPLACE"
+	if len(args) != 1 && !replace {
+		return resp.Err("ERR wrong number of arguments for 'function|load' command")
+	}
+
+	name := args[len(args)-1].String()
+	if err := server.functions.Load(name, replace); err != nil {
+		return resp.Err(err.Error())
+	}
+
+	return resp.Bulk(name)
+}
+
+func functionList(args []resp.Value) resp.Value {
+	pattern := "*"
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].String()) {
+		case "LIBRARYNAME":
+			if i+1 >= len(args) {
+				return resp.Err("ERR library name argument was not given")
+			}
+
+			pattern = args[i+1].String()
+			i++
+		case "WITHCODE":
+			// Libraries are compiled into the server, so there is no code to show.
+		default:
+			return resp.Errf("ERR Unknown argument %s", args[i].String())
+		}
+	}
+
+	server.functions.mu.RLock()
+	defer server.functions.mu.RUnlock()
+
+	names := make([]string, 0, len(server.functions.libraries))
+	for name := range server.functions.libraries {
+		if glob.Match(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	ret := resp.Array()
+	for _, name := range names {
+		lib := server.functions.libraries[name]
+		functions := resp.Array()
+		for _, fn := range lib.Functions {
+			flags := resp.Array()
+			for _, flag := range fn.Flags {
+				flags.Array = append(flags.Array, resp.Bulk(flag))
+			}
+
+			description := resp.Null()
+			if fn.Description != "" {
+				description = resp.Bulk(fn.Description)
+			}
+
+			functions.Array = append(functions.Array, resp.Map(
+				resp.Bulk("name"), resp.Bulk(fn.Name),
+				resp.Bulk("description"), description,
+				resp.Bulk("flags"), flags,
+			))
+		}
+
+		ret.Array = append(ret.Array, resp.Map(
+			resp.Bulk("library_name"), resp.Bulk(lib.Name),
+			resp.Bulk("engine"), resp.Bulk("GO"),
+			resp.Bulk("functions"), functions,
+		))
+	}
+
+	return ret
+}
+
+// functionStats never reports a running function: FCALL holds the keyspace
+// exclusively, so no other command can run while a function does.
+func functionStats() resp.Value {
+	server.functions.mu.RLock()
+	defer server.functions.mu.RUnlock()
+
+	return resp.Map(
+		resp.Bulk("running_script"),
+		resp.Null(),
+		resp.Bulk("engines"),
+		resp.Map(
+			resp.Bulk("GO"),
+			resp.Map(
+				resp.Bulk("libraries_count"),
+				resp.Int(len(server.functions.libraries)),
+				resp.Bulk("functions_count"),
+				resp.Int(len(server.functions.functions)),
+			),
+		),
+	)
+}
+
+func fcall(args []resp.Value) resp.Value {
+	return fcallGeneric(args, false)
+}
+
+func fcallRO(args []resp.Value) resp.Value {
+	return fcallGeneric(args, true)
+}
+
+// fcallGeneric runs a function. The caller must hold the keyspace exclusively,
+// see callFunction.
+func fcallGeneric(args []resp.Value, readOnly bool) resp.Value {
+	command := "fcall"
+	if readOnly {
+		command = "fcall_ro"
+	}
+
+	if len(args) < 2 {
+		return resp.Errf("ERR wrong number of arguments for '%s' command", command)
+	}
+
+	fn, ok := server.functions.Get(args[0].String())
+	if !ok {
+		return resp.Err("ERR Function not found")
+	}
+
+	numkeys, err := strconv.Atoi(args[1].String())
+	if err != nil {
+		return resp.Err("ERR value is not an integer or out of range")
+	}
+
+	if numkeys < 0 {
+		return resp.Err("ERR Number of keys can't be negative")
+	}
+
+	if numkeys > len(args)-2 {
+		return resp.Err("ERR Number of keys can't be greater than number of args")
+	}
+
+	if readOnly && !slices.Contains(fn.Flags, "no-writes") {
+		return resp.Err("ERR Can not execute a script with write flag using *_ro command.")
+	}
+
+	// The shutdown sets the flag while holding the keyspace exclusively, like
+	// the caller, so no function writes once it has started.
+	if !slices.Contains(fn.Flags, "no-writes") && server.shuttingDown.Load() {
+		return resp.Err("ERR Server is shutting down")
+	}
+
+	keys := make([]string, numkeys)
+	for i := range keys {
+		keys[i] = args[2+i].String()
+	}
+
+	rest := make([]string, len(args)-2-numkeys)
+	for i := range rest {
+		rest[i] = args[2+numkeys+i].String()
+	}
+
+	redis := &Redis{readOnly: readOnly || slices.Contains(fn.Flags, "no-writes"), keys: keys}
+	return fn.Run(redis, keys, rest)
+}
+
+// callFunction runs FCALL or FCALL_RO with exclusive access to the keyspace,
+// and replicates the writes done by the function wrapped in MULTI/EXEC.
+func callFunction(handler Handler, args []resp.Value) resp.Value {
+	var ret resp.Value
+	keyspace.runExclusive(func() {
+		ret = handler(args)
+		server.propagateTransaction(keyspace.effects)
+	})
+
+	return ret
+}
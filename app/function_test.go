@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// callLibrary has functions running the command in their arguments, to test
+// what functions are allowed to do.
+var callLibrary = &Library{
+	Name: "test:call",
+	Functions: []*Function{
+		{Name: "call", Run: runCall},
+		{Name: "call_ro", Flags: []string{"no-writes"}, Run: runCall},
+	},
+}
+
+func runCall(redis *Redis, keys, args []string) resp.Value {
+	return redis.Call(args...)
+}
+
+// loadLibraries starts the test server with only bank and callLibrary loaded.
+func loadLibraries(t *testing.T) {
+	t.Helper()
+
+	startTestServer(t)
+	server.functions.Flush()
+	for _, lib := range []*Library{bankLibrary, callLibrary} {
+		if err := server.functions.Register(lib); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFCall(t *testing.T) {
+	const account = "test:function:account"
+	tests := []struct {
+		name string
+		args []string
+		want resp.Value
+		// balance is the account balance after the call.
+		balance string
+	}{
+		{"debit", []string{"FCALL", "debit", "1", account, "30"}, resp.Int(70), "70"},
+		{"insufficient funds", []string{"FCALL", "debit", "1", account, "130"}, resp.Err("ERR insufficient funds"), "100"},
+		{"invalid amount", []string{"FCALL", "debit", "1", account, "-1"}, resp.Err("ERR amount must be a non-negative integer"), "100"},
+		{"balance", []string{"FCALL_RO", "balance", "1", account}, resp.Int(100), "100"},
+		{"missing account", []string{"FCALL", "balance", "1", "test:function:missing"}, resp.Int(0), "100"},
+		{"write from fcall_ro", []string{"FCALL_RO", "debit", "1", account, "30"}, resp.Err("ERR Can not execute a script with write flag using *_ro command."), "100"},
+		{"unknown function", []string{"FCALL", "credit", "1", account, "30"}, resp.Err("ERR Function not found"), "100"},
+		{"numkeys not an integer", []string{"FCALL", "debit", "one", account, "30"}, resp.Err("ERR value is not an integer or out of range"), "100"},
+		{"negative numkeys", []string{"FCALL", "debit", "-1", account, "30"}, resp.Err("ERR Number of keys can't be negative"), "100"},
+		{"too many keys", []string{"FCALL", "debit", "3", account, "30"}, resp.Err("ERR Number of keys can't be greater than number of args"), "100"},
+		{"call", []string{"FCALL", "call", "1", account, "INCR", account}, resp.Int(101), "101"},
+		{"undeclared key", []string{"FCALL", "call", "1", account, "SET", "test:function:other", "1"}, resp.Err("ERR Script attempted to access key 'test:function:other' that was not declared in the keys of the call"), "100"},
+		{"write from a no-writes function", []string{"FCALL", "call_ro", "1", account, "SET", account, "0"}, resp.Err("ERR Write commands are not allowed from read-only scripts."), "100"},
+		{"read from a no-writes function", []string{"FCALL_RO", "call_ro", "1", account, "GET", account}, resp.Bulk("100"), "100"},
+		{"publish from a no-writes function", []string{"FCALL", "call_ro", "0", "PUBLISH", "news", "hello"}, resp.Err("ERR Write commands are not allowed from read-only scripts."), "100"},
+		{"function from a function", []string{"FCALL", "call", "0", "FCALL", "call", "0"}, resp.Err("ERR This Redis command is not allowed from script"), "100"},
+		{"unknown command", []string{"FCALL", "call", "0", "NOSUCH"}, resp.Err("ERR This Redis command is not allowed from script"), "100"},
+		{"arity", []string{"FCALL", "call", "1", account, "GET"}, resp.Err("ERR wrong number of arguments for 'get' command"), "100"},
+		{"no command", []string{"FCALL", "call", "0"}, resp.Err("ERR Please specify at least one argument for this redis lib call"), "100"},
+	}
+
+	loadLibraries(t)
+	ctx := context.Background()
+	conn := dialTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := conn.Do(ctx, "SET", account, "100"); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := conn.Do(ctx, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(2), tt.want.MarshalProtocol(2))
+			}
+
+			if balance, err := conn.Do(ctx, "GET", account); err != nil || balance.String() != tt.balance {
+				t.Fatalf("balance is %v, %v, want %s", balance, err, tt.balance)
+			}
+		})
+	}
+}
+
+func TestFunctionLibraries(t *testing.T) {
+	bank := resp.Map(
+		resp.Bulk("library_name"), resp.Bulk("bank"),
+		resp.Bulk("engine"), resp.Bulk("GO"),
+		resp.Bulk("functions"), resp.Array(
+			resp.Map(
+				resp.Bulk("name"), resp.Bulk("balance"),
+				resp.Bulk("description"), resp.Bulk("Returns the balance of an account"),
+				resp.Bulk("flags"), resp.Array(resp.Bulk("no-writes")),
+			),
+			resp.Map(
+				resp.Bulk("name"), resp.Bulk("debit"),
+				resp.Bulk("description"), resp.Bulk("Takes an amount from an account if its balance allows it"),
+				resp.Bulk("flags"), resp.Array(),
+			),
+		),
+	)
+	stats := func(libraries, functions int) resp.Value {
+		return resp.Map(
+			resp.Bulk("running_script"), resp.Null(),
+			resp.Bulk("engines"), resp.Map(resp.Bulk("GO"), resp.Map(
+				resp.Bulk("libraries_count"), resp.Int(libraries),
+				resp.Bulk("functions_count"), resp.Int(functions),
+			)),
+		)
+	}
+
+	steps := []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"FUNCTION", "STATS"}, stats(2, 4)},
+		{[]string{"FUNCTION", "LIST", "LIBRARYNAME", "b*"}, resp.Array(bank)},
+		{[]string{"FUNCTION", "LIST", "LIBRARYNAME", "nosuch"}, resp.Array()},
+		{[]string{"FUNCTION", "LIST", "LIBRARYNAME"}, resp.Err("ERR library name argument was not given")},
+		{[]string{"FUNCTION", "LIST", "WITHSOURCE"}, resp.Err("ERR Unknown argument WITHSOURCE")},
+		{[]string{"FUNCTION", "LOAD", "bank"}, resp.Err("ERR Library 'bank' already exists")},
+		{[]string{"function", "load", "replace", "bank"}, resp.Bulk("bank")},
+		{[]string{"FUNCTION", "LOAD", "nosuch"}, resp.Err("ERR Library 'nosuch' is not registered")},
+		{[]string{"FUNCTION", "LOAD", "REPLACE"}, resp.Err("ERR Library 'REPLACE' is not registered")},
+		{[]string{"FUNCTION", "LOAD", "A", "bank"}, resp.Err("ERR wrong number of arguments for 'function|load' command")},
+		{[]string{"FUNCTION", "DELETE", "bank"}, resp.OK()},
+		{[]string{"FUNCTION", "DELETE", "bank"}, resp.Err("ERR Library not found")},
+		{[]string{"FCALL", "debit", "1", "test:function:account", "1"}, resp.Err("ERR Function not found")},
+		{[]string{"FUNCTION", "LIST", "WITHCODE", "LIBRARYNAME", "bank"}, resp.Array()},
+		{[]string{"FUNCTION", "STATS"}, stats(1, 2)},
+		{[]string{"FUNCTION", "LOAD", "bank"}, resp.Bulk("bank")},
+		{[]string{"FUNCTION", "FLUSH", "LATER"}, resp.Err("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")},
+		{[]string{"FUNCTION", "FLUSH", "ASYNC"}, resp.OK()},
+		{[]string{"FUNCTION", "LIST"}, resp.Array()},
+		{[]string{"FUNCTION", "STATS"}, stats(0, 0)},
+		{[]string{"FUNCTION", "RESTART"}, resp.Err("ERR unknown subcommand 'RESTART'. Try FUNCTION HELP.")},
+	}
+
+	loadLibraries(t)
+	t.Cleanup(func() { server.functions.Flush() })
+	for i, step := range steps {
+		if got := call(step.args...); !sameReply(got, step.want) {
+			t.Fatalf("step %d %v: got %q, want %q", i, step.args, got.MarshalProtocol(3), step.want.MarshalProtocol(3))
+		}
+	}
+}
+
+// TestFunctionNameClash checks that a library can't be loaded while another
+// one has a function of the same name.
+func TestFunctionNameClash(t *testing.T) {
+	clash := &Library{
+		Name:      "test:clash",
+		Functions: []*Function{{Name: "debit", Run: runCall}},
+	}
+
+	loadLibraries(t)
+	t.Cleanup(func() { server.functions.Flush() })
+	if err := server.functions.Register(clash); err == nil || err.Error() != "ERR Function debit already exists" {
+		t.Fatalf("registering a clashing library returned %v", err)
+	}
+
+	if got := call("FUNCTION", "DELETE", "bank"); !sameReply(got, resp.OK()) {
+		t.Fatalf("got %q deleting bank", got.MarshalProtocol(3))
+	}
+
+	if got := call("FUNCTION", "LOAD", "test:clash"); !sameReply(got, resp.Bulk("test:clash")) {
+		t.Fatalf("got %q loading test:clash once bank is deleted", got.MarshalProtocol(3))
+	}
+
+	if got := call("FUNCTION", "LOAD", "bank"); !sameReply(got, resp.Err("ERR Function debit already exists")) {
+		t.Fatalf("got %q loading bank", got.MarshalProtocol(3))
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
-	"container/heap"
+	"cmp"
+	"errors"
 	"fmt"
 	"math"
 	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -12,151 +14,460 @@ import (
 
 	"github.com/codecrafters-io/redis-starter-go/internal/auth"
 	"github.com/codecrafters-io/redis-starter-go/internal/geohash"
-	"github.com/codecrafters-io/redis-starter-go/internal/resp"
 	s "github.com/codecrafters-io/redis-starter-go/internal/set"
+	"github.com/codecrafters-io/redis-starter-go/resp"
 )
 
 type Handler func([]resp.Value) resp.Value
 
 var Handlers = map[string]Handler{
-	"ECHO":      echo,
-	"SET":       set,
-	"GET":       get,
-	"CONFIG":    config,
-	"KEYS":      keys,
-	"INFO":      info,
-	"REPLCONF":  replconf,
-	"PSYNC":     psync,
-	"WAIT":      wait,
-	"TYPE":      typ,
-	"XADD":      xadd,
-	"XRANGE":    xrange,
-	"XREAD":     xread,
-	"INCR":      incr,
-	"RPUSH":     rpush,
-	"LRANGE":    lrange,
-	"LPUSH":     lpush,
-	"LLEN":      llen,
-	"LPOP":      lpop,
-	"BLPOP":     blpop,
-	"PUBLISH":   publish,
-	"ZADD":      zadd,
-	"ZRANK":     zrank,
-	"ZRANGE":    zrange,
-	"ZCARD":     zcard,
-	"ZSCORE":    zscore,
-	"ZREM":      zrem,
-	"GEOADD":    geoadd,
-	"GEOPOS":    geopos,
-	"GEODIST":   geodist,
-	"GEOSEARCH": geosearch,
-	"ACL":       acl,
-	"AUTH":      authenticate,
+	"ECHO":                 echo,
+	"PING":                 pong,
+	"UNWATCH":              unwatch,
+	"SET":                  set,
+	"GET":                  get,
+	"CONFIG":               config,
+	"KEYS":                 keys,
+	"FLUSHALL":             flushall,
+	"INFO":                 info,
+	"REPLCONF":             replconf,
+	"PSYNC":                psync,
+	"WAIT":                 wait,
+	"TYPE":                 typ,
+	"XADD":                 xadd,
+	"XRANGE":               xrange,
+	"XREAD":                xread,
+	"XLEN":                 xlen,
+	"XDEL":                 xdel,
+	"XTRIM":                xtrim,
+	"XSETID":               xsetid,
+	"XINFO":                xinfo,
+	"INCR":                 incr,
+	"RPUSH":                rpush,
+	"LRANGE":               lrange,
+	"LPUSH":                lpush,
+	"LLEN":                 llen,
+	"LPOP":                 lpop,
+	"BLPOP":                blpop,
+	"PUBLISH":              publish,
+	"PUBSUB":               pubsubCommand,
+	"SPUBLISH":             spublish,
+	"ZADD":                 zadd,
+	"ZRANK":                zrank,
+	"ZRANGE":               zrange,
+	"ZCARD":                zcard,
+	"ZSCORE":               zscore,
+	"ZREM":                 zrem,
+	"GEOADD":               geoadd,
+	"GEOPOS":               geopos,
+	"GEODIST":              geodist,
+	"GEOHASH":              geohashCommand,
+	"GEOSEARCH":            geosearch,
+	"GEOSEARCHSTORE":       geosearchstore,
+	"GEORADIUS":            georadius,
+	"GEORADIUS_RO":         georadiusRO,
+	"GEORADIUSBYMEMBER":    georadiusbymember,
+	"GEORADIUSBYMEMBER_RO": georadiusbymemberRO,
+	"ACL":                  acl,
+	"AUTH":                 authenticate,
+	"FUNCTION":             function,
+	"FCALL":                fcall,
+	"FCALL_RO":             fcallRO,
+}
+
+// Arity is the number of arguments of each command, including its name. A
+// negative arity means that at least that many arguments are required.
+var Arity = map[string]int{
+	"ECHO":                 2,
+	"PING":                 -1,
+	"UNWATCH":              1,
+	"SET":                  -3,
+	"GET":                  2,
+	"CONFIG":               -2,
+	"KEYS":                 2,
+	"FLUSHALL":             -1,
+	"INFO":                 -1,
+	"REPLCONF":             -1,
+	"PSYNC":                -3,
+	"WAIT":                 3,
+	"TYPE":                 2,
+	"XADD":                 -5,
+	"XRANGE":               -4,
+	"XREAD":                -4,
+	"XLEN":                 2,
+	"XDEL":                 -3,
+	"XTRIM":                -4,
+	"XSETID":               -3,
+	"XINFO":                -2,
+	"INCR":                 2,
+	"RPUSH":                -3,
+	"LRANGE":               4,
+	"LPUSH":                -3,
+	"LLEN":                 2,
+	"LPOP":                 -2,
+	"BLPOP":                -3,
+	"PUBLISH":              3,
+	"PUBSUB":               -2,
+	"SPUBLISH":             3,
+	"ZADD":                 -4,
+	"ZRANK":                -3,
+	"ZRANGE":               -4,
+	"ZCARD":                2,
+	"ZSCORE":               3,
+	"ZREM":                 -3,
+	"GEOADD":               -5,
+	"GEOPOS":               -2,
+	"GEODIST":              -4,
+	"GEOHASH":              -2,
+	"GEOSEARCH":            -7,
+	"GEOSEARCHSTORE":       -8,
+	"GEORADIUS":            -6,
+	"GEORADIUS_RO":         -6,
+	"GEORADIUSBYMEMBER":    -5,
+	"GEORADIUSBYMEMBER_RO": -5,
+	"ACL":                  -2,
+	"AUTH":                 -2,
+	"FUNCTION":             -2,
+	"FCALL":                -3,
+	"FCALL_RO":             -3,
+}
+
+// checkArity reports whether a command called with n arguments, including its
+// name, satisfies its arity.
+func checkArity(command string, n int) bool {
+	arity, ok := Arity[command]
+	if !ok {
+		return true
+	}
+
+	if arity < 0 {
+		return n >= -arity
+	}
+
+	return n == arity
+}
+
+// SyntaxChecks validate the options of the commands having some without
+// running them, so that a transaction refuses them when they are queued
+// rather than failing at EXEC. They are given the arguments after the
+// command name, and arity was already checked.
+var SyntaxChecks = map[string]func([]resp.Value) error{
+	"SET": func(args []resp.Value) error {
+		_, err := parseSetExpiry(args)
+		return err
+	},
+	"XADD": func(args []resp.Value) error {
+		_, _, _, err := parseXAddOptions(args[1:])
+		return err
+	},
+	"XTRIM": func(args []resp.Value) error {
+		_, err := parseXTrim(args[1:])
+		return err
+	},
+	"XDEL": func(args []resp.Value) error {
+		_, err := parseStreamIDs(args[1:])
+		return err
+	},
+	"ZADD": func(args []resp.Value) error {
+		if _, err := strconv.ParseFloat(args[1].String(), 64); err != nil {
+			return errors.New("ERR score is not a float or out of range")
+		}
+
+		return nil
+	},
+	"GEOADD": func(args []resp.Value) error {
+		_, err := parseGeoAdd(args[1:])
+		return err
+	},
+	"GEOSEARCH": func(args []resp.Value) error {
+		return parseGeoSearchOptions(args[1:], &geoSearchOptions{}, geoSearchFlag)
+	},
+	"GEOSEARCHSTORE": func(args []resp.Value) error {
+		return parseGeoSearchOptions(args[2:], &geoSearchOptions{}, geoSearchFlag|geoSearchStoreFlag)
+	},
+	"GEORADIUS": func(args []resp.Value) error {
+		_, err := parseGeoRadiusArgs(args, false, geoRadiusStoreFlag)
+		return err
+	},
+	"GEORADIUS_RO": func(args []resp.Value) error {
+		_, err := parseGeoRadiusArgs(args, false, 0)
+		return err
+	},
+	"GEORADIUSBYMEMBER": func(args []resp.Value) error {
+		_, err := parseGeoRadiusArgs(args, true, geoRadiusStoreFlag)
+		return err
+	},
+	"GEORADIUSBYMEMBER_RO": func(args []resp.Value) error {
+		_, err := parseGeoRadiusArgs(args, true, 0)
+		return err
+	},
 }
 
 var (
-	WriteCommands          []string = []string{"SET", "XADD", "INCR", "RPUSH", "LPUSH", "LPOP", "BLPOP"}
-	SubscribedModeCommands []string = []string{"SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "PING", "QUIT"}
+	WriteCommands          []string = []string{"SET", "XADD", "XDEL", "XTRIM", "XSETID", "GEOSEARCHSTORE", "INCR", "RPUSH", "LPUSH", "LPOP", "BLPOP", "ZADD", "ZREM", "GEOADD", "FLUSHALL"}
+	SubscribedModeCommands []string = []string{"SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "SSUBSCRIBE", "SUNSUBSCRIBE", "PING", "QUIT"}
+
+	// TransactionCommands run right away inside MULTI, every other command is
+	// queued, or refused when it is one of NoMultiCommands.
+	TransactionCommands []string = []string{"MULTI", "EXEC", "DISCARD", "WATCH"}
+	NoMultiCommands     []string = []string{"SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "SSUBSCRIBE", "SUNSUBSCRIBE", "SHUTDOWN", "AUTH", "HELLO"}
+
+	// PublishCommands are propagated to replicas so that their subscribers get the
+	// messages too, but unlike WriteCommands they don't touch the keyspace.
+	PublishCommands []string = []string{"PUBLISH", "SPUBLISH"}
 )
 
+// isWriteCommand reports whether the command in args, its name first, changes
+// the keyspace and is propagated to replicas. GEORADIUS and GEORADIUSBYMEMBER
+// only write with their STORE and STOREDIST options, and FUNCTION with the
+// subcommands changing the loaded libraries.
+func isWriteCommand(args []resp.Value) bool {
+	command := strings.ToUpper(args[0].String())
+	switch command {
+	case "FUNCTION":
+		return len(args) > 1 && slices.Contains([]string{"LOAD", "DELETE", "FLUSH", "RESTORE"}, strings.ToUpper(args[1].String()))
+	case "GEORADIUS", "GEORADIUSBYMEMBER":
+		if len(args) < -Arity[command] {
+			return false
+		}
+
+		return slices.ContainsFunc(args[-Arity[command]:], func(arg resp.Value) bool {
+			option := strings.ToUpper(arg.String())
+			return option == "STORE" || option == "STOREDIST"
+		})
+	}
+
+	return slices.Contains(WriteCommands, command)
+}
+
+// KeySpec locates the keys in the arguments of a command, like the first key,
+// last key and step of Redis's command table. A negative last key counts from
+// the end of the arguments.
+type KeySpec struct {
+	first, last, step int
+}
+
+var KeySpecs = map[string]KeySpec{
+	"SET":                  {1, 1, 1},
+	"GET":                  {1, 1, 1},
+	"TYPE":                 {1, 1, 1},
+	"INCR":                 {1, 1, 1},
+	"XADD":                 {1, 1, 1},
+	"XRANGE":               {1, 1, 1},
+	"XLEN":                 {1, 1, 1},
+	"XDEL":                 {1, 1, 1},
+	"XTRIM":                {1, 1, 1},
+	"XSETID":               {1, 1, 1},
+	"RPUSH":                {1, 1, 1},
+	"LRANGE":               {1, 1, 1},
+	"LPUSH":                {1, 1, 1},
+	"LLEN":                 {1, 1, 1},
+	"LPOP":                 {1, 1, 1},
+	"ZADD":                 {1, 1, 1},
+	"ZRANK":                {1, 1, 1},
+	"ZRANGE":               {1, 1, 1},
+	"ZCARD":                {1, 1, 1},
+	"ZSCORE":               {1, 1, 1},
+	"ZREM":                 {1, 1, 1},
+	"GEOADD":               {1, 1, 1},
+	"GEOPOS":               {1, 1, 1},
+	"GEODIST":              {1, 1, 1},
+	"GEOHASH":              {1, 1, 1},
+	"GEOSEARCH":            {1, 1, 1},
+	"GEORADIUS":            {1, 1, 1},
+	"GEORADIUS_RO":         {1, 1, 1},
+	"GEORADIUSBYMEMBER":    {1, 1, 1},
+	"GEORADIUSBYMEMBER_RO": {1, 1, 1},
+	"BLPOP":                {1, -2, 1},
+	"GEOSEARCHSTORE":       {1, 2, 1},
+	"XINFO":                {2, 2, 1},
+}
+
+// commandKeys returns the keys of the command in args, its name first.
+// Commands missing from KeySpecs don't take keys.
+func commandKeys(args []resp.Value) []string {
+	command := strings.ToUpper(args[0].String())
+	var keys []string
+	switch command {
+	case "XREAD":
+		// The keys follow STREAMS, along with as many IDs.
+		for i, arg := range args {
+			if strings.ToUpper(arg.String()) == "STREAMS" {
+				for _, key := range args[i+1 : i+1+(len(args)-i-1)/2] {
+					keys = append(keys, key.String())
+				}
+
+				break
+			}
+		}
+
+		return keys
+	case "GEORADIUS", "GEORADIUSBYMEMBER":
+		// STORE and STOREDIST name the destination key.
+		for i := -Arity[command]; i < len(args)-1; i++ {
+			if option := strings.ToUpper(args[i].String()); option == "STORE" || option == "STOREDIST" {
+				keys = append(keys, args[i+1].String())
+			}
+		}
+	}
+
+	spec, ok := KeySpecs[command]
+	if !ok {
+		return keys
+	}
+
+	last := spec.last
+	if last < 0 {
+		last += len(args)
+	}
+
+	for i := spec.first; i <= last && i < len(args); i += spec.step {
+		keys = append(keys, args[i].String())
+	}
+
+	return keys
+}
+
 func ping(subscribedMode bool) resp.Value {
 	if subscribedMode {
-		pong := resp.Value{Typ: resp.BULK_TYPE, Bulk: "pong"}
-		return resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{pong, {Typ: resp.BULK_TYPE}}}
+		pong := resp.Bulk("pong")
+		return resp.Array(pong, resp.Bulk(""))
 	}
 
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "PONG"}
+	return resp.Simple("PONG")
+}
+
+// pong is PING run from a transaction or a function, where there is no
+// subscribed mode.
+func pong(args []resp.Value) resp.Value {
+	return ping(false)
 }
 
 func echo(args []resp.Value) resp.Value {
 	if len(args) != 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of args for 'echo' command"}
+		return resp.Err("ERR wrong number of args for 'echo' command")
 	}
 
-	return resp.Value{Typ: resp.BULK_TYPE, Bulk: args[0].Bulk}
+	return resp.Bulk(args[0].String())
 }
 
 var SETs = map[string]string{}
 var SETsMu = sync.RWMutex{}
 
+// SETsExpiry holds when the keys of SETs set with EX or PX expire, so that
+// their expiry can be saved along with them. It is guarded by SETsMu.
+var SETsExpiry = map[string]time.Time{}
+
 func set(args []resp.Value) resp.Value {
-	if len(args) != 2 && len(args) != 4 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of args for 'set' command"}
+	ttl, err := parseSetExpiry(args)
+	if err != nil {
+		return resp.Err(err.Error())
 	}
 
-	key := args[0].Bulk
-	value := args[1].Bulk
+	key := args[0].String()
+	value := args[1].String()
 	SETsMu.Lock()
 	defer SETsMu.Unlock()
 
 	SETs[key] = value
+	delete(SETsExpiry, key)
+	touchKey(key)
 	if len(args) == 4 {
-		var unit time.Duration
-		switch strings.ToUpper(args[2].Bulk) {
-		case "EX":
-			unit = time.Second
-		case "PX":
-			unit = time.Millisecond
-		default:
-			return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR syntax error"}
-		}
-
-		i64, err := strconv.ParseInt(args[3].Bulk, 10, 64)
-		if err != nil {
-			return resp.Value{Typ: resp.ERROR_TYPE, Str: "value is not an integer or out of range"}
-		}
-
+		deadline := time.Now().Add(ttl)
+		SETsExpiry[key] = deadline
 		go func() {
-			time.Sleep(unit * time.Duration(i64))
-			unset(key)
+			time.Sleep(ttl)
+			expire(key, deadline)
 		}()
 	}
 
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "OK"}
+	return resp.OK()
+}
+
+// parseSetExpiry returns the time to live given to SET with EX or PX, before
+// anything is written.
+func parseSetExpiry(args []resp.Value) (time.Duration, error) {
+	if len(args) != 2 && len(args) != 4 {
+		return 0, errors.New("ERR wrong number of args for 'set' command")
+	}
+
+	if len(args) == 2 {
+		return 0, nil
+	}
+
+	var unit time.Duration
+	switch strings.ToUpper(args[2].String()) {
+	case "EX":
+		unit = time.Second
+	case "PX":
+		unit = time.Millisecond
+	default:
+		return 0, errors.New("ERR syntax error")
+	}
+
+	i64, err := strconv.ParseInt(args[3].String(), 10, 64)
+	if err != nil {
+		return 0, errors.New("value is not an integer or out of range")
+	}
+
+	return unit * time.Duration(i64), nil
 }
 
-func unset(key string) {
+// expire deletes key if it still expires at deadline. A key set again since,
+// without an expiry or with another one, is left alone. Like any command, it
+// takes the keyspace lock so that it can't interleave with a transaction.
+func expire(key string, deadline time.Time) {
+	keyspace.RLock()
+	defer keyspace.RUnlock()
+
 	SETsMu.Lock()
+	if at, ok := SETsExpiry[key]; !ok || !at.Equal(deadline) {
+		SETsMu.Unlock()
+		return
+	}
+
 	delete(SETs, key)
+	delete(SETsExpiry, key)
 	SETsMu.Unlock()
+	touchKey(key)
 }
 
 func get(args []resp.Value) resp.Value {
 	if len(args) != 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of args for 'get' command"}
+		return resp.Err("ERR wrong number of args for 'get' command")
 	}
 
-	key := args[0].Bulk
+	key := args[0].String()
 	SETsMu.RLock()
 	val, ok := SETs[key]
 	SETsMu.RUnlock()
 
 	if !ok {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
-	return resp.Value{Typ: resp.BULK_TYPE, Bulk: val}
+	return resp.Bulk(val)
 }
 
 func config(args []resp.Value) resp.Value {
 	if len(args) != 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'config' command"}
+		return resp.Err("ERR wrong number of arguments for 'config' command")
 	}
 
-	switch args[0].Bulk {
+	switch args[0].String() {
 	case "GET":
 		return configGet(args[1:])
 	default:
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: fmt.Sprintf("ERR unknown subcommand '%v'", args[0].Bulk)}
+		return resp.Errf("ERR unknown subcommand '%v'", args[0].String())
 	}
 }
 
 func configGet(args []resp.Value) resp.Value {
-	key := args[0].Bulk
+	key := args[0].String()
 	value, ok := server.configs[key]
 
-	ret := resp.Value{Typ: resp.ARRAY_TYPE}
+	ret := resp.Map()
 	if ok {
-		ret.Array = append(ret.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: key}, resp.Value{Typ: resp.BULK_TYPE, Bulk: value})
+		ret.Array = append(ret.Array, resp.Bulk(key), resp.Bulk(value))
 	}
 
 	return ret
@@ -164,85 +475,113 @@ func configGet(args []resp.Value) resp.Value {
 
 func keys(args []resp.Value) resp.Value {
 	if len(args) != 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'keys' command"}
+		return resp.Err("ERR wrong number of arguments for 'keys' command")
 	}
 
-	ret := resp.Value{Typ: resp.ARRAY_TYPE}
+	ret := resp.Array()
 	SETsMu.RLock()
 	for key := range SETs {
-		ret.Array = append(ret.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: key})
+		ret.Array = append(ret.Array, resp.Bulk(key))
 	}
 	SETsMu.RUnlock()
 
 	return ret
 }
 
+func flushall(args []resp.Value) resp.Value {
+	if len(args) > 1 {
+		return resp.Err("ERR wrong number of arguments for 'flushall' command")
+	}
+
+	if len(args) == 1 {
+		mode := strings.ToUpper(args[0].String())
+		if mode != "SYNC" && mode != "ASYNC" {
+			return resp.Err("ERR syntax error")
+		}
+	}
+
+	SETsMu.Lock()
+	clear(SETs)
+	clear(SETsExpiry)
+	SETsMu.Unlock()
+
+	streams.mu.Lock()
+	clear(streams.entries)
+	streams.mu.Unlock()
+
+	lists.mu.Lock()
+	clear(lists.lists)
+	lists.mu.Unlock()
+
+	setsmu.Lock()
+	clear(sets)
+	setsmu.Unlock()
+
+	touchAllKeys()
+	return resp.OK()
+}
+
 func info(args []resp.Value) resp.Value {
 	if len(args) != 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'info' command"}
+		return resp.Err("ERR wrong number of arguments for 'info' command")
 	}
 
-	switch strings.ToUpper(args[0].Bulk) {
+	switch strings.ToUpper(args[0].String()) {
 	case "REPLICATION":
 		return infoReplication()
 	default:
-		return resp.Value{Typ: resp.BULK_TYPE}
+		return resp.Bulk("")
 	}
 }
 
 func infoReplication() resp.Value {
-	ret := resp.Value{Typ: resp.BULK_TYPE}
-	replicaof := server.replconf.host
-
-	if replicaof != "" {
-		ret.Bulk = "role:slave\r\n"
-	} else {
-		ret.Bulk = "role:master\r\nmaster_replid:8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb\r\nmaster_repl_offset:0\r\n"
+	if server.replconf.host != "" {
+		return resp.Bulk("role:slave\r\n")
 	}
 
-	return ret
+	return resp.Bulk("role:master\r\nmaster_replid:8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb\r\nmaster_repl_offset:0\r\n")
 }
 
 func replconf(args []resp.Value) resp.Value {
-	switch strings.ToUpper(args[0].Bulk) {
+	switch strings.ToUpper(args[0].String()) {
 	case "GETACK":
 		return replconfgetack(args[1:])
 	case "ACK":
 		return resp.Value{}
 	default:
-		return resp.Value{Typ: resp.STRING_TYPE, Str: "OK"}
+		return resp.OK()
 	}
 }
 
 func replconfgetack(args []resp.Value) resp.Value {
-	if args[0].Bulk != "*" {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "Invalid GETACK parameter"}
+	if args[0].String() != "*" {
+		return resp.Err("Invalid GETACK parameter")
 	}
 
 	offset := server.offset
-	for _, slave := range server.slaves {
+	for _, slave := range server.replicas() {
 		offset += slave.offset
 	}
 
-	ret := resp.Value{Typ: resp.ARRAY_TYPE}
-	ret.Array = append(ret.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: "REPLCONF"}, resp.Value{Typ: resp.BULK_TYPE, Bulk: "ACK"}, resp.Value{Typ: resp.BULK_TYPE, Bulk: strconv.Itoa(offset)})
+	ret := resp.Array()
+	ret.Array = append(ret.Array, resp.Bulk("REPLCONF"), resp.Bulk("ACK"), resp.Bulk(strconv.Itoa(offset)))
 	return ret
 }
 
 func psync(args []resp.Value) resp.Value {
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0"}
+	return resp.Simple("FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0")
 }
 
 func wait(args []resp.Value) resp.Value {
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: len(server.slaves)}
+	return resp.Int(len(server.replicas()))
 }
 
 func typ(args []resp.Value) resp.Value {
 	if len(args) != 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'type' command"}
+		return resp.Err("ERR wrong number of arguments for 'type' command")
 	}
 
-	key := args[0].Bulk
+	key := args[0].String()
 	SETsMu.RLock()
 	_, isString := SETs[key]
 	SETsMu.RUnlock()
@@ -252,86 +591,313 @@ func typ(args []resp.Value) resp.Value {
 	streams.mu.RUnlock()
 
 	if isString {
-		return resp.Value{Typ: resp.STRING_TYPE, Str: "string"}
+		return resp.Simple("string")
 	} else if isStream {
-		return resp.Value{Typ: resp.STRING_TYPE, Str: "stream"}
+		return resp.Simple("stream")
 	}
 
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "none"}
+	return resp.Simple("none")
 }
 
 type Streams struct {
-	entries     map[string]Stream
-	mu          sync.RWMutex
-	change      chan struct{}
-	trackChange bool
+	entries map[string]Stream
+	mu      sync.RWMutex
+	waiters map[string][]chan struct{}
 }
 
+const streamNodeMaxEntries = 100
+
 type Stream struct {
-	last    string
-	entries []StreamEntry
+	last         string
+	maxDeleted   string
+	entriesAdded int
+	entries      []StreamEntry
+	master       []string
+	masterCount  int
 }
 
+// StreamEntry keeps its fields in the order they were given to XADD. Entries
+// whose field names match the master entry of their block share its slice.
 type StreamEntry struct {
-	id   string
-	KVPs map[string]string
+	id     string
+	fields []string
+	values []string
 }
 
 var streams Streams = Streams{
-	entries:     make(map[string]Stream),
-	mu:          sync.RWMutex{},
-	change:      make(chan struct{}),
-	trackChange: false,
+	entries: make(map[string]Stream),
+	mu:      sync.RWMutex{},
+	waiters: make(map[string][]chan struct{}),
+}
+
+func newStream() Stream {
+	return Stream{
+		last:       "0-0",
+		maxDeleted: "0-0",
+		entries:    make([]StreamEntry, 0),
+	}
 }
 
 func xadd(args []resp.Value) resp.Value {
-	if len(args) < 4 || len(args)%2 != 0 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'xadd' command"}
+	if len(args) < 4 {
+		return resp.Err("ERR wrong number of arguments for 'xadd' command")
 	}
 
-	streamKey := args[0].Bulk
-	streams.mu.RLock()
-	stream, ok := streams.entries[streamKey]
-	streams.mu.RUnlock()
+	streamKey := args[0].String()
+	noMkStream, trim, fields, err := parseXAddOptions(args[1:])
+	if err != nil {
+		return resp.Err(err.Error())
+	}
 
+	streams.mu.Lock()
+	stream, ok := streams.entries[streamKey]
 	if !ok {
-		stream = Stream{
-			last:    "0-0",
-			entries: make([]StreamEntry, 0),
+		if noMkStream {
+			streams.mu.Unlock()
+			return resp.Null()
 		}
+
+		stream = newStream()
 	}
 
-	newStreamEntryID := tryGenarateStreamEntryId(args[1].Bulk, stream)
+	newStreamEntryID := tryGenarateStreamEntryId(fields[0].String(), stream)
+	if _, _, err := parseStreamID(newStreamEntryID); err != nil {
+		streams.mu.Unlock()
+		return resp.Err(err.Error())
+	}
 
 	if isLessThanOrEqual(newStreamEntryID, stream.last) {
+		streams.mu.Unlock()
 		if isLessThanOrEqual(newStreamEntryID, "0-0") {
-			return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR The ID specified in XADD must be greater than 0-0"}
+			return resp.Err("ERR The ID specified in XADD must be greater than 0-0")
 		} else {
-			return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR The ID specified in XADD is equal or smaller than the target stream top item"}
+			return resp.Err("ERR The ID specified in XADD is equal or smaller than the target stream top item")
 		}
 	}
 
 	entry := StreamEntry{
-		id:   newStreamEntryID,
-		KVPs: make(map[string]string),
+		id:     newStreamEntryID,
+		fields: make([]string, 0, len(fields)/2),
+		values: make([]string, 0, len(fields)/2),
 	}
 
-	for i := 2; i < len(args); i += 2 {
-		entry.KVPs[args[i].Bulk] = args[i+1].Bulk
+	for i := 1; i < len(fields); i += 2 {
+		entry.fields = append(entry.fields, fields[i].String())
+		entry.values = append(entry.values, fields[i+1].String())
 	}
 
-	stream.entries = append(stream.entries, entry)
+	stream.addEntry(entry)
 	stream.last = newStreamEntryID
+	stream.entriesAdded++
+
+	if trim != nil {
+		stream.trim(*trim)
+	}
 
-	streams.mu.Lock()
 	streams.entries[streamKey] = stream
+	streams.notify(streamKey)
+	touchKey(streamKey)
 	streams.mu.Unlock()
 
-	if streams.trackChange {
-		streams.change <- struct{}{}
+	return resp.Bulk(entry.id)
+}
+
+// parseXAddOptions parses the NOMKSTREAM, MAXLEN and MINID options of XADD,
+// given the arguments after the key, and returns the ID and fields following
+// them.
+func parseXAddOptions(args []resp.Value) (noMkStream bool, trim *StreamTrim, fields []resp.Value, err error) {
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].String())
+		if option == "NOMKSTREAM" {
+			noMkStream = true
+			continue
+		}
+
+		if option != "MAXLEN" && option != "MINID" {
+			break
+		}
+
+		t, n, err := parseStreamTrim(args[i:])
+		if err != nil {
+			return false, nil, nil, err
+		}
+
+		trim = &t
+		i += n - 1
+	}
+
+	fields = args[i:]
+	if len(fields) < 3 || len(fields)%2 != 1 {
+		return false, nil, nil, errors.New("ERR wrong number of arguments for 'xadd' command")
+	}
+
+	return noMkStream, trim, fields, nil
+}
+
+// addEntry appends entry to the stream, reusing the field names of the current
+// block's master entry when they are identical, like Redis's listpack nodes.
+func (stream *Stream) addEntry(entry StreamEntry) {
+	if stream.masterCount == 0 || stream.masterCount >= streamNodeMaxEntries {
+		stream.master = entry.fields
+		stream.masterCount = 0
+	} else if slices.Equal(stream.master, entry.fields) {
+		entry.fields = stream.master
+	}
+
+	stream.masterCount++
+	stream.entries = append(stream.entries, entry)
+}
+
+// blockStart returns the index of the first entry of the current block.
+func (stream *Stream) blockStart() int {
+	return len(stream.entries) - stream.masterCount
+}
+
+// removedFromBlock accounts for n entries of the current block being deleted.
+// Once the block is empty its master fields are dropped, so that the next
+// entry starts a new block with its own fields.
+func (stream *Stream) removedFromBlock(n int) {
+	stream.masterCount -= n
+	if stream.masterCount <= 0 {
+		stream.master = nil
+		stream.masterCount = 0
+	}
+}
+
+// StreamTrim describes the MAXLEN / MINID trimming options shared by XADD and XTRIM.
+type StreamTrim struct {
+	strategy string
+	approx   bool
+	maxLen   int
+	minID    string
+	limit    int
+	hasLimit bool
+}
+
+// parseStreamTrim parses "MAXLEN|MINID [=|~] threshold [LIMIT count]" and returns
+// the number of arguments it consumed.
+func parseStreamTrim(args []resp.Value) (StreamTrim, int, error) {
+	trim := StreamTrim{strategy: strings.ToUpper(args[0].String())}
+	i := 1
+
+	if i < len(args) && (args[i].String() == "=" || args[i].String() == "~") {
+		trim.approx = args[i].String() == "~"
+		i++
+	}
+
+	if i >= len(args) {
+		return trim, 0, errors.New("ERR syntax error")
+	}
+
+	threshold := args[i].String()
+	i++
+
+	switch trim.strategy {
+	case "MAXLEN":
+		maxLen, err := strconv.Atoi(threshold)
+		if err != nil {
+			return trim, 0, errors.New("ERR value is not an integer or out of range")
+		}
+
+		if maxLen < 0 {
+			return trim, 0, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+
+		trim.maxLen = maxLen
+	case "MINID":
+		ms, seq, err := parseStreamID(threshold)
+		if err != nil {
+			return trim, 0, err
+		}
+
+		trim.minID = formatStreamID(ms, seq)
+	default:
+		return trim, 0, errors.New("ERR syntax error")
+	}
+
+	if i+1 < len(args) && strings.ToUpper(args[i].String()) == "LIMIT" {
+		limit, err := strconv.Atoi(args[i+1].String())
+		if err != nil {
+			return trim, 0, errors.New("ERR value is not an integer or out of range")
+		}
+
+		if limit < 0 {
+			return trim, 0, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+
+		if !trim.approx {
+			return trim, 0, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+
+		trim.limit = limit
+		trim.hasLimit = true
+		i += 2
+	}
+
+	return trim, i, nil
+}
+
+// trim evicts the oldest entries according to t and returns how many were removed.
+// Entries are stored flat, so approximate trimming is exact apart from honoring LIMIT.
+func (stream *Stream) trim(t StreamTrim) int {
+	n := 0
+	switch t.strategy {
+	case "MAXLEN":
+		n = max(len(stream.entries)-t.maxLen, 0)
+	case "MINID":
+		for n < len(stream.entries) && !isLessThanOrEqual(t.minID, stream.entries[n].id) {
+			n++
+		}
+	}
+
+	if t.hasLimit && t.limit > 0 {
+		n = min(n, t.limit)
+	}
+
+	stream.removedFromBlock(max(n-stream.blockStart(), 0))
+	stream.entries = stream.entries[n:]
+	return n
+}
+
+func parseStreamID(id string) (int64, int64, error) {
+	invalid := errors.New("ERR Invalid stream ID specified as stream command argument")
+
+	msPart, seqPart, hasSeq := strings.Cut(id, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil || ms > math.MaxInt64 {
+		return 0, 0, invalid
+	}
+
+	if !hasSeq {
+		return int64(ms), 0, nil
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil || seq > math.MaxInt64 {
+		return 0, 0, invalid
+	}
+
+	return int64(ms), int64(seq), nil
+}
+
+// parseStreamIDs parses the IDs given to XDEL into their canonical form.
+func parseStreamIDs(args []resp.Value) ([]string, error) {
+	ids := make([]string, 0, len(args))
+	for _, arg := range args {
+		ms, seq, err := parseStreamID(arg.String())
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, formatStreamID(ms, seq))
 	}
 
-	return resp.Value{Typ: resp.BULK_TYPE, Bulk: entry.id}
+	return ids, nil
+}
+
+func formatStreamID(ms, seq int64) string {
+	return strconv.FormatInt(ms, 10) + "-" + strconv.FormatInt(seq, 10)
 }
 
 func tryGenarateStreamEntryId(input string, stream Stream) string {
@@ -382,40 +948,35 @@ func isLessThanOrEqual(id1, id2 string) bool {
 
 func xrange(args []resp.Value) resp.Value {
 	if len(args) != 3 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'xrange' command"}
+		return resp.Err("ERR wrong number of arguments for 'xrange' command")
 	}
 
-	ret := resp.Value{Typ: resp.ARRAY_TYPE}
-	streamKey := args[0].Bulk
+	ret := resp.Array()
+	streamKey := args[0].String()
+	// XDEL and XTRIM compact the entries in place, so they must not run
+	// while the shared array is being walked.
 	streams.mu.RLock()
-	stream, ok := streams.entries[streamKey]
-	streams.mu.RUnlock()
+	defer streams.mu.RUnlock()
 
+	stream, ok := streams.entries[streamKey]
 	if !ok {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
 
-	startVal, startSeq := xrangeFormatArgs(args[1].Bulk, stream)
-	endVal, endSeq := xrangeFormatArgs(args[2].Bulk, stream)
+	startVal, startSeq := xrangeFormatArgs(args[1].String(), stream)
+	endVal, endSeq := xrangeFormatArgs(args[2].String(), stream)
 
 	for _, entry := range stream.entries {
 		entryId := strings.Split(entry.id, "-")
 		seq, _ := strconv.ParseInt(entryId[1], 10, 64)
 
 		if (entryId[0] > startVal || (entryId[0] == startVal && seq >= startSeq)) && (entryId[0] < endVal || (entryId[0] == endVal && seq <= endSeq)) {
-			valsArr := resp.Value{Typ: resp.ARRAY_TYPE}
-			for key, value := range entry.KVPs {
-				valsArr.Array = append(valsArr.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: key}, resp.Value{Typ: resp.BULK_TYPE, Bulk: value})
-			}
-
-			respEntry := resp.Value{Typ: resp.ARRAY_TYPE}
-			respEntry.Array = append(respEntry.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: entry.id}, valsArr)
-			ret.Array = append(ret.Array, respEntry)
+			ret.Array = append(ret.Array, streamEntryToValue(entry))
 		}
 	}
 
 	if len(ret.Array) == 0 {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
 
 	return ret
@@ -440,168 +1001,669 @@ func xrangeFormatArgs(arg string, stream Stream) (string, int64) {
 	return val, seq
 }
 
+type xreadStream struct {
+	key       string
+	id        string
+	lastEntry bool
+}
+
 func xread(args []resp.Value) resp.Value {
-	searchData := args[1:]
-	var blockDuration int = -1
-	if args[0].Bulk == "block" {
-		blockDuration, _ = strconv.Atoi(args[1].Bulk)
-		searchData = args[3:]
+	count := 0
+	block := time.Duration(-1)
+
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].String())
+		if option == "STREAMS" {
+			break
+		}
+
+		if i+1 >= len(args) {
+			return resp.Err("ERR syntax error")
+		}
+
+		switch option {
+		case "COUNT":
+			n, err := strconv.Atoi(args[i+1].String())
+			if err != nil {
+				return resp.Err("ERR value is not an integer or out of range")
+			}
+
+			count = max(n, 0)
+		case "BLOCK":
+			ms, err := strconv.ParseInt(args[i+1].String(), 10, 64)
+			if err != nil {
+				return resp.Err("ERR timeout is not an integer or out of range")
+			}
+
+			if ms < 0 {
+				return resp.Err("ERR timeout is negative")
+			}
+
+			block = time.Duration(ms) * time.Millisecond
+		default:
+			return resp.Err("ERR syntax error")
+		}
+
+		i++
+	}
+
+	searchData := args[min(i+1, len(args)):]
+	if i >= len(args) || len(searchData) == 0 {
+		return resp.Err("ERR wrong number of arguments for 'xread' command")
+	}
+
+	if len(searchData)%2 != 0 {
+		return resp.Err("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
 	}
 
 	median := len(searchData) / 2
-	streamKeys := make([]string, 0)
-
-	for i := 0; i+median < len(searchData); i++ {
-		streamKeys = append(streamKeys, searchData[i].Bulk)
-		if searchData[i+median].Bulk == "$" {
-			streams.mu.RLock()
-			stream, ok := streams.entries[searchData[i].Bulk]
-			streams.mu.RUnlock()
-			if ok {
-				searchData[median+i].Bulk = stream.last
-			} else {
-				searchData[median+i].Bulk = "0-0"
+	targets := make([]xreadStream, median)
+
+	streams.mu.RLock()
+	for i := range targets {
+		key, id := searchData[i].String(), searchData[median+i].String()
+		stream, ok := streams.entries[key]
+		if !ok {
+			stream = newStream()
+		}
+
+		targets[i].key = key
+		switch id {
+		case "$":
+			targets[i].id = stream.last
+		case "+":
+			targets[i].id = stream.last
+			targets[i].lastEntry = len(stream.entries) > 0
+		default:
+			ms, seq, err := parseStreamID(id)
+			if err != nil {
+				streams.mu.RUnlock()
+				return resp.Err(err.Error())
 			}
+
+			targets[i].id = formatStreamID(ms, seq)
 		}
 	}
+	streams.mu.RUnlock()
 
-	if blockDuration == 0 {
-		streams.trackChange = true
-		<-streams.change
-		streams.trackChange = false
+	var timeout <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
-	time.Sleep(time.Duration(blockDuration) * time.Millisecond)
+	for {
+		streams.mu.Lock()
+		ret := readStreams(targets, count)
+		if len(ret.Array) > 0 || block < 0 || keyspace.exclusive {
+			streams.mu.Unlock()
+			if len(ret.Array) == 0 {
+				return resp.NullArray()
+			}
+
+			return ret
+		}
 
-	ret := resp.Value{Typ: resp.ARRAY_TYPE}
-	if len(streamKeys) == 0 {
-		return resp.Value{Typ: resp.NULL_TYPE}
-	}
+		woken := make(chan struct{}, 1)
+		for _, target := range targets {
+			streams.waiters[target.key] = append(streams.waiters[target.key], woken)
+		}
+		streams.mu.Unlock()
 
-	foundEntry := false
-	for i, streamKey := range streamKeys {
-		if median+i >= len(searchData) {
-			return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."}
+		timedOut := waitUnlocked(woken, timeout)
+		streams.removeWaiter(targets, woken)
+		if timedOut {
+			return resp.NullArray()
 		}
+	}
+}
 
-		streams.mu.RLock()
-		stream, ok := streams.entries[streamKey]
-		streams.mu.RUnlock()
-		if !ok {
+// waitUnlocked releases the shared keyspace lock taken by ExecuteCommand while
+// a blocking command waits, so that it doesn't hold back transactions. It
+// reports whether the timeout fired before the command was woken.
+func waitUnlocked(woken <-chan struct{}, timeout <-chan time.Time) bool {
+	keyspace.RUnlock()
+	defer keyspace.RLock()
+
+	select {
+	case <-woken:
+		return false
+	case <-timeout:
+		return true
+	}
+}
+
+// readStreams collects the entries newer than each target's ID, leaving out
+// streams that have nothing new. The caller must hold streams.mu.
+func readStreams(targets []xreadStream, count int) resp.Value {
+	ret := resp.Array()
+	for _, target := range targets {
+		stream, ok := streams.entries[target.key]
+		if !ok || len(stream.entries) == 0 {
 			continue
 		}
 
-		startVal, startSeq := xrangeFormatArgs(searchData[median+i].Bulk, stream)
-		respStream := resp.Value{Typ: resp.ARRAY_TYPE}
-		respStream.Array = append(respStream.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: streamKey})
-
-		for _, entry := range stream.entries {
-			entryId := strings.Split(entry.id, "-")
-			seq, _ := strconv.ParseInt(entryId[1], 10, 64)
+		var entries []StreamEntry
+		if target.lastEntry {
+			entries = stream.entries[len(stream.entries)-1:]
+		} else {
+			start := sort.Search(len(stream.entries), func(i int) bool {
+				return !isLessThanOrEqual(stream.entries[i].id, target.id)
+			})
+			entries = stream.entries[start:]
+		}
 
-			if entryId[0] > startVal || (entryId[0] == startVal && seq > startSeq) {
-				respEntries := resp.Value{Typ: resp.ARRAY_TYPE}
-				respEntry := resp.Value{Typ: resp.ARRAY_TYPE}
-				respEntry.Array = append(respEntry.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: entry.id})
+		if count > 0 && len(entries) > count {
+			entries = entries[:count]
+		}
 
-				respKVPs := resp.Value{Typ: resp.ARRAY_TYPE}
-				for key, value := range entry.KVPs {
-					respKVPs.Array = append(respKVPs.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: key}, resp.Value{Typ: resp.BULK_TYPE, Bulk: value})
-				}
+		if len(entries) == 0 {
+			continue
+		}
 
-				respEntry.Array = append(respEntry.Array, respKVPs)
-				respEntries.Array = append(respEntries.Array, respEntry)
-				respStream.Array = append(respStream.Array, respEntries)
-				foundEntry = true
-			}
+		respEntries := resp.Array()
+		for _, entry := range entries {
+			respEntries.Array = append(respEntries.Array, streamEntryToValue(entry))
 		}
 
+		respStream := resp.Array(resp.Bulk(target.key), respEntries)
 		ret.Array = append(ret.Array, respStream)
 	}
 
-	if !foundEntry {
-		return resp.Value{Typ: resp.NULL_ARRAY}
-	}
-
 	return ret
 }
 
-func incr(args []resp.Value) resp.Value {
-	if len(args) != 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'incr' command"}
+// notify wakes every XREAD blocked on key. The caller must hold streams.mu.
+func (s *Streams) notify(key string) {
+	for _, waiter := range s.waiters[key] {
+		select {
+		case waiter <- struct{}{}:
+		default:
+		}
 	}
 
-	key := args[0].Bulk
-	SETsMu.RLock()
-	val, ok := SETs[key]
-	SETsMu.RUnlock()
+	delete(s.waiters, key)
+}
 
-	if !ok {
+func (s *Streams) removeWaiter(targets []xreadStream, waiter chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, target := range targets {
+		waiters := slices.DeleteFunc(s.waiters[target.key], func(w chan struct{}) bool { return w == waiter })
+		if len(waiters) == 0 {
+			delete(s.waiters, target.key)
+		} else {
+			s.waiters[target.key] = waiters
+		}
+	}
+}
+
+func xlen(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.Err("ERR wrong number of arguments for 'xlen' command")
+	}
+
+	streams.mu.RLock()
+	stream := streams.entries[args[0].String()]
+	streams.mu.RUnlock()
+
+	return resp.Int(len(stream.entries))
+}
+
+func xdel(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.Err("ERR wrong number of arguments for 'xdel' command")
+	}
+
+	ids, err := parseStreamIDs(args[1:])
+	if err != nil {
+		return resp.Err(err.Error())
+	}
+
+	streamKey := args[0].String()
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+
+	stream, ok := streams.entries[streamKey]
+	if !ok {
+		return resp.Int(0)
+	}
+
+	deleted, deletedFromBlock := 0, 0
+	blockStart := stream.blockStart()
+	kept := stream.entries[:0]
+	for i, entry := range stream.entries {
+		if !slices.Contains(ids, entry.id) {
+			kept = append(kept, entry)
+			continue
+		}
+
+		if !isLessThanOrEqual(entry.id, stream.maxDeleted) {
+			stream.maxDeleted = entry.id
+		}
+
+		deleted++
+		if i >= blockStart {
+			deletedFromBlock++
+		}
+	}
+
+	clear(stream.entries[len(kept):])
+	stream.entries = kept
+	stream.removedFromBlock(deletedFromBlock)
+
+	streams.entries[streamKey] = stream
+	if deleted > 0 {
+		touchKey(streamKey)
+	}
+
+	return resp.Int(deleted)
+}
+
+func xtrim(args []resp.Value) resp.Value {
+	if len(args) < 3 {
+		return resp.Err("ERR wrong number of arguments for 'xtrim' command")
+	}
+
+	trim, err := parseXTrim(args[1:])
+	if err != nil {
+		return resp.Err(err.Error())
+	}
+
+	streamKey := args[0].String()
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+
+	stream, ok := streams.entries[streamKey]
+	if !ok {
+		return resp.Int(0)
+	}
+
+	trimmed := stream.trim(trim)
+	streams.entries[streamKey] = stream
+	if trimmed > 0 {
+		touchKey(streamKey)
+	}
+
+	return resp.Int(trimmed)
+}
+
+// parseXTrim parses the arguments of XTRIM after the key, which must all be
+// part of the trimming options.
+func parseXTrim(args []resp.Value) (StreamTrim, error) {
+	strategy := strings.ToUpper(args[0].String())
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return StreamTrim{}, errors.New("ERR syntax error")
+	}
+
+	trim, n, err := parseStreamTrim(args)
+	if err != nil {
+		return trim, err
+	}
+
+	if n != len(args) {
+		return trim, errors.New("ERR syntax error")
+	}
+
+	return trim, nil
+}
+
+func xsetid(args []resp.Value) resp.Value {
+	if len(args) != 2 && len(args) != 4 && len(args) != 6 {
+		return resp.Err("ERR wrong number of arguments for 'xsetid' command")
+	}
+
+	ms, seq, err := parseStreamID(args[1].String())
+	if err != nil {
+		return resp.Err(err.Error())
+	}
+
+	lastID := formatStreamID(ms, seq)
+	entriesAdded := -1
+	maxDeleted := ""
+
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i].String()) {
+		case "ENTRIESADDED":
+			entriesAdded, err = strconv.Atoi(args[i+1].String())
+			if err != nil {
+				return resp.Err("ERR value is not an integer or out of range")
+			}
+
+			if entriesAdded < 0 {
+				return resp.Err("ERR entries_added must be positive")
+			}
+		case "MAXDELETEDID":
+			ms, seq, err := parseStreamID(args[i+1].String())
+			if err != nil {
+				return resp.Err(err.Error())
+			}
+
+			maxDeleted = formatStreamID(ms, seq)
+			if !isLessThanOrEqual(maxDeleted, lastID) {
+				return resp.Err("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+		default:
+			return resp.Err("ERR syntax error")
+		}
+	}
+
+	streamKey := args[0].String()
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+
+	stream, ok := streams.entries[streamKey]
+	if !ok {
+		return resp.Err("ERR no such key")
+	}
+
+	if entriesAdded != -1 && len(stream.entries) > entriesAdded {
+		return resp.Err("ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+
+	if len(stream.entries) > 0 && !isLessThanOrEqual(stream.entries[len(stream.entries)-1].id, lastID) {
+		return resp.Err("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+
+	stream.last = lastID
+	if entriesAdded != -1 {
+		stream.entriesAdded = entriesAdded
+	}
+
+	if maxDeleted != "" {
+		stream.maxDeleted = maxDeleted
+	}
+
+	streams.entries[streamKey] = stream
+	touchKey(streamKey)
+	return resp.OK()
+}
+
+func xinfo(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Err("ERR wrong number of arguments for 'xinfo' command")
+	}
+
+	subcommand := strings.ToUpper(args[0].String())
+	switch subcommand {
+	case "STREAM":
+		return xinfoStream(args[1:])
+	case "GROUPS":
+		if len(args) != 2 {
+			return resp.Err("ERR wrong number of arguments for 'xinfo|groups' command")
+		}
+
+		return xinfoGroups(args[1].String())
+	case "CONSUMERS":
+		if len(args) != 3 {
+			return resp.Err("ERR wrong number of arguments for 'xinfo|consumers' command")
+		}
+
+		return xinfoConsumers(args[1].String(), args[2].String())
+	default:
+		return resp.Errf("ERR unknown subcommand '%v'. Try XINFO HELP.", args[0].String())
+	}
+}
+
+func xinfoStream(args []resp.Value) resp.Value {
+	if len(args) != 1 && len(args) != 2 && len(args) != 4 {
+		return resp.Err("ERR wrong number of arguments for 'xinfo|stream' command")
+	}
+
+	full := false
+	count := 10
+	if len(args) > 1 {
+		if strings.ToUpper(args[1].String()) != "FULL" {
+			return resp.Err("ERR syntax error")
+		}
+
+		full = true
+		if len(args) == 4 {
+			if strings.ToUpper(args[2].String()) != "COUNT" {
+				return resp.Err("ERR syntax error")
+			}
+
+			var err error
+			if count, err = strconv.Atoi(args[3].String()); err != nil {
+				return resp.Err("ERR value is not an integer or out of range")
+			}
+		}
+	}
+
+	streams.mu.RLock()
+	defer streams.mu.RUnlock()
+
+	stream, ok := streams.entries[args[0].String()]
+	if !ok {
+		return resp.Err("ERR no such key")
+	}
+
+	radixKeys := (len(stream.entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	firstID := "0-0"
+	if len(stream.entries) > 0 {
+		firstID = stream.entries[0].id
+	}
+
+	ret := resp.Map()
+	ret.Array = append(ret.Array,
+		resp.Bulk("length"), resp.Int(len(stream.entries)),
+		resp.Bulk("radix-tree-keys"), resp.Int(radixKeys),
+		resp.Bulk("radix-tree-nodes"), resp.Int(radixKeys+1),
+		resp.Bulk("last-generated-id"), resp.Bulk(stream.last),
+		resp.Bulk("max-deleted-entry-id"), resp.Bulk(stream.maxDeleted),
+		resp.Bulk("entries-added"), resp.Int(stream.entriesAdded),
+		resp.Bulk("recorded-first-entry-id"), resp.Bulk(firstID),
+	)
+
+	if full {
+		entries := stream.entries
+		if count > 0 && count < len(entries) {
+			entries = entries[:count]
+		}
+
+		respEntries := resp.Array()
+		for _, entry := range entries {
+			respEntries.Array = append(respEntries.Array, streamEntryToValue(entry))
+		}
+
+		ret.Array = append(ret.Array,
+			resp.Bulk("entries"), respEntries,
+			resp.Bulk("groups"), resp.Array(),
+		)
+
+		return ret
+	}
+
+	firstEntry, lastEntry := resp.Null(), resp.Null()
+	if len(stream.entries) > 0 {
+		firstEntry = streamEntryToValue(stream.entries[0])
+		lastEntry = streamEntryToValue(stream.entries[len(stream.entries)-1])
+	}
+
+	ret.Array = append(ret.Array,
+		resp.Bulk("groups"), resp.Int(0),
+		resp.Bulk("first-entry"), firstEntry,
+		resp.Bulk("last-entry"), lastEntry,
+	)
+
+	return ret
+}
+
+// Consumer groups are not supported yet, so every existing stream reports none.
+func xinfoGroups(streamKey string) resp.Value {
+	streams.mu.RLock()
+	_, ok := streams.entries[streamKey]
+	streams.mu.RUnlock()
+
+	if !ok {
+		return resp.Err("ERR no such key")
+	}
+
+	return resp.Array()
+}
+
+func xinfoConsumers(streamKey, group string) resp.Value {
+	streams.mu.RLock()
+	_, ok := streams.entries[streamKey]
+	streams.mu.RUnlock()
+
+	if !ok {
+		return resp.Err("ERR no such key")
+	}
+
+	return resp.Errf("NOGROUP No such consumer group '%v' for key name '%v'", group, streamKey)
+}
+
+func streamEntryToValue(entry StreamEntry) resp.Value {
+	kvps := resp.Array(make([]resp.Value, 0, 2*len(entry.fields))...)
+	for i, field := range entry.fields {
+		kvps.Array = append(kvps.Array, resp.Bulk(field), resp.Bulk(entry.values[i]))
+	}
+
+	return resp.Array(resp.Bulk(entry.id), kvps)
+}
+
+func incr(args []resp.Value) resp.Value {
+	if len(args) != 1 {
+		return resp.Err("ERR wrong number of arguments for 'incr' command")
+	}
+
+	key := args[0].String()
+	SETsMu.RLock()
+	val, ok := SETs[key]
+	SETsMu.RUnlock()
+
+	if !ok {
 		val = "0"
 	}
 
 	i, err := strconv.Atoi(val)
 	if err != nil {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR value is not an integer or out of range"}
+		return resp.Err("ERR value is not an integer or out of range")
 	}
 
 	SETsMu.Lock()
 	SETs[key] = strconv.Itoa(i + 1)
 	SETsMu.Unlock()
+	touchKey(key)
 
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: i + 1}
+	return resp.Int(i + 1)
 }
 
 func multi(queue *Queue) resp.Value {
 	queue.active = true
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "OK"}
+	return resp.OK()
 }
 
-func exec(queue *Queue) resp.Value {
+func exec(queue *Queue, client *Client) resp.Value {
 	if !queue.active {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR EXEC without MULTI"}
+		return resp.Err("ERR EXEC without MULTI")
 	}
 
-	defer func() { queue.active = false; queue.items = queue.items[:0] }()
+	defer queue.reset()
+	defer watches.Unwatch(client)
 
-	for _, wasTouched := range server.watched {
-		if wasTouched {
-			return resp.Value{Typ: resp.NULL_ARRAY}
-		}
+	if queue.dirty {
+		return resp.Err("EXECABORT Transaction discarded because of previous errors.")
 	}
 
-	ret := resp.Value{Typ: resp.ARRAY_TYPE}
-	for _, item := range queue.items {
-		command := strings.ToUpper(item.Array[0].Bulk)
+	ret := resp.NullArray()
+	keyspace.runExclusive(func() {
+		if watches.Dirty(client) {
+			return
+		}
+
+		writes := slices.ContainsFunc(queue.items, func(item resp.Value) bool {
+			return isWriteCommand(item.Array)
+		})
+		if writes && server.shuttingDown.Load() {
+			ret = resp.Err("ERR Server is shutting down")
+			return
+		}
+
+		ret = runTransaction(queue.items)
+		server.propagateTransaction(keyspace.effects)
+	})
+
+	return ret
+}
+
+// runTransaction runs the queued commands one after another and records their
+// writes in keyspace.effects. The caller must hold the keyspace exclusively.
+func runTransaction(items []resp.Value) resp.Value {
+	ret := resp.Array()
+	for _, item := range items {
+		command := strings.ToUpper(item.Array[0].String())
 		handler := Handlers[command]
-		ret.Array = append(ret.Array, ExecuteCommand(handler, item.Array[1:]))
+		ret.Array = append(ret.Array, handler(item.Array[1:]))
+		if isWriteCommand(item.Array) || slices.Contains(PublishCommands, command) {
+			keyspace.effects = append(keyspace.effects, item)
+		}
 	}
 
 	return ret
 }
 
+// queueCommand adds a command sent inside MULTI to the transaction. A command
+// that can't run in a transaction, is unknown, or has invalid arguments is
+// refused, and makes EXEC discard the transaction.
+func queueCommand(queue *Queue, value resp.Value) resp.Value {
+	command := strings.ToUpper(value.Array[0].String())
+	if slices.Contains(NoMultiCommands, command) {
+		queue.dirty = true
+		return resp.Err("ERR Command not allowed inside a transaction")
+	}
+
+	if _, ok := Handlers[command]; !ok {
+		fmt.Println("Invalid command: ", command)
+		queue.dirty = true
+		return resp.Errf("ERR unknown command %v", command)
+	}
+
+	if !checkArity(command, len(value.Array)) {
+		queue.dirty = true
+		return resp.Errf("ERR wrong number of arguments for '%s' command", strings.ToLower(command))
+	}
+
+	if check, ok := SyntaxChecks[command]; ok {
+		if err := check(value.Array[1:]); err != nil {
+			queue.dirty = true
+			return resp.Err(err.Error())
+		}
+	}
+
+	queue.items = append(queue.items, value)
+	return resp.Simple("QUEUED")
+}
+
 func discard(queue *Queue) resp.Value {
 	if !queue.active {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR DISCARD without MULTI"}
+		return resp.Err("ERR DISCARD without MULTI")
 	}
 
-	queue.active = false
-	queue.items = queue.items[:0]
+	queue.reset()
+	return resp.OK()
+}
 
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "OK"}
+// unwatch is UNWATCH run from a transaction, where it has nothing to do since
+// EXEC forgets the watched keys anyway.
+func unwatch(args []resp.Value) resp.Value {
+	return resp.OK()
 }
 
-func watch(args []resp.Value) resp.Value {
+func watch(args []resp.Value, client *Client) resp.Value {
 	if len(args) < 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'watch' command"}
+		return resp.Err("ERR wrong number of arguments for 'watch' command")
 	}
 
 	for _, arg := range args {
-		key := arg.Bulk
-		server.watched[key] = false
+		watches.Watch(client, arg.String())
 	}
 
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "OK"}
+	return resp.OK()
 }
 
 type List struct {
@@ -609,27 +1671,50 @@ type List struct {
 }
 
 type Lists struct {
-	lists map[string]List
-	mu    sync.Mutex
-	cond  *sync.Cond
+	lists   map[string]List
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
 }
 
 var lists *Lists = newLists()
 
 func newLists() *Lists {
-	lists := &Lists{
-		lists: make(map[string]List),
+	return &Lists{
+		lists:   make(map[string]List),
+		waiters: make(map[string][]chan struct{}),
+	}
+}
+
+// notify wakes every BLPOP blocked on key. The caller must hold lists.mu.
+func (l *Lists) notify(key string) {
+	for _, waiter := range l.waiters[key] {
+		select {
+		case waiter <- struct{}{}:
+		default:
+		}
+	}
+
+	delete(l.waiters, key)
+}
+
+func (l *Lists) removeWaiter(key string, waiter chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	waiters := slices.DeleteFunc(l.waiters[key], func(w chan struct{}) bool { return w == waiter })
+	if len(waiters) == 0 {
+		delete(l.waiters, key)
+	} else {
+		l.waiters[key] = waiters
 	}
-	lists.cond = sync.NewCond(&lists.mu)
-	return lists
 }
 
 func rpush(args []resp.Value) resp.Value {
 	if len(args) < 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'rpush' command"}
+		return resp.Err("ERR wrong number of arguments for 'rpush' command")
 	}
 
-	key := args[0].Bulk
+	key := args[0].String()
 
 	lists.mu.Lock()
 	defer lists.mu.Unlock()
@@ -641,37 +1726,38 @@ func rpush(args []resp.Value) resp.Value {
 
 	list.items = append(list.items, args[1:]...)
 	lists.lists[key] = list
-	lists.cond.Signal()
+	touchKey(key)
+	lists.notify(key)
 
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: len(list.items)}
+	return resp.Int(len(list.items))
 }
 
 func lrange(args []resp.Value) resp.Value {
 	if len(args) != 3 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'lrange' command"}
+		return resp.Err("ERR wrong number of arguments for 'lrange' command")
 	}
 
-	key := args[0].Bulk
+	key := args[0].String()
 	lists.mu.Lock()
 	defer lists.mu.Unlock()
 
 	list, ok := lists.lists[key]
 	if !ok {
-		return resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{}}
+		return resp.Array()
 	}
 
-	start, err := strconv.Atoi(args[1].Bulk)
+	start, err := strconv.Atoi(args[1].String())
 	if err != nil {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: err.Error()}
+		return resp.Err(err.Error())
 	}
 
 	if start < 0 {
 		start = max(len(list.items)+start, 0)
 	}
 
-	end, err := strconv.Atoi(args[2].Bulk)
+	end, err := strconv.Atoi(args[2].String())
 	if err != nil {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: err.Error()}
+		return resp.Err(err.Error())
 	}
 
 	if end < 0 {
@@ -683,17 +1769,17 @@ func lrange(args []resp.Value) resp.Value {
 	}
 
 	if start >= len(list.items) || start > end {
-		return resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{}}
+		return resp.Array()
 	}
 
-	return resp.Value{Typ: resp.ARRAY_TYPE, Array: list.items[start : end+1]}
+	return resp.Array(list.items[start : end+1]...)
 }
 
 func lpush(args []resp.Value) resp.Value {
 	if len(args) < 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'lpush' command"}
+		return resp.Err("ERR wrong number of arguments for 'lpush' command")
 	}
-	key := args[0].Bulk
+	key := args[0].String()
 
 	lists.mu.Lock()
 	defer lists.mu.Unlock()
@@ -706,27 +1792,28 @@ func lpush(args []resp.Value) resp.Value {
 	slices.Reverse(args[1:])
 	list.items = append(args[1:], list.items...)
 	lists.lists[key] = list
-	lists.cond.Broadcast()
+	touchKey(key)
+	lists.notify(key)
 
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: len(list.items)}
+	return resp.Int(len(list.items))
 }
 
 func llen(args []resp.Value) resp.Value {
 	if len(args) != 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'llen' command"}
+		return resp.Err("ERR wrong number of arguments for 'llen' command")
 	}
 
-	key := args[0].Bulk
+	key := args[0].String()
 	lists.mu.Lock()
 	defer lists.mu.Unlock()
 
 	list := lists.lists[key]
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: len(list.items)}
+	return resp.Int(len(list.items))
 }
 
 func lpop(args []resp.Value) resp.Value {
 	if len(args) > 2 || len(args) < 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'lpop' command"}
+		return resp.Err("ERR wrong number of arguments for 'lpop' command")
 	}
 
 	var (
@@ -736,22 +1823,22 @@ func lpop(args []resp.Value) resp.Value {
 
 	if len(args) > 1 {
 		fmt.Println(len(args))
-		if n, err = strconv.Atoi(args[1].Bulk); err != nil {
-			return resp.Value{Typ: resp.ERROR_TYPE, Str: err.Error()}
+		if n, err = strconv.Atoi(args[1].String()); err != nil {
+			return resp.Err(err.Error())
 		}
 	}
 
 	if n < 0 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR value is out of range, must be positive"}
+		return resp.Err("ERR value is out of range, must be positive")
 	}
 
-	key := args[0].Bulk
+	key := args[0].String()
 	lists.mu.Lock()
 	defer lists.mu.Unlock()
 
 	list, ok := lists.lists[key]
 	if !ok || len(list.items) == 0 {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
 
 	if n >= len(list.items) {
@@ -761,9 +1848,10 @@ func lpop(args []resp.Value) resp.Value {
 	items := list.items[:n]
 	list.items = list.items[n:]
 	lists.lists[key] = list
+	touchKey(key)
 
 	if n > 1 {
-		return resp.Value{Typ: resp.ARRAY_TYPE, Array: items}
+		return resp.Array(items...)
 	}
 
 	return items[0]
@@ -771,114 +1859,51 @@ func lpop(args []resp.Value) resp.Value {
 
 func blpop(args []resp.Value) resp.Value {
 	if len(args) != 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'blpop' command"}
+		return resp.Err("ERR wrong number of arguments for 'blpop' command")
 	}
 
-	key := args[0].Bulk
-	timeout, err := strconv.ParseFloat(args[1].Bulk, 32)
+	key := args[0].String()
+	timeout, err := strconv.ParseFloat(args[1].String(), 32)
 	if err != nil {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR timeout is not a float or out of range"}
+		return resp.Err("ERR timeout is not a float or out of range")
 	}
 
-	lists.mu.Lock()
-	defer lists.mu.Unlock()
-
-	var (
-		list   List
-		exists bool
-	)
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout * float64(time.Second)))
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
 
 	for {
-		list, exists = lists.lists[key]
+		lists.mu.Lock()
+		list, exists := lists.lists[key]
 		if exists && len(list.items) > 0 {
-			break
-		}
+			item := list.items[0]
+			list.items = list.items[1:]
+			lists.lists[key] = list
+			touchKey(key)
+			lists.mu.Unlock()
 
-		if timeout == 0 {
-			lists.cond.Wait()
-		} else {
-			timeoutCh := time.After(time.Duration(timeout * float64(time.Second)))
-			waitCh := make(chan struct{})
-			go func() {
-				lists.cond.Wait()
-				waitCh <- struct{}{}
-			}()
-
-			select {
-			case <-waitCh:
-			case <-timeoutCh:
-				lists.cond.Signal()
-				time.Sleep(100 * time.Millisecond) // Wait for the unlock
-				return resp.Value{Typ: resp.NULL_ARRAY}
-			}
+			return resp.Array(args[0], item)
 		}
-	}
-
-	item := list.items[0]
-	list.items = list.items[1:]
-	lists.lists[key] = list
-
-	return resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{args[0], item}}
-}
-
-func subscribe(args []resp.Value, subscribes map[string]*SubscribeChan) resp.Value {
-	if len(args) < 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'subscribe' command"}
-	}
-
-	channel := args[0].Bulk
-	if _, ok := server.subscribeChans[channel]; !ok {
-		server.subscribeChans[channel] = &SubscribeChan{name: channel, channel: make(chan string), subscribers: 0}
-	}
-
-	server.subscribeChans[channel].subscribers++
-	subscribes[channel] = server.subscribeChans[channel]
-
-	ret := resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{}}
-	ret.Array = append(ret.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: "subscribe"})
-	ret.Array = append(ret.Array, args[0])
-	ret.Array = append(ret.Array, resp.Value{Typ: resp.INTEGER_TYPE, Int: len(subscribes)})
-	return ret
-}
-
-func publish(args []resp.Value) resp.Value {
-	if len(args) != 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'publish' command"}
-	}
-
-	channel, ok := server.subscribeChans[args[0].Bulk]
-	if !ok {
-		return resp.Value{Typ: resp.INTEGER_TYPE, Int: 0}
-	}
-
-	for range channel.subscribers {
-		channel.channel <- args[1].Bulk
-	}
 
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: channel.subscribers}
-}
+		// Inside a transaction BLPOP behaves as if the timeout was reached.
+		if keyspace.exclusive {
+			lists.mu.Unlock()
+			return resp.NullArray()
+		}
 
-func unsubscribe(args []resp.Value, subscribes map[string]*SubscribeChan, unsubscribeChans map[string]chan struct{}) resp.Value {
-	if len(args) < 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'unsubscribe' command"}
-	}
+		woken := make(chan struct{}, 1)
+		lists.waiters[key] = append(lists.waiters[key], woken)
+		lists.mu.Unlock()
 
-	name := args[0].Bulk
-	channel, ok := server.subscribeChans[name]
-	if !ok {
-		channel = &SubscribeChan{name: name, channel: make(chan string), subscribers: 1}
-		unsubscribeChans[name] = make(chan struct{})
+		timedOut := waitUnlocked(woken, timeoutCh)
+		lists.removeWaiter(key, woken)
+		if timedOut {
+			return resp.NullArray()
+		}
 	}
-
-	channel.subscribers--
-	delete(subscribes, name)
-	unsubscribeChans[name] <- struct{}{}
-
-	ret := resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{}}
-	ret.Array = append(ret.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: "unsubscribe"})
-	ret.Array = append(ret.Array, args[0])
-	ret.Array = append(ret.Array, resp.Value{Typ: resp.INTEGER_TYPE, Int: len(subscribes)})
-	return ret
 }
 
 var sets = map[string]*s.Set{}
@@ -886,20 +1911,20 @@ var setsmu = sync.Mutex{}
 
 func zadd(args []resp.Value) resp.Value {
 	if len(args) != 3 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'zadd' command"}
+		return resp.Err("ERR wrong number of arguments for 'zadd' command")
 	}
 
-	score, err := strconv.ParseFloat(args[1].Bulk, 64)
+	score, err := strconv.ParseFloat(args[1].String(), 64)
 	if err != nil {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR score is not a float or out of range"}
+		return resp.Err("ERR score is not a float or out of range")
 	}
 
-	added := addToSet(args[0].Bulk, args[2].Bulk, score)
+	added := addToSet(args[0].String(), args[2].String(), score)
 	if added {
-		return resp.Value{Typ: resp.INTEGER_TYPE, Int: 1}
+		return resp.Int(1)
 	}
 
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: 0}
+	return resp.Int(0)
 }
 
 func addToSet(name, member string, score float64) bool {
@@ -908,104 +1933,88 @@ func addToSet(name, member string, score float64) bool {
 
 	set, ok := sets[name]
 	if !ok {
-		set = &s.Set{}
-		heap.Init(set)
+		set = s.New()
 	}
 
-	added := false
-	idx := set.FindByIndex(member)
-	if idx == -1 {
-		entry := s.SetMember{Member: member, Score: score}
-		heap.Push(set, entry)
-		added = true
-	} else {
-		(*set)[idx].Score = score
+	if old, ok := set.Score(member); ok && old == score {
+		return false
 	}
 
+	added := set.Add(member, score)
 	sets[name] = set
+	touchKey(name)
 	return added
 }
 
 func zrank(args []resp.Value) resp.Value {
 	if len(args) != 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'zrank' command"}
+		return resp.Err("ERR wrong number of arguments for 'zrank' command")
 	}
 
 	setsmu.Lock()
-	set, ok := sets[args[0].Bulk]
-	setsmu.Unlock()
+	defer setsmu.Unlock()
+
+	set, ok := sets[args[0].String()]
 
 	if !ok {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
 
-	idx := set.FindByRank(args[1].Bulk)
-	if idx == -1 {
-		return resp.Value{Typ: resp.NULL_TYPE}
+	rank := set.Rank(args[1].String())
+	if rank == -1 {
+		return resp.Null()
 	}
 
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: idx}
+	return resp.Int(rank)
 }
 
 func zrange(args []resp.Value) resp.Value {
 	if len(args) != 3 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'zrange' command"}
+		return resp.Err("ERR wrong number of arguments for 'zrange' command")
 	}
 
 	setsmu.Lock()
-	set, ok := sets[args[0].Bulk]
-	setsmu.Unlock()
+	defer setsmu.Unlock()
+
+	set, ok := sets[args[0].String()]
 
 	if !ok {
-		return resp.Value{Typ: resp.ARRAY_TYPE}
+		return resp.Array()
 	}
 
-	start, err := strconv.Atoi(args[1].Bulk)
+	start, err := strconv.Atoi(args[1].String())
 	if err != nil {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR start is not a int or out of range"}
+		return resp.Err("ERR start is not a int or out of range")
 	}
 
-	end, err := strconv.Atoi(args[2].Bulk)
+	end, err := strconv.Atoi(args[2].String())
 	if err != nil {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR end is not a int or out of range"}
+		return resp.Err("ERR end is not a int or out of range")
 	}
 
 	if start < 0 {
-		if math.Abs(float64(start)) >= float64(len(*set)) {
+		if math.Abs(float64(start)) >= float64(set.Len()) {
 			start = 0
 		} else {
-			start = len(*set) + start
+			start = set.Len() + start
 		}
 	}
 
 	if end < 0 {
-		if math.Abs(float64(end)) >= float64(len(*set)) {
+		if math.Abs(float64(end)) >= float64(set.Len()) {
 			end = 0
 		} else {
-			end = len(*set) + end
+			end = set.Len() + end
 		}
 	}
 
-	if start >= len(*set) || start > end {
-		return resp.Value{Typ: resp.ARRAY_TYPE}
+	if start >= set.Len() || start > end {
+		return resp.Array()
 	}
 
-	end = min(end, len(*set)-1)
-	elems := make([]s.SetMember, 0)
-	for range start {
-		elem := heap.Pop(set).(s.SetMember)
-		elems = append(elems, elem)
-	}
-
-	ret := resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{}}
-	for i := start; i <= end; i++ {
-		elem := heap.Pop(set).(s.SetMember)
-		elems = append(elems, elem)
-		ret.Array = append(ret.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: elem.Member})
-	}
-
-	for _, elem := range elems {
-		heap.Push(set, elem)
+	ret := resp.Array()
+	for _, elem := range set.RangeByRank(start, end) {
+		ret.Array = append(ret.Array, resp.Bulk(elem.Member))
 	}
 
 	return ret
@@ -1013,219 +2022,739 @@ func zrange(args []resp.Value) resp.Value {
 
 func zcard(args []resp.Value) resp.Value {
 	if len(args) != 1 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'zcard' command"}
+		return resp.Err("ERR wrong number of arguments for 'zcard' command")
 	}
 
 	setsmu.Lock()
-	set, ok := sets[args[0].Bulk]
-	setsmu.Unlock()
+	defer setsmu.Unlock()
+
+	set, ok := sets[args[0].String()]
 
 	if !ok {
-		return resp.Value{Typ: resp.INTEGER_TYPE, Int: 0}
+		return resp.Int(0)
 	}
 
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: len(*set)}
+	return resp.Int(set.Len())
 }
 
 func zscore(args []resp.Value) resp.Value {
 	if len(args) != 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'zscore' command"}
+		return resp.Err("ERR wrong number of arguments for 'zscore' command")
 	}
 
 	setsmu.Lock()
-	set, ok := sets[args[0].Bulk]
-	setsmu.Unlock()
+	defer setsmu.Unlock()
+
+	set, ok := sets[args[0].String()]
 
 	if !ok {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
 
-	idx := set.FindByIndex(args[1].Bulk)
-	if idx == -1 {
-		return resp.Value{Typ: resp.NULL_TYPE}
+	score, ok := set.Score(args[1].String())
+	if !ok {
+		return resp.Null()
 	}
 
-	return resp.Value{Typ: resp.BULK_TYPE, Bulk: fmt.Sprint((*set)[idx].Score)}
+	return resp.Double(score)
 }
 
 func zrem(args []resp.Value) resp.Value {
 	if len(args) != 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'zrem' command"}
+		return resp.Err("ERR wrong number of arguments for 'zrem' command")
 	}
 
 	setsmu.Lock()
 	defer setsmu.Unlock()
-	set, ok := sets[args[0].Bulk]
+	set, ok := sets[args[0].String()]
 
-	if !ok || len(*set) == 0 {
-		return resp.Value{Typ: resp.INTEGER_TYPE, Int: 0}
+	if !ok || set.Len() == 0 {
+		return resp.Int(0)
 	}
 
-	elems := make([]s.SetMember, 0)
 	removed := 0
-	for len(*set) > 0 {
-		elem := heap.Pop(set).(s.SetMember)
-		if elem.Member == args[1].Bulk {
-			removed = 1
-			break
+	if set.Remove(args[1].String()) {
+		removed = 1
+		touchKey(args[0].String())
+	}
+
+	return resp.Int(removed)
+}
+
+func geoadd(args []resp.Value) resp.Value {
+	if len(args) < 4 {
+		return resp.Err("ERR wrong number of arguments for 'geoadd' command")
+	}
+
+	opts, err := parseGeoAdd(args[1:])
+	if err != nil {
+		return resp.Err(err.Error())
+	}
+
+	nx, xx, ch, members := opts.nx, opts.xx, opts.ch, opts.members
+	setsmu.Lock()
+	defer setsmu.Unlock()
+
+	set, ok := sets[args[0].String()]
+	if !ok {
+		if xx {
+			return resp.Int(0)
 		}
 
-		elems = append(elems, elem)
+		set = s.New()
+		sets[args[0].String()] = set
+	}
+
+	added, updated := 0, 0
+	for _, member := range members {
+		score, ok := set.Score(member.Member)
+		if !ok {
+			if !xx {
+				set.Add(member.Member, member.Score)
+				added++
+			}
+
+			continue
+		}
+
+		if !nx && score != member.Score {
+			set.Add(member.Member, member.Score)
+			updated++
+		}
 	}
 
-	for _, elem := range elems {
-		heap.Push(set, elem)
+	if added+updated > 0 {
+		touchKey(args[0].String())
 	}
-	sets[args[0].Bulk] = set
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: removed}
+
+	if ch {
+		return resp.Int(added + updated)
+	}
+
+	return resp.Int(added)
 }
 
-func geoadd(args []resp.Value) resp.Value {
-	if len(args) != 4 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'geoadd' command"}
+type geoAddOptions struct {
+	nx, xx, ch bool
+	members    []s.SetMember
+}
+
+// parseGeoAdd parses the arguments of GEOADD after the key: its options, then
+// longitude, latitude and member triplets.
+func parseGeoAdd(args []resp.Value) (geoAddOptions, error) {
+	opts := geoAddOptions{}
+	i := 0
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].String()) {
+		case "NX":
+			opts.nx = true
+			continue
+		case "XX":
+			opts.xx = true
+			continue
+		case "CH":
+			opts.ch = true
+			continue
+		}
+
+		break
 	}
 
-	long, err := strconv.ParseFloat(args[1].Bulk, 64)
-	if err != nil || long < geohash.MinLongitude || long > geohash.MaxLongitude {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR longitude is not a float or out of range"}
+	if opts.nx && opts.xx {
+		return opts, errors.New("ERR XX and NX options at the same time are not compatible")
 	}
 
-	lat, err := strconv.ParseFloat(args[2].Bulk, 64)
-	if err != nil || lat < geohash.MinLatitude || lat > geohash.MaxLatitude {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR latitude is not a float or out of range"}
+	if (len(args)-i)%3 != 0 || len(args) == i {
+		return opts, errors.New("ERR syntax error")
 	}
 
-	added := addToSet(args[0].Bulk, args[3].Bulk, float64(geohash.EncodeGeoScore(long, lat)))
-	if added {
-		return resp.Value{Typ: resp.INTEGER_TYPE, Int: 1}
+	opts.members = make([]s.SetMember, 0, (len(args)-i)/3)
+	for ; i < len(args); i += 3 {
+		long, lat, err := parseLonLat(args[i].String(), args[i+1].String())
+		if err != nil {
+			return opts, err
+		}
+
+		opts.members = append(opts.members, s.SetMember{Member: args[i+2].String(), Score: float64(geohash.EncodeGeoScore(long, lat))})
 	}
 
-	return resp.Value{Typ: resp.INTEGER_TYPE, Int: 0}
+	return opts, nil
 }
 
 func geopos(args []resp.Value) resp.Value {
 	if len(args) < 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'geopos' command"}
+		return resp.Err("ERR wrong number of arguments for 'geopos' command")
 	}
 
 	setsmu.Lock()
-	set, ok := sets[args[0].Bulk]
-	setsmu.Unlock()
+	defer setsmu.Unlock()
+
+	set, ok := sets[args[0].String()]
 
 	if !ok {
-		ret := resp.Value{Typ: resp.ARRAY_TYPE}
+		ret := resp.Array()
 		for range len(args[1:]) {
-			ret.Array = append(ret.Array, resp.Value{Typ: resp.NULL_ARRAY})
+			ret.Array = append(ret.Array, resp.NullArray())
 		}
 
 		return ret
 	}
 
-	ret := resp.Value{Typ: resp.ARRAY_TYPE}
+	ret := resp.Array()
 	for _, location := range args[1:] {
-		idx := set.FindByIndex(location.Bulk)
-		if idx == -1 {
-			ret.Array = append(ret.Array, resp.Value{Typ: resp.NULL_ARRAY})
+		score, ok := set.Score(location.String())
+		if !ok {
+			ret.Array = append(ret.Array, resp.NullArray())
 			continue
 		}
 
-		pos := geohash.DecodeGeoScore(int((*set)[idx].Score))
-		ret.Array = append(ret.Array, resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{{Typ: resp.BULK_TYPE, Bulk: fmt.Sprint(pos.Long)}, {Typ: resp.BULK_TYPE, Bulk: fmt.Sprint(pos.Lat)}}})
+		pos := geohash.DecodeGeoScore(int(score))
+		ret.Array = append(ret.Array, resp.Array(resp.Bulk(fmt.Sprint(pos.Long)), resp.Bulk(fmt.Sprint(pos.Lat))))
 	}
 
 	return ret
 }
 
 func geodist(args []resp.Value) resp.Value {
-	if len(args) != 3 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'geodist' command"}
+	if len(args) != 3 && len(args) != 4 {
+		return resp.Err("ERR wrong number of arguments for 'geodist' command")
+	}
+
+	unit := 1.0
+	if len(args) == 4 {
+		var err error
+		if unit, err = geoUnitToMeters(args[3].String()); err != nil {
+			return resp.Err(err.Error())
+		}
 	}
 
 	setsmu.Lock()
-	set, ok := sets[args[0].Bulk]
-	setsmu.Unlock()
+	defer setsmu.Unlock()
+
+	set, ok := sets[args[0].String()]
 
 	if !ok {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
 
-	idx1 := set.FindByIndex(args[1].Bulk)
-	if idx1 == -1 {
-		return resp.Value{Typ: resp.NULL_TYPE}
+	score1, ok := set.Score(args[1].String())
+	if !ok {
+		return resp.Null()
 	}
 
-	idx2 := set.FindByIndex(args[2].Bulk)
-	if idx2 == -1 {
-		return resp.Value{Typ: resp.NULL_TYPE}
+	score2, ok := set.Score(args[2].String())
+	if !ok {
+		return resp.Null()
 	}
 
-	pos1 := geohash.DecodeGeoScore(int((*set)[idx1].Score))
-	pos2 := geohash.DecodeGeoScore(int((*set)[idx2].Score))
+	pos1 := geohash.DecodeGeoScore(int(score1))
+	pos2 := geohash.DecodeGeoScore(int(score2))
 	dist := geohash.Hsdist(geohash.DegPos(pos1.Lat, pos1.Long), geohash.DegPos(pos2.Lat, pos2.Long))
 
-	return resp.Value{Typ: resp.BULK_TYPE, Bulk: fmt.Sprint(dist)}
+	return resp.Bulk(strconv.FormatFloat(dist/unit, 'f', 4, 64))
 }
 
-func geosearch(args []resp.Value) resp.Value {
-	if len(args) != 7 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'geosearch' command"}
+func geohashCommand(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Err("ERR wrong number of arguments for 'geohash' command")
 	}
 
 	setsmu.Lock()
-	set, ok := sets[args[0].Bulk]
-	setsmu.Unlock()
+	defer setsmu.Unlock()
+	set, ok := sets[args[0].String()]
+
+	ret := resp.Array()
+	for _, member := range args[1:] {
+		var score float64
+		found := false
+		if ok {
+			score, found = set.Score(member.String())
+		}
 
-	if !ok {
-		return resp.Value{Typ: resp.NULL_ARRAY}
+		if !found {
+			ret.Array = append(ret.Array, resp.Null())
+			continue
+		}
+
+		pos := geohash.DecodeGeoScore(int(score))
+		ret.Array = append(ret.Array, resp.Bulk(geohash.EncodeBase32(pos.Long, pos.Lat)))
 	}
 
-	long, err := strconv.ParseFloat(args[2].Bulk, 64)
-	if err != nil || long < geohash.MinLongitude || long > geohash.MaxLongitude {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR longitude is not a float or out of range"}
+	return ret
+}
+
+const (
+	geoSearchFlag = 1 << iota
+	geoSearchStoreFlag
+	geoRadiusStoreFlag
+)
+
+const (
+	geoSortNone = iota
+	geoSortAsc
+	geoSortDesc
+)
+
+type geoShape struct {
+	lon, lat      float64
+	byRadius      bool
+	byBox         bool
+	radius        float64
+	width, height float64
+	unit          float64
+}
+
+type geoSearchOptions struct {
+	shape      geoShape
+	fromMember string
+	fromLonLat bool
+	sort       int
+	count      int
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeKey   string
+	storeDist  bool
+}
+
+type geoPoint struct {
+	member   string
+	score    float64
+	lon, lat float64
+	dist     float64
+}
+
+func geosearch(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Err("ERR wrong number of arguments for 'geosearch' command")
 	}
 
-	lat, err := strconv.ParseFloat(args[3].Bulk, 64)
-	if err != nil || lat < geohash.MinLatitude || lat > geohash.MaxLatitude {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR latitude is not a float or out of range"}
+	opts := geoSearchOptions{}
+	if err := parseGeoSearchOptions(args[1:], &opts, geoSearchFlag); err != nil {
+		return resp.Err(err.Error())
 	}
 
-	r, err := strconv.ParseFloat(args[5].Bulk, 64)
+	return geoSearchGeneric(args[0].String(), opts)
+}
+
+func geosearchstore(args []resp.Value) resp.Value {
+	if len(args) < 2 {
+		return resp.Err("ERR wrong number of arguments for 'geosearchstore' command")
+	}
+
+	opts := geoSearchOptions{storeKey: args[0].String()}
+	if err := parseGeoSearchOptions(args[2:], &opts, geoSearchFlag|geoSearchStoreFlag); err != nil {
+		return resp.Err(err.Error())
+	}
+
+	return geoSearchGeneric(args[1].String(), opts)
+}
+
+func georadius(args []resp.Value) resp.Value {
+	return georadiusGeneric("georadius", args, false, geoRadiusStoreFlag)
+}
+
+func georadiusRO(args []resp.Value) resp.Value {
+	return georadiusGeneric("georadius_ro", args, false, 0)
+}
+
+func georadiusbymember(args []resp.Value) resp.Value {
+	return georadiusGeneric("georadiusbymember", args, true, geoRadiusStoreFlag)
+}
+
+func georadiusbymemberRO(args []resp.Value) resp.Value {
+	return georadiusGeneric("georadiusbymember_ro", args, true, 0)
+}
+
+func georadiusGeneric(name string, args []resp.Value, byMember bool, flags int) resp.Value {
+	positional := 5
+	if byMember {
+		positional = 4
+	}
+
+	if len(args) < positional {
+		return resp.Errf("ERR wrong number of arguments for '%v' command", name)
+	}
+
+	opts, err := parseGeoRadiusArgs(args, byMember, flags)
 	if err != nil {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR radius is not a float or out of range"}
+		return resp.Err(err.Error())
 	}
 
-	r = radiusToM(r, args[6].Bulk)
-	ret := resp.Value{Typ: resp.ARRAY_TYPE}
-	for _, location := range *set {
-		pos := geohash.DecodeGeoScore(int(location.Score))
-		dist := geohash.Hsdist(geohash.DegPos(lat, long), geohash.DegPos(pos.Lat, pos.Long))
-		if dist <= r {
-			ret.Array = append(ret.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: location.Member})
+	return geoSearchGeneric(args[0].String(), opts)
+}
+
+// parseGeoRadiusArgs parses the center, radius and options of GEORADIUS, or
+// of GEORADIUSBYMEMBER when byMember is set. The caller checks that the
+// positional arguments are there.
+func parseGeoRadiusArgs(args []resp.Value, byMember bool, flags int) (geoSearchOptions, error) {
+	positional := 5
+	if byMember {
+		positional = 4
+	}
+
+	opts := geoSearchOptions{}
+	if byMember {
+		opts.fromMember = args[1].String()
+	} else {
+		lon, lat, err := parseLonLat(args[1].String(), args[2].String())
+		if err != nil {
+			return opts, err
 		}
+
+		opts.fromLonLat = true
+		opts.shape.lon, opts.shape.lat = lon, lat
 	}
 
-	return ret
+	if err := parseGeoRadius(args[positional-2].String(), args[positional-1].String(), &opts.shape); err != nil {
+		return opts, err
+	}
+
+	err := parseGeoSearchOptions(args[positional:], &opts, flags)
+	return opts, err
 }
 
-func radiusToM(radius float64, unit string) float64 {
-	switch unit {
+func parseGeoSearchOptions(args []resp.Value, opts *geoSearchOptions, flags int) error {
+	search := flags&geoSearchFlag != 0
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(args[i].String())
+		remaining := len(args) - i - 1
+
+		switch {
+		case arg == "WITHDIST":
+			opts.withDist = true
+		case arg == "WITHHASH":
+			opts.withHash = true
+		case arg == "WITHCOORD":
+			opts.withCoord = true
+		case arg == "ANY":
+			opts.any = true
+		case arg == "ASC":
+			opts.sort = geoSortAsc
+		case arg == "DESC":
+			opts.sort = geoSortDesc
+		case arg == "COUNT" && remaining >= 1:
+			count, err := strconv.Atoi(args[i+1].String())
+			if err != nil {
+				return errors.New("ERR value is not an integer or out of range")
+			}
+
+			if count <= 0 {
+				return errors.New("ERR COUNT must be > 0")
+			}
+
+			opts.count = count
+			i++
+		case (arg == "STORE" || arg == "STOREDIST") && remaining >= 1 && flags&geoRadiusStoreFlag != 0:
+			opts.storeKey = args[i+1].String()
+			opts.storeDist = arg == "STOREDIST"
+			i++
+		case arg == "STOREDIST" && flags&geoSearchStoreFlag != 0:
+			opts.storeDist = true
+		case arg == "FROMMEMBER" && remaining >= 1 && search:
+			if opts.fromLonLat || opts.fromMember != "" {
+				return errors.New("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+
+			opts.fromMember = args[i+1].String()
+			i++
+		case arg == "FROMLONLAT" && remaining >= 2 && search:
+			if opts.fromLonLat || opts.fromMember != "" {
+				return errors.New("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+
+			lon, lat, err := parseLonLat(args[i+1].String(), args[i+2].String())
+			if err != nil {
+				return err
+			}
+
+			opts.fromLonLat = true
+			opts.shape.lon, opts.shape.lat = lon, lat
+			i += 2
+		case arg == "BYRADIUS" && remaining >= 2 && search:
+			if opts.shape.byRadius || opts.shape.byBox {
+				return errors.New("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+
+			if err := parseGeoRadius(args[i+1].String(), args[i+2].String(), &opts.shape); err != nil {
+				return err
+			}
+
+			i += 2
+		case arg == "BYBOX" && remaining >= 3 && search:
+			if opts.shape.byRadius || opts.shape.byBox {
+				return errors.New("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+
+			if err := parseGeoBox(args[i+1].String(), args[i+2].String(), args[i+3].String(), &opts.shape); err != nil {
+				return err
+			}
+
+			i += 3
+		default:
+			return errors.New("ERR syntax error")
+		}
+	}
+
+	if search && !opts.fromLonLat && opts.fromMember == "" {
+		return errors.New("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+
+	if search && !opts.shape.byRadius && !opts.shape.byBox {
+		return errors.New("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+
+	if opts.any && opts.count == 0 {
+		return errors.New("ERR the ANY argument requires COUNT argument")
+	}
+
+	if (opts.storeKey != "" || flags&geoSearchStoreFlag != 0) && (opts.withDist || opts.withHash || opts.withCoord) {
+		return errors.New("ERR STORE option is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+	}
+
+	return nil
+}
+
+func parseLonLat(lonArg, latArg string) (float64, float64, error) {
+	lon, err := strconv.ParseFloat(lonArg, 64)
+	if err != nil || lon < geohash.MinLongitude || lon > geohash.MaxLongitude {
+		return 0, 0, errors.New("ERR longitude is not a float or out of range")
+	}
+
+	lat, err := strconv.ParseFloat(latArg, 64)
+	if err != nil || lat < geohash.MinLatitude || lat > geohash.MaxLatitude {
+		return 0, 0, errors.New("ERR latitude is not a float or out of range")
+	}
+
+	return lon, lat, nil
+}
+
+func parseGeoRadius(radiusArg, unitArg string, shape *geoShape) error {
+	radius, err := strconv.ParseFloat(radiusArg, 64)
+	if err != nil {
+		return errors.New("ERR need numeric radius")
+	}
+
+	if radius < 0 {
+		return errors.New("ERR radius cannot be negative")
+	}
+
+	unit, err := geoUnitToMeters(unitArg)
+	if err != nil {
+		return err
+	}
+
+	shape.byRadius = true
+	shape.radius = radius * unit
+	shape.unit = unit
+	return nil
+}
+
+func parseGeoBox(widthArg, heightArg, unitArg string, shape *geoShape) error {
+	width, err := strconv.ParseFloat(widthArg, 64)
+	if err != nil {
+		return errors.New("ERR need numeric width")
+	}
+
+	height, err := strconv.ParseFloat(heightArg, 64)
+	if err != nil {
+		return errors.New("ERR need numeric height")
+	}
+
+	if width < 0 || height < 0 {
+		return errors.New("ERR height or width cannot be negative")
+	}
+
+	unit, err := geoUnitToMeters(unitArg)
+	if err != nil {
+		return err
+	}
+
+	shape.byBox = true
+	shape.width = width * unit
+	shape.height = height * unit
+	shape.unit = unit
+	return nil
+}
+
+func geoUnitToMeters(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
 	case "km":
-		return radius * 1000
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
 	case "mi":
-		return radius * 1609.34
+		return 1609.34, nil
 	default:
-		return radius
+		return 0, errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
 	}
 }
 
+func geoSearchGeneric(key string, opts geoSearchOptions) resp.Value {
+	setsmu.Lock()
+	set, ok := sets[key]
+	if !ok {
+		setsmu.Unlock()
+		if opts.storeKey != "" {
+			return geoStore(opts.storeKey, nil, opts.storeDist, opts.shape.unit)
+		}
+
+		return resp.Array()
+	}
+
+	if opts.fromMember != "" {
+		score, ok := set.Score(opts.fromMember)
+		if !ok {
+			setsmu.Unlock()
+			return resp.Err("ERR could not decode requested zset member")
+		}
+
+		pos := geohash.DecodeGeoScore(int(score))
+		opts.shape.lon, opts.shape.lat = pos.Long, pos.Lat
+	}
+
+	limit := 0
+	if opts.any {
+		limit = opts.count
+	}
+
+	points := geoMembersInShape(set, opts.shape, limit)
+	setsmu.Unlock()
+
+	if opts.sort == geoSortNone && opts.count > 0 && !opts.any {
+		opts.sort = geoSortAsc
+	}
+
+	switch opts.sort {
+	case geoSortAsc:
+		slices.SortStableFunc(points, func(a, b geoPoint) int { return cmp.Compare(a.dist, b.dist) })
+	case geoSortDesc:
+		slices.SortStableFunc(points, func(a, b geoPoint) int { return cmp.Compare(b.dist, a.dist) })
+	}
+
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+
+	if opts.storeKey != "" {
+		return geoStore(opts.storeKey, points, opts.storeDist, opts.shape.unit)
+	}
+
+	ret := resp.Array()
+	for _, point := range points {
+		member := resp.Bulk(point.member)
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			ret.Array = append(ret.Array, member)
+			continue
+		}
+
+		item := resp.Array(member)
+		if opts.withDist {
+			item.Array = append(item.Array, resp.Bulk(strconv.FormatFloat(point.dist/opts.shape.unit, 'f', 4, 64)))
+		}
+
+		if opts.withHash {
+			item.Array = append(item.Array, resp.Int(int(point.score)))
+		}
+
+		if opts.withCoord {
+			item.Array = append(item.Array, resp.Array(resp.Bulk(fmt.Sprint(point.lon)), resp.Bulk(fmt.Sprint(point.lat))))
+		}
+
+		ret.Array = append(ret.Array, item)
+	}
+
+	return ret
+}
+
+// geoMembersInShape returns the members of set that lie inside shape, only
+// walking the score ranges of the geohash cells that cover it. A non-zero limit
+// stops the search as soon as that many members were found.
+func geoMembersInShape(set *s.Set, shape geoShape, limit int) []geoPoint {
+	var ranges []geohash.ScoreRange
+	if shape.byBox {
+		ranges = geohash.ScoreRangesByBox(shape.lon, shape.lat, shape.width, shape.height)
+	} else {
+		ranges = geohash.ScoreRangesByRadius(shape.lon, shape.lat, shape.radius)
+	}
+
+	points := make([]geoPoint, 0)
+	for _, r := range ranges {
+		for _, location := range set.RangeByScore(float64(r.Min), float64(r.Max)) {
+			pos := geohash.DecodeGeoScore(int(location.Score))
+			dist, ok := geoDistanceInShape(shape, pos.Long, pos.Lat)
+			if !ok {
+				continue
+			}
+
+			points = append(points, geoPoint{member: location.Member, score: location.Score, lon: pos.Long, lat: pos.Lat, dist: dist})
+			if limit > 0 && len(points) >= limit {
+				return points
+			}
+		}
+	}
+
+	return points
+}
+
+func geoDistanceInShape(shape geoShape, lon, lat float64) (float64, bool) {
+	if shape.byBox {
+		if geohash.LatDist(shape.lat, lat) > shape.height/2 {
+			return 0, false
+		}
+
+		if geohash.Hsdist(geohash.DegPos(lat, shape.lon), geohash.DegPos(lat, lon)) > shape.width/2 {
+			return 0, false
+		}
+	}
+
+	dist := geohash.Hsdist(geohash.DegPos(shape.lat, shape.lon), geohash.DegPos(lat, lon))
+	if shape.byRadius && dist > shape.radius {
+		return 0, false
+	}
+
+	return dist, true
+}
+
+func geoStore(key string, points []geoPoint, storeDist bool, unit float64) resp.Value {
+	setsmu.Lock()
+	defer setsmu.Unlock()
+
+	if len(points) == 0 {
+		if _, ok := sets[key]; ok {
+			delete(sets, key)
+			touchKey(key)
+		}
+
+		return resp.Int(0)
+	}
+
+	set := s.New()
+	for _, point := range points {
+		score := point.score
+		if storeDist {
+			score = point.dist / unit
+		}
+
+		set.Add(point.member, score)
+	}
+
+	sets[key] = set
+	touchKey(key)
+	return resp.Int(len(points))
+}
+
 func acl(args []resp.Value) resp.Value {
 	if len(args) == 0 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'acl' command"}
+		return resp.Err("ERR wrong number of arguments for 'acl' command")
 	}
 
-	switch args[0].Bulk {
+	switch args[0].String() {
 	case "WHOAMI":
-		return resp.Value{Typ: resp.BULK_TYPE, Bulk: "default"}
+		return resp.Bulk("default")
 	case "GETUSER":
 		return getuser(args[1:])
 	case "SETUSER":
@@ -1237,29 +2766,29 @@ func acl(args []resp.Value) resp.Value {
 
 func getuser(args []resp.Value) resp.Value {
 	if len(args) == 0 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'acl|getuser' command"}
+		return resp.Err("ERR wrong number of arguments for 'acl|getuser' command")
 	}
 
-	user, ok := server.users[args[0].Bulk]
+	user, ok := server.users[args[0].String()]
 	if !ok {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
 
-	res := resp.Value{Typ: resp.ARRAY_TYPE}
-	res.Array = append(res.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: "flags"})
+	res := resp.Map()
+	res.Array = append(res.Array, resp.Bulk("flags"))
 
-	flags := resp.Value{Typ: resp.ARRAY_TYPE}
+	flags := resp.Array()
 	for _, flag := range user.flags {
-		flags.Array = append(flags.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: flag})
+		flags.Array = append(flags.Array, resp.Bulk(flag))
 	}
 
 	res.Array = append(res.Array, flags)
 
-	res.Array = append(res.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: "passwords"})
+	res.Array = append(res.Array, resp.Bulk("passwords"))
 
-	passwords := resp.Value{Typ: resp.ARRAY_TYPE}
+	passwords := resp.Array()
 	for _, password := range user.passwords {
-		passwords.Array = append(passwords.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: password})
+		passwords.Array = append(passwords.Array, resp.Bulk(password))
 	}
 
 	res.Array = append(res.Array, passwords)
@@ -1268,32 +2797,96 @@ func getuser(args []resp.Value) resp.Value {
 
 func setuser(args []resp.Value) resp.Value {
 	if len(args) < 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'acl|setuser' command"}
+		return resp.Err("ERR wrong number of arguments for 'acl|setuser' command")
 	}
 
-	user, ok := server.users[args[0].Bulk]
+	user, ok := server.users[args[0].String()]
 	if !ok {
-		return resp.Value{Typ: resp.NULL_TYPE}
+		return resp.Null()
 	}
 
-	password := args[1].Bulk[1:]
+	password := args[1].String()[1:]
 	user.passwords = append(user.passwords, auth.Encrypt(password))
 	user.flags = slices.DeleteFunc(user.flags, func(flag string) bool { return flag == "nopass" })
-	server.users[args[0].Bulk] = user
+	server.users[args[0].String()] = user
 
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "OK"}
+	return resp.OK()
 }
 
 func authenticate(args []resp.Value) resp.Value {
 	if len(args) < 2 {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR wrong number of arguments for 'auth' command"}
+		return resp.Err("ERR wrong number of arguments for 'auth' command")
 	}
 
-	username, password := args[0].Bulk, args[1].Bulk
+	username, password := args[0].String(), args[1].String()
 	user, ok := server.users[username]
 	if !ok || !slices.Contains(user.passwords, auth.Encrypt(password)) {
-		return resp.Value{Typ: resp.ERROR_TYPE, Str: "WRONGPASS invalid username-password pair or user is disabled."}
+		return resp.Err("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+
+	return resp.OK()
+}
+
+// hello switches the connection to the requested protocol version, after
+// authenticating it when AUTH is given. It reports whether AUTH succeeded.
+func hello(args []resp.Value, client *Client, authenticated bool) (resp.Value, bool) {
+	protocol := client.Protocol()
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0].String())
+		if err != nil {
+			return resp.Err("ERR Protocol version is not an integer or out of range"), false
+		}
+
+		if n != 2 && n != 3 {
+			return resp.Err("NOPROTO sorry, this protocol version is not supported."), false
+		}
+
+		protocol = n
+	}
+
+	var credentials []resp.Value
+	name := client.name
+	for i := 1; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i].String()); {
+		case option == "AUTH" && i+2 < len(args):
+			credentials = args[i+1 : i+3]
+			i += 2
+		case option == "SETNAME" && i+1 < len(args):
+			name = args[i+1].String()
+			i++
+		default:
+			return resp.Errf("ERR Syntax error in HELLO option '%s'", args[i].String()), false
+		}
+	}
+
+	authenticatedNow := false
+	if credentials != nil {
+		if r := authenticate(credentials); r.Typ == resp.ErrorType {
+			return r, false
+		}
+
+		authenticatedNow = true
+	}
+
+	if !authenticated && !authenticatedNow {
+		return resp.Err("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"), false
+	}
+
+	client.name = name
+	client.protocol.Store(int32(protocol))
+
+	role := "master"
+	if server.replconf.host != "" {
+		role = "replica"
 	}
 
-	return resp.Value{Typ: resp.STRING_TYPE, Str: "OK"}
+	return resp.Map(
+		resp.Bulk("server"), resp.Bulk("redis"),
+		resp.Bulk("version"), resp.Bulk("7.4.0"),
+		resp.Bulk("proto"), resp.Int(protocol),
+		resp.Bulk("id"), resp.Int(int(client.id)),
+		resp.Bulk("mode"), resp.Bulk("standalone"),
+		resp.Bulk("role"), resp.Bulk(role),
+		resp.Bulk("modules"), resp.Array(),
+	), authenticatedNow
 }
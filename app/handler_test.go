@@ -0,0 +1,633 @@
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// TestSortedSetConcurrentAccess runs the sorted set readers alongside ZADD and
+// ZREM on the same key. It is meant to be run with -race.
+func TestSortedSetConcurrentAccess(t *testing.T) {
+	const key = "test:zset:concurrent"
+	defer func() {
+		setsmu.Lock()
+		delete(sets, key)
+		setsmu.Unlock()
+	}()
+
+	readers := []func(member string) resp.Value{
+		func(member string) resp.Value { return zscore(resp.BulkArray(key, member).Array) },
+		func(member string) resp.Value { return zrank(resp.BulkArray(key, member).Array) },
+		func(string) resp.Value { return zrange(resp.BulkArray(key, "0", "-1").Array) },
+		func(string) resp.Value { return zcard(resp.BulkArray(key).Array) },
+		func(member string) resp.Value { return geopos(resp.BulkArray(key, member).Array) },
+		func(member string) resp.Value { return geodist(resp.BulkArray(key, member, "m0").Array) },
+	}
+
+	stop := make(chan struct{})
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			member := "m" + strconv.Itoa(i%50)
+			zadd(resp.BulkArray(key, strconv.Itoa(i), member).Array)
+			if i%3 == 0 {
+				zrem(resp.BulkArray(key, member).Array)
+			}
+			runtime.Gosched()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, read := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				if v := read("m" + strconv.Itoa(i%50)); v.Typ == resp.ErrorType {
+					t.Errorf("unexpected error: %s", v.String())
+					return
+				}
+				// Yielding interleaves the goroutines even on a single CPU.
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	<-written
+}
+
+// TestStreamConcurrentRange runs XRANGE while XDEL and XTRIM compact the
+// entries of the same stream. It is meant to be run with -race.
+func TestStreamConcurrentRange(t *testing.T) {
+	const key = "test:stream:concurrent"
+	defer func() {
+		streams.mu.Lock()
+		delete(streams.entries, key)
+		streams.mu.Unlock()
+	}()
+
+	stop := make(chan struct{})
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			id := strconv.Itoa(i) + "-0"
+			xadd(resp.BulkArray(key, id, "field", "value").Array)
+			if i%2 == 0 {
+				xdel(resp.BulkArray(key, strconv.Itoa(i-1)+"-0").Array)
+			}
+			if i%5 == 0 {
+				xtrim(resp.BulkArray(key, "MAXLEN", "3").Array)
+			}
+			runtime.Gosched()
+		}
+	}()
+
+	for range 1000 {
+		if v := xrange(resp.BulkArray(key, "-", "+").Array); v.Typ == resp.ErrorType {
+			t.Fatalf("unexpected error: %s", v.String())
+		}
+		runtime.Gosched()
+	}
+
+	close(stop)
+	<-written
+}
+
+// call runs the handler of args[0] directly, outside of any connection.
+func call(args ...string) resp.Value {
+	return Handlers[strings.ToUpper(args[0])](resp.BulkArray(args[1:]...).Array)
+}
+
+// sameReply reports whether got and want encode to the same RESP3 reply.
+func sameReply(got, want resp.Value) bool {
+	return bytes.Equal(got.MarshalProtocol(3), want.MarshalProtocol(3))
+}
+
+func streamIDs(key string) []string {
+	streams.mu.RLock()
+	defer streams.mu.RUnlock()
+
+	ids := []string{}
+	for _, entry := range streams.entries[key].entries {
+		ids = append(ids, entry.id)
+	}
+
+	return ids
+}
+
+func TestStreamTrimming(t *testing.T) {
+	tests := []struct {
+		name string
+		// args follow the key in the command run on a stream holding the
+		// entries 1-0 to 5-0.
+		command string
+		args    []string
+		want    resp.Value
+		ids     []string
+	}{
+		{"xlen", "XLEN", nil, resp.Int(5), []string{"1-0", "2-0", "3-0", "4-0", "5-0"}},
+		{"xtrim maxlen", "XTRIM", []string{"MAXLEN", "2"}, resp.Int(3), []string{"4-0", "5-0"}},
+		{"xtrim exact maxlen", "XTRIM", []string{"MAXLEN", "=", "0"}, resp.Int(5), []string{}},
+		{"xtrim minid", "XTRIM", []string{"MINID", "3"}, resp.Int(2), []string{"3-0", "4-0", "5-0"}},
+		{"xtrim approximate limit", "XTRIM", []string{"maxlen", "~", "1", "LIMIT", "2"}, resp.Int(2), []string{"3-0", "4-0", "5-0"}},
+		{"xtrim exact limit", "XTRIM", []string{"MAXLEN", "1", "LIMIT", "2"}, resp.Err("ERR syntax error, LIMIT cannot be used without the special ~ option"), []string{"1-0", "2-0", "3-0", "4-0", "5-0"}},
+		{"xtrim negative maxlen", "XTRIM", []string{"MAXLEN", "-1"}, resp.Err("ERR The MAXLEN argument must be >= 0."), []string{"1-0", "2-0", "3-0", "4-0", "5-0"}},
+		{"xtrim trailing argument", "XTRIM", []string{"MAXLEN", "1", "x"}, resp.Err("ERR syntax error"), []string{"1-0", "2-0", "3-0", "4-0", "5-0"}},
+		{"xdel", "XDEL", []string{"2-0", "4", "9-0"}, resp.Int(2), []string{"1-0", "3-0", "5-0"}},
+		{"xdel invalid id", "XDEL", []string{"2-0", "x"}, resp.Err("ERR Invalid stream ID specified as stream command argument"), []string{"1-0", "2-0", "3-0", "4-0", "5-0"}},
+		{"xadd maxlen", "XADD", []string{"MAXLEN", "2", "6-0", "f", "v"}, resp.Bulk("6-0"), []string{"5-0", "6-0"}},
+		{"xadd minid", "XADD", []string{"MINID", "4-0", "6-0", "f", "v"}, resp.Bulk("6-0"), []string{"4-0", "5-0", "6-0"}},
+		{"xadd nomkstream", "XADD", []string{"NOMKSTREAM", "6-0", "f", "v"}, resp.Bulk("6-0"), []string{"1-0", "2-0", "3-0", "4-0", "5-0", "6-0"}},
+		{"xsetid", "XSETID", []string{"9-0"}, resp.OK(), []string{"1-0", "2-0", "3-0", "4-0", "5-0"}},
+		{"xsetid below the top item", "XSETID", []string{"4-0"}, resp.Err("ERR The ID specified in XSETID is smaller than the target stream top item"), []string{"1-0", "2-0", "3-0", "4-0", "5-0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The keyspace outlives a test run, so every case starts empty.
+			call("FLUSHALL")
+			key := "test:stream:" + tt.name
+			for i := 1; i <= 5; i++ {
+				call("XADD", key, strconv.Itoa(i)+"-0", "f", "v")
+			}
+
+			got := call(append([]string{tt.command, key}, tt.args...)...)
+			if !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+
+			if ids := streamIDs(key); !slices.Equal(ids, tt.ids) {
+				t.Fatalf("stream holds %q, want %q", ids, tt.ids)
+			}
+		})
+	}
+}
+
+func TestXAddNoMkStream(t *testing.T) {
+	const key = "test:stream:nomkstream"
+	if got := call("XADD", key, "NOMKSTREAM", "*", "f", "v"); !sameReply(got, resp.Null()) {
+		t.Fatalf("got %q, want a null reply", got.MarshalProtocol(3))
+	}
+
+	if got := call("TYPE", key); got.String() != "none" {
+		t.Fatalf("TYPE is %q after XADD NOMKSTREAM, want none", got.String())
+	}
+}
+
+func TestXInfo(t *testing.T) {
+	const key = "test:stream:xinfo"
+	call("FLUSHALL")
+	call("XADD", key, "1-0", "a", "1")
+	call("XADD", key, "2-0", "b", "2")
+	call("XADD", key, "3-0", "c", "3")
+	call("XDEL", key, "2-0")
+
+	first := resp.Array(resp.Bulk("1-0"), resp.BulkArray("a", "1"))
+	last := resp.Array(resp.Bulk("3-0"), resp.BulkArray("c", "3"))
+	summary := []resp.Value{
+		resp.Bulk("length"), resp.Int(2),
+		resp.Bulk("radix-tree-keys"), resp.Int(1),
+		resp.Bulk("radix-tree-nodes"), resp.Int(2),
+		resp.Bulk("last-generated-id"), resp.Bulk("3-0"),
+		resp.Bulk("max-deleted-entry-id"), resp.Bulk("2-0"),
+		resp.Bulk("entries-added"), resp.Int(3),
+		resp.Bulk("recorded-first-entry-id"), resp.Bulk("1-0"),
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want resp.Value
+	}{
+		{
+			name: "stream",
+			args: []string{"STREAM", key},
+			want: resp.Map(append(slices.Clone(summary), resp.Bulk("groups"), resp.Int(0), resp.Bulk("first-entry"), first, resp.Bulk("last-entry"), last)...),
+		},
+		{
+			name: "full",
+			args: []string{"STREAM", key, "FULL"},
+			want: resp.Map(append(slices.Clone(summary), resp.Bulk("entries"), resp.Array(first, last), resp.Bulk("groups"), resp.Array())...),
+		},
+		{
+			name: "full with count",
+			args: []string{"stream", key, "full", "count", "1"},
+			want: resp.Map(append(slices.Clone(summary), resp.Bulk("entries"), resp.Array(first), resp.Bulk("groups"), resp.Array())...),
+		},
+		{name: "count without full", args: []string{"STREAM", key, "COUNT", "1"}, want: resp.Err("ERR wrong number of arguments for 'xinfo|stream' command")},
+		{name: "unknown option", args: []string{"STREAM", key, "PARTIAL"}, want: resp.Err("ERR syntax error")},
+		{name: "invalid count", args: []string{"STREAM", key, "FULL", "COUNT", "x"}, want: resp.Err("ERR value is not an integer or out of range")},
+		{name: "missing stream", args: []string{"STREAM", "test:stream:missing"}, want: resp.Err("ERR no such key")},
+		{name: "groups", args: []string{"GROUPS", key}, want: resp.Array()},
+		{name: "consumers", args: []string{"CONSUMERS", key, "g"}, want: resp.Err("NOGROUP No such consumer group 'g' for key name '" + key + "'")},
+		{name: "unknown subcommand", args: []string{"HELP"}, want: resp.Err("ERR unknown subcommand 'HELP'. Try XINFO HELP.")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := call(append([]string{"XINFO"}, tt.args...)...)
+			if !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+		})
+	}
+}
+
+func TestXReadBlocking(t *testing.T) {
+	const key, other = "test:xread", "test:xread:other"
+	entry := func(id, field, value string) resp.Value {
+		return resp.Array(resp.Bulk(id), resp.BulkArray(field, value))
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		// write runs once XREAD waits on key.
+		write []string
+		want  resp.Value
+	}{
+		{
+			name: "count",
+			args: []string{"COUNT", "1", "STREAMS", key, "0"},
+			want: resp.Array(resp.Array(resp.Bulk(key), resp.Array(entry("1-0", "a", "1")))),
+		},
+		{
+			name: "last entry",
+			args: []string{"STREAMS", key, "+"},
+			want: resp.Array(resp.Array(resp.Bulk(key), resp.Array(entry("2-0", "b", "2")))),
+		},
+		{name: "nothing new", args: []string{"STREAMS", key, "2-0"}, want: resp.NullArray()},
+		{name: "timeout", args: []string{"BLOCK", "10", "STREAMS", key, "$"}, want: resp.NullArray()},
+		{
+			name:  "woken by xadd",
+			args:  []string{"BLOCK", "0", "STREAMS", key, "$"},
+			write: []string{"XADD", key, "3-0", "c", "3"},
+			want:  resp.Array(resp.Array(resp.Bulk(key), resp.Array(entry("3-0", "c", "3")))),
+		},
+		{
+			name:  "woken by one of the streams",
+			args:  []string{"BLOCK", "0", "STREAMS", other, key, "$", "$"},
+			write: []string{"XADD", key, "3-0", "c", "3"},
+			want:  resp.Array(resp.Array(resp.Bulk(key), resp.Array(entry("3-0", "c", "3")))),
+		},
+		{
+			name:  "write to another stream",
+			args:  []string{"BLOCK", "20", "STREAMS", key, "$"},
+			write: []string{"XADD", other, "1-0", "c", "3"},
+			want:  resp.NullArray(),
+		},
+		{name: "negative timeout", args: []string{"BLOCK", "-1", "STREAMS", key, "$"}, want: resp.Err("ERR timeout is negative")},
+		{
+			name: "unbalanced streams",
+			args: []string{"STREAMS", key, other, "$"},
+			want: resp.Err("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			streams.mu.Lock()
+			delete(streams.entries, key)
+			delete(streams.entries, other)
+			streams.mu.Unlock()
+
+			call("XADD", key, "1-0", "a", "1")
+			call("XADD", key, "2-0", "b", "2")
+
+			replies := make(chan resp.Value, 1)
+			go func() {
+				replies <- ExecuteCommand(xread, resp.BulkArray(tt.args...).Array)
+			}()
+
+			if tt.write != nil {
+				for {
+					streams.mu.RLock()
+					waiting := len(streams.waiters[key]) > 0
+					streams.mu.RUnlock()
+					if waiting {
+						break
+					}
+
+					runtime.Gosched()
+				}
+
+				call(tt.write...)
+			}
+
+			if got := <-replies; !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+		})
+	}
+}
+
+func TestStreamFieldOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		// entries are the fields and values of each entry, added as 1-0,
+		// 2-0 and so on.
+		entries [][]string
+		// shared tells which entries reuse the field names of the first one.
+		shared []bool
+	}{
+		{name: "order kept", entries: [][]string{{"z", "1", "a", "2", "m", "3"}}, shared: []bool{true}},
+		{name: "duplicate fields", entries: [][]string{{"a", "1", "a", "2"}}, shared: []bool{true}},
+		{name: "same fields", entries: [][]string{{"a", "1", "b", "2"}, {"a", "3", "b", "4"}}, shared: []bool{true, true}},
+		{name: "other order", entries: [][]string{{"a", "1", "b", "2"}, {"b", "3", "a", "4"}}, shared: []bool{true, false}},
+		{name: "other fields", entries: [][]string{{"a", "1"}, {"c", "2"}, {"a", "3"}}, shared: []bool{true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call("FLUSHALL")
+			key := "test:stream:order:" + tt.name
+			want := resp.Array()
+			for i, fields := range tt.entries {
+				id := strconv.Itoa(i+1) + "-0"
+				call(append([]string{"XADD", key, id}, fields...)...)
+				want.Array = append(want.Array, resp.Array(resp.Bulk(id), resp.BulkArray(fields...)))
+			}
+
+			if got := call("XRANGE", key, "-", "+"); !sameReply(got, want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), want.MarshalProtocol(3))
+			}
+
+			streams.mu.RLock()
+			entries := streams.entries[key].entries
+			streams.mu.RUnlock()
+			for i, entry := range entries {
+				if shared := &entry.fields[0] == &entries[0].fields[0]; shared != tt.shared[i] {
+					t.Fatalf("entry %s shares the master fields: %v, want %v", entry.id, shared, tt.shared[i])
+				}
+			}
+		})
+	}
+}
+
+// TestStreamMasterReset checks that emptying the current block drops its
+// master fields, so that the next entry doesn't inherit them.
+func TestStreamMasterReset(t *testing.T) {
+	tests := []struct {
+		name  string
+		empty []string
+	}{
+		{name: "xdel", empty: []string{"XDEL", "1-0", "2-0"}},
+		{name: "xtrim", empty: []string{"XTRIM", "MAXLEN", "0"}},
+		{name: "xtrim minid", empty: []string{"XTRIM", "MINID", "3-0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call("FLUSHALL")
+			key := "test:stream:reset:" + tt.name
+			call("XADD", key, "1-0", "a", "1")
+			call("XADD", key, "2-0", "a", "2")
+			call(append([]string{tt.empty[0], key}, tt.empty[1:]...)...)
+			call("XADD", key, "3-0", "b", "3")
+
+			streams.mu.RLock()
+			stream := streams.entries[key]
+			streams.mu.RUnlock()
+			if !slices.Equal(stream.master, []string{"b"}) || stream.masterCount != 1 {
+				t.Fatalf("master is %q for %d entries, want [b] for 1", stream.master, stream.masterCount)
+			}
+		})
+	}
+}
+
+// addSicily adds the members used by the Redis documentation of the geo
+// commands to key.
+func addSicily(key string) {
+	call("GEOADD", key,
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania",
+		"12.758489", "38.788135", "edge1",
+		"17.241510", "38.788135", "edge2",
+	)
+}
+
+func TestGeoSearch(t *testing.T) {
+	const key = "test:geo:search"
+	addSicily(key)
+
+	withDist := func(member, dist string) resp.Value { return resp.BulkArray(member, dist) }
+	tests := []struct {
+		name string
+		args []string
+		want resp.Value
+	}{
+		{"radius", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"}, resp.BulkArray("Catania", "Palermo")},
+		{"descending", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC"}, resp.BulkArray("Palermo", "Catania")},
+		{"count sorts", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "COUNT", "1"}, resp.BulkArray("Catania")},
+		{"count any", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "COUNT", "1", "ANY"}, resp.Array()},
+		{
+			"box with distances",
+			[]string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHDIST"},
+			resp.Array(withDist("Catania", "56.4413"), withDist("Palermo", "190.4424"), withDist("edge2", "279.7403"), withDist("edge1", "279.7405")),
+		},
+		{"from member", []string{"GEOSEARCH", key, "FROMMEMBER", "Palermo", "BYRADIUS", "200", "km", "ASC"}, resp.BulkArray("Palermo", "edge1", "Catania")},
+		{
+			"with hash",
+			[]string{"GEOSEARCH", key, "FROMMEMBER", "Palermo", "BYRADIUS", "1", "km", "WITHHASH"},
+			resp.Array(resp.Array(resp.Bulk("Palermo"), resp.Int(3479099956230698))),
+		},
+		{"missing key", []string{"GEOSEARCH", "test:geo:missing", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"}, resp.Array()},
+		{"georadius", []string{"GEORADIUS", key, "15", "37", "200", "km", "ASC"}, resp.BulkArray("Catania", "Palermo")},
+		{"georadiusbymember", []string{"GEORADIUSBYMEMBER_RO", key, "Palermo", "200", "km", "ASC"}, resp.BulkArray("Palermo", "edge1", "Catania")},
+		{"two centers", []string{"GEOSEARCH", key, "FROMMEMBER", "Palermo", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km"}, resp.Err("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")},
+		{"no center", []string{"GEOSEARCH", key, "BYRADIUS", "1", "km"}, resp.Err("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")},
+		{"two shapes", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "BYBOX", "1", "1", "km"}, resp.Err("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")},
+		{"no shape", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37"}, resp.Err("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")},
+		{"any without count", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "ANY"}, resp.Err("ERR the ANY argument requires COUNT argument")},
+		{"zero count", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "COUNT", "0"}, resp.Err("ERR COUNT must be > 0")},
+		{"unknown member", []string{"GEOSEARCH", key, "FROMMEMBER", "Rome", "BYRADIUS", "1", "km"}, resp.Err("ERR could not decode requested zset member")},
+		{"unknown unit", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "1", "parsec"}, resp.Err("ERR unsupported unit provided. please use M, KM, FT, MI")},
+		{"negative radius", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "-1", "km"}, resp.Err("ERR radius cannot be negative")},
+		{"store on geosearch", []string{"GEOSEARCH", key, "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "STORE", "x"}, resp.Err("ERR syntax error")},
+		{"store on a read-only variant", []string{"GEORADIUS_RO", key, "15", "37", "1", "km", "STORE", "x"}, resp.Err("ERR syntax error")},
+		{"store with distances", []string{"GEOSEARCHSTORE", "x", key, "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km", "WITHDIST"}, resp.Err("ERR STORE option is not compatible with WITHDIST, WITHHASH and WITHCOORD options")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := call(tt.args...); !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+		})
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	const key = "test:geo:store"
+	addSicily(key)
+
+	tests := []struct {
+		name string
+		args []string
+		want resp.Value
+		// members are the members of the destination, ordered by score.
+		members resp.Value
+	}{
+		{"store hashes", []string{"GEOSEARCHSTORE", "DST", key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"}, resp.Int(2), resp.BulkArray("Palermo", "Catania")},
+		{"store distances", []string{"GEOSEARCHSTORE", "DST", key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "STOREDIST"}, resp.Int(2), resp.BulkArray("Catania", "Palermo")},
+		{"store count", []string{"GEOSEARCHSTORE", "DST", key, "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC", "COUNT", "1"}, resp.Int(1), resp.BulkArray("Palermo")},
+		{"georadius store", []string{"GEORADIUS", key, "15", "37", "200", "km", "STOREDIST", "DST"}, resp.Int(2), resp.BulkArray("Catania", "Palermo")},
+		{"store nothing", []string{"GEOSEARCHSTORE", "DST", key, "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km"}, resp.Int(0), resp.Array()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := "test:geo:store:" + tt.name
+			addSicily(dst)
+			args := slices.Clone(tt.args)
+			args[slices.Index(args, "DST")] = dst
+
+			if got := call(args...); !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+
+			if got := call("ZRANGE", dst, "0", "-1"); !sameReply(got, tt.members) {
+				t.Fatalf("destination holds %q, want %q", got.MarshalProtocol(3), tt.members.MarshalProtocol(3))
+			}
+		})
+	}
+}
+
+func TestGeoAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		// args follow the key of a set holding Palermo.
+		args []string
+		want resp.Value
+		card int
+		// moved tells whether Palermo has a new position.
+		moved bool
+	}{
+		{name: "add", args: []string{"15.087269", "37.502669", "Catania"}, want: resp.Int(1), card: 2},
+		{name: "update", args: []string{"13", "38", "Palermo"}, want: resp.Int(0), card: 1, moved: true},
+		{name: "update with ch", args: []string{"CH", "13", "38", "Palermo"}, want: resp.Int(1), card: 1, moved: true},
+		{name: "same position with ch", args: []string{"CH", "13.361389", "38.115556", "Palermo"}, want: resp.Int(0), card: 1},
+		{name: "nx", args: []string{"NX", "13", "38", "Palermo", "15.087269", "37.502669", "Catania"}, want: resp.Int(1), card: 2},
+		{name: "xx", args: []string{"XX", "13", "38", "Palermo", "15.087269", "37.502669", "Catania"}, want: resp.Int(0), card: 1, moved: true},
+		{name: "xx with ch", args: []string{"xx", "ch", "13", "38", "Palermo"}, want: resp.Int(1), card: 1, moved: true},
+		{name: "nx and xx", args: []string{"NX", "XX", "13", "38", "Palermo"}, want: resp.Err("ERR XX and NX options at the same time are not compatible"), card: 1},
+		{name: "incomplete triplet", args: []string{"15.087269", "37.502669", "Catania", "13"}, want: resp.Err("ERR syntax error"), card: 1},
+		{name: "invalid longitude", args: []string{"15.087269", "37.502669", "Catania", "181", "38", "Rome"}, want: resp.Err("ERR longitude is not a float or out of range"), card: 1},
+		{name: "invalid latitude", args: []string{"13", "86", "Palermo"}, want: resp.Err("ERR latitude is not a float or out of range"), card: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call("FLUSHALL")
+			key := "test:geo:add:" + tt.name
+			call("GEOADD", key, "13.361389", "38.115556", "Palermo")
+			before := call("ZSCORE", key, "Palermo")
+
+			if got := call(append([]string{"GEOADD", key}, tt.args...)...); !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+
+			if got := call("ZCARD", key); !sameReply(got, resp.Int(tt.card)) {
+				t.Fatalf("ZCARD is %q, want %d", got.MarshalProtocol(3), tt.card)
+			}
+
+			if moved := !sameReply(call("ZSCORE", key, "Palermo"), before); moved != tt.moved {
+				t.Fatalf("Palermo moved: %v, want %v", moved, tt.moved)
+			}
+		})
+	}
+}
+
+func TestGeoHashAndDist(t *testing.T) {
+	const key = "test:geo:hash"
+	addSicily(key)
+
+	tests := []struct {
+		name string
+		args []string
+		want resp.Value
+	}{
+		{"geohash", []string{"GEOHASH", key, "Palermo", "Catania", "Rome"}, resp.Array(resp.Bulk("sqc8b49rny0"), resp.Bulk("sqdtr74hyu0"), resp.Null())},
+		{"geohash of a missing key", []string{"GEOHASH", "test:geo:missing", "Palermo"}, resp.Array(resp.Null())},
+		{"geohash without members", []string{"GEOHASH", key}, resp.Array()},
+		{"meters", []string{"GEODIST", key, "Palermo", "Catania"}, resp.Bulk("166274.1516")},
+		{"kilometers", []string{"GEODIST", key, "Palermo", "Catania", "km"}, resp.Bulk("166.2742")},
+		{"miles", []string{"GEODIST", key, "Palermo", "Catania", "MI"}, resp.Bulk("103.3182")},
+		{"feet", []string{"GEODIST", key, "Palermo", "Catania", "ft"}, resp.Bulk("545518.8700")},
+		{"unknown unit", []string{"GEODIST", key, "Palermo", "Catania", "yd"}, resp.Err("ERR unsupported unit provided. please use M, KM, FT, MI")},
+		{"unknown member", []string{"GEODIST", key, "Palermo", "Rome"}, resp.Null()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := call(tt.args...); !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+		})
+	}
+}
+
+func TestQueueCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want resp.Value
+	}{
+		{"ping", []string{"PING"}, resp.Simple("QUEUED")},
+		{"set", []string{"set", "k", "v", "PX", "100"}, resp.Simple("QUEUED")},
+		{"get", []string{"GET", "k"}, resp.Simple("QUEUED")},
+		{"unknown command", []string{"NOSUCH", "k"}, resp.Err("ERR unknown command NOSUCH")},
+		{"arity", []string{"GET"}, resp.Err("ERR wrong number of arguments for 'get' command")},
+		{"subscribe", []string{"subscribe", "news"}, resp.Err("ERR Command not allowed inside a transaction")},
+		{"psubscribe", []string{"PSUBSCRIBE", "news.*"}, resp.Err("ERR Command not allowed inside a transaction")},
+		{"shutdown", []string{"SHUTDOWN"}, resp.Err("ERR Command not allowed inside a transaction")},
+		{"set syntax", []string{"SET", "k", "v", "BAD", "1"}, resp.Err("ERR syntax error")},
+		{"set expiry", []string{"SET", "k", "v", "EX", "soon"}, resp.Err("value is not an integer or out of range")},
+		{"xadd threshold", []string{"XADD", "s", "MAXLEN", "many", "*", "f", "v"}, resp.Err("ERR value is not an integer or out of range")},
+		{"xdel id", []string{"XDEL", "s", "1-x"}, resp.Err("ERR Invalid stream ID specified as stream command argument")},
+		{"xtrim strategy", []string{"XTRIM", "s", "MAXSIZE", "1"}, resp.Err("ERR syntax error")},
+		{"zadd score", []string{"ZADD", "z", "high", "m"}, resp.Err("ERR score is not a float or out of range")},
+		{"geoadd longitude", []string{"GEOADD", "g", "181", "38", "Rome"}, resp.Err("ERR longitude is not a float or out of range")},
+		{"geosearch shape", []string{"GEOSEARCH", "g", "FROMLONLAT", "13", "38", "WITHDIST", "ASC"}, resp.Err("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")},
+		{"georadius unit", []string{"GEORADIUS", "g", "13", "38", "10", "yd"}, resp.Err("ERR unsupported unit provided. please use M, KM, FT, MI")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := &Queue{active: true}
+			got := queueCommand(queue, resp.BulkArray(tt.args...))
+			if !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+
+			// A rejected command aborts the transaction instead of being queued.
+			queued := got.Typ != resp.ErrorType
+			if len(queue.items) == 1 != queued || queue.dirty == queued {
+				t.Fatalf("queued %d commands with dirty %v", len(queue.items), queue.dirty)
+			}
+		})
+	}
+}
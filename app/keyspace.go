@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// Keyspace serializes transactions against every other command. Commands run
+// under the read lock, while EXEC holds the write lock for the whole queue so
+// that no foreign command can interleave with it.
+type Keyspace struct {
+	sync.RWMutex
+	// exclusive is set while a transaction holds the write lock. Blocking
+	// commands check it to reply right away instead of waiting.
+	exclusive bool
+	// effects collects the write commands run while the keyspace is held
+	// exclusively, which are then replicated as one transaction.
+	effects []resp.Value
+}
+
+var keyspace Keyspace
+
+// runExclusive runs fn while holding the write lock on the keyspace.
+func (k *Keyspace) runExclusive(fn func()) {
+	k.Lock()
+	defer k.Unlock()
+
+	k.exclusive = true
+	defer func() { k.exclusive = false; k.effects = nil }()
+
+	fn()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+var (
+	testServerOnce sync.Once
+	testServerAddr string
+)
+
+// startTestServer serves connections on a random local port, once for the
+// whole test binary since the server state is global.
+func startTestServer(tb testing.TB) string {
+	testServerOnce.Do(func() {
+		server = NewServer()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		server.listener = l
+		go server.propagateLoop()
+		go server.Accept()
+		testServerAddr = l.Addr().String()
+	})
+
+	return testServerAddr
+}
+
+// dialTestServer connects to the test server, closing the connection when
+// the test ends.
+func dialTestServer(t *testing.T) *client.Conn {
+	t.Helper()
+
+	conn, err := client.Dial(context.Background(), client.Options{Addr: startTestServer(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func BenchmarkPipeline1(b *testing.B)   { benchmarkPipeline(b, 1) }
+func BenchmarkPipeline16(b *testing.B)  { benchmarkPipeline(b, 16) }
+func BenchmarkPipeline128(b *testing.B) { benchmarkPipeline(b, 128) }
+
+// benchmarkPipeline sends b.N SET commands in batches of depth, waiting for
+// all the replies of a batch before sending the next one.
+func benchmarkPipeline(b *testing.B, depth int) {
+	ctx := context.Background()
+	conn, err := client.Dial(ctx, client.Options{Addr: startTestServer(b)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	cmd := resp.BulkArray("SET", "pipeline-bench:key", "value")
+
+	b.ResetTimer()
+	for sent := 0; sent < b.N; sent += depth {
+		n := min(depth, b.N-sent)
+		for range n {
+			if err := conn.Send(cmd); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		if err := conn.Flush(ctx); err != nil {
+			b.Fatal(err)
+		}
+
+		for range n {
+			reply, err := conn.Receive(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if reply.Typ == resp.ErrorType {
+				b.Fatalf("unexpected reply: %s", reply.String())
+			}
+		}
+	}
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "requests/s")
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/internal/cluster"
+	"github.com/codecrafters-io/redis-starter-go/internal/glob"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// PubSub is the registry of subscriptions. A channel or pattern is only
+// present while it has at least one subscriber.
+type PubSub struct {
+	mu            sync.RWMutex
+	channels      map[string]map[*Client]bool
+	patterns      map[string]map[*Client]bool
+	shardChannels map[string]map[*Client]bool
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{
+		channels:      make(map[string]map[*Client]bool),
+		patterns:      make(map[string]map[*Client]bool),
+		shardChannels: make(map[string]map[*Client]bool),
+	}
+}
+
+// Subscribe registers client on every channel and queues one confirmation per
+// channel. Both happen under the lock so that no message can overtake them.
+func (ps *PubSub) Subscribe(client *Client, channels []resp.Value) {
+	ps.subscribe(client, channels, ps.channels, client.channels, "subscribe", client.subscriptions)
+}
+
+// Unsubscribe removes client from the given channels, or from all of its
+// channels when none are given.
+func (ps *PubSub) Unsubscribe(client *Client, channels []resp.Value) {
+	ps.unsubscribe(client, channels, ps.channels, client.channels, "unsubscribe", client.subscriptions)
+}
+
+func (ps *PubSub) PSubscribe(client *Client, patterns []resp.Value) {
+	ps.subscribe(client, patterns, ps.patterns, client.patterns, "psubscribe", client.subscriptions)
+}
+
+func (ps *PubSub) PUnsubscribe(client *Client, patterns []resp.Value) {
+	ps.unsubscribe(client, patterns, ps.patterns, client.patterns, "punsubscribe", client.subscriptions)
+}
+
+// SSubscribe subscribes client to shard channels, which live in their own
+// namespace and are counted separately from classic channels and patterns.
+func (ps *PubSub) SSubscribe(client *Client, channels []resp.Value) {
+	ps.subscribe(client, channels, ps.shardChannels, client.shardChannels, "ssubscribe", client.shardSubscriptions)
+}
+
+func (ps *PubSub) SUnsubscribe(client *Client, channels []resp.Value) {
+	ps.unsubscribe(client, channels, ps.shardChannels, client.shardChannels, "sunsubscribe", client.shardSubscriptions)
+}
+
+func (ps *PubSub) subscribe(client *Client, names []resp.Value, registry map[string]map[*Client]bool, subscribed map[string]bool, kind string, count func() int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	client.startQueue()
+	for _, name := range names {
+		if !subscribed[name.String()] {
+			subscribed[name.String()] = true
+			if registry[name.String()] == nil {
+				registry[name.String()] = make(map[*Client]bool)
+			}
+
+			registry[name.String()][client] = true
+		}
+
+		client.enqueue(subscriptionReply(kind, name, count()).to(client))
+	}
+}
+
+func (ps *PubSub) unsubscribe(client *Client, names []resp.Value, registry map[string]map[*Client]bool, subscribed map[string]bool, kind string, count func() int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	client.startQueue()
+	if len(names) == 0 {
+		for name := range subscribed {
+			names = append(names, resp.Bulk(name))
+		}
+	}
+
+	if len(names) == 0 {
+		client.enqueue(subscriptionReply(kind, resp.Null(), count()).to(client))
+		return
+	}
+
+	for _, name := range names {
+		remove(client, name.String(), registry, subscribed)
+		client.enqueue(subscriptionReply(kind, name, count()).to(client))
+	}
+}
+
+// RemoveClient drops every subscription of a disconnected client.
+func (ps *PubSub) RemoveClient(client *Client) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for name := range client.channels {
+		remove(client, name, ps.channels, client.channels)
+	}
+
+	for pattern := range client.patterns {
+		remove(client, pattern, ps.patterns, client.patterns)
+	}
+
+	for name := range client.shardChannels {
+		remove(client, name, ps.shardChannels, client.shardChannels)
+	}
+}
+
+func remove(client *Client, name string, registry map[string]map[*Client]bool, subscribed map[string]bool) {
+	delete(subscribed, name)
+	delete(registry[name], client)
+	if len(registry[name]) == 0 {
+		delete(registry, name)
+	}
+}
+
+// Publish queues message for every subscriber of channel and of the patterns
+// matching it without waiting for any of them, and returns the number of
+// deliveries.
+func (ps *PubSub) Publish(channel, message string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	receivers := 0
+	if subscribers := ps.channels[channel]; len(subscribers) > 0 {
+		msg := newPush(
+			resp.Bulk("message"),
+			resp.Bulk(channel),
+			resp.Bulk(message),
+		)
+
+		for client := range subscribers {
+			client.enqueue(msg.to(client))
+		}
+
+		receivers += len(subscribers)
+	}
+
+	for pattern, subscribers := range ps.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+
+		msg := newPush(
+			resp.Bulk("pmessage"),
+			resp.Bulk(pattern),
+			resp.Bulk(channel),
+			resp.Bulk(message),
+		)
+
+		for client := range subscribers {
+			client.enqueue(msg.to(client))
+		}
+
+		receivers += len(subscribers)
+	}
+
+	return receivers
+}
+
+// SPublish delivers message to the subscribers of a shard channel.
+func (ps *PubSub) SPublish(channel, message string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	subscribers := ps.shardChannels[channel]
+	if len(subscribers) == 0 {
+		return 0
+	}
+
+	msg := newPush(
+		resp.Bulk("smessage"),
+		resp.Bulk(channel),
+		resp.Bulk(message),
+	)
+
+	for client := range subscribers {
+		client.enqueue(msg.to(client))
+	}
+
+	return len(subscribers)
+}
+
+func subscriptionReply(kind string, name resp.Value, count int) push {
+	return newPush(
+		resp.Bulk(kind),
+		name,
+		resp.Int(count),
+	)
+}
+
+// push is a message encoded for both protocols: as a push reply for RESP3
+// clients and as an array for RESP2 ones. Encoding it up front keeps the cost
+// of a publish independent of the number of subscribers.
+type push [2][]byte
+
+func newPush(values ...resp.Value) push {
+	v := resp.Push(values...)
+	return push{v.MarshalProtocol(2), v.MarshalProtocol(3)}
+}
+
+func (p push) to(client *Client) []byte {
+	if client.Protocol() == 3 {
+		return p[1]
+	}
+
+	return p[0]
+}
+
+func publish(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Err("ERR wrong number of arguments for 'publish' command")
+	}
+
+	return resp.Int(server.pubsub.Publish(args[0].String(), args[1].String()))
+}
+
+func spublish(args []resp.Value) resp.Value {
+	if len(args) != 2 {
+		return resp.Err("ERR wrong number of arguments for 'spublish' command")
+	}
+
+	return resp.Int(server.pubsub.SPublish(args[0].String(), args[1].String()))
+}
+
+// sameSlot reports whether all shard channels hash to the same slot, as shard
+// commands may only address a single slot at a time in cluster mode.
+func sameSlot(channels []resp.Value) bool {
+	for _, channel := range channels[min(1, len(channels)):] {
+		if cluster.KeySlot(channel.String()) != cluster.KeySlot(channels[0].String()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func pubsubCommand(args []resp.Value) resp.Value {
+	if len(args) < 1 {
+		return resp.Err("ERR wrong number of arguments for 'pubsub' command")
+	}
+
+	return server.pubsub.introspect(args)
+}
+
+// introspect runs the PUBSUB subcommand in args against the registry.
+func (ps *PubSub) introspect(args []resp.Value) resp.Value {
+	subcommand := strings.ToUpper(args[0].String())
+	switch subcommand {
+	case "CHANNELS", "SHARDCHANNELS":
+		if len(args) > 2 {
+			return resp.Errf("ERR wrong number of arguments for 'pubsub|%v' command", strings.ToLower(subcommand))
+		}
+
+		pattern := "*"
+		if len(args) == 2 {
+			pattern = args[1].String()
+		}
+
+		return ps.activeChannels(subcommand == "SHARDCHANNELS", pattern)
+	case "NUMSUB", "SHARDNUMSUB":
+		return ps.numSub(subcommand == "SHARDNUMSUB", args[1:])
+	case "NUMPAT":
+		if len(args) != 1 {
+			return resp.Err("ERR wrong number of arguments for 'pubsub|numpat' command")
+		}
+
+		ps.mu.RLock()
+		defer ps.mu.RUnlock()
+
+		return resp.Int(len(ps.patterns))
+	default:
+		return resp.Errf("ERR unknown subcommand '%v'. Try PUBSUB HELP.", args[0].String())
+	}
+}
+
+func (ps *PubSub) activeChannels(shard bool, pattern string) resp.Value {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	registry := ps.channels
+	if shard {
+		registry = ps.shardChannels
+	}
+
+	ret := resp.Array()
+	for name := range registry {
+		if glob.Match(pattern, name) {
+			ret.Array = append(ret.Array, resp.Bulk(name))
+		}
+	}
+
+	return ret
+}
+
+func (ps *PubSub) numSub(shard bool, channels []resp.Value) resp.Value {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	registry := ps.channels
+	if shard {
+		registry = ps.shardChannels
+	}
+
+	ret := resp.Map()
+	for _, channel := range channels {
+		ret.Array = append(ret.Array, channel, resp.Int(len(registry[channel.String()])))
+	}
+
+	return ret
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// pipeClient returns a client speaking protocol whose output is read from the
+// returned reader, as if it was connected.
+func pipeClient(t *testing.T, protocol int) (*Client, *bufio.Reader) {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	client := NewClient(serverConn)
+	client.protocol.Store(int32(protocol))
+	return client, bufio.NewReader(clientConn)
+}
+
+// expect reads len(want) bytes from r and checks that they are want.
+func expect(t *testing.T, r *bufio.Reader, want string) {
+	t.Helper()
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatalf("reading %q: %v", want, err)
+	}
+
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestPublishEncoding(t *testing.T) {
+	tests := []struct {
+		protocol  int
+		subscribe string
+		message   string
+	}{
+		{
+			protocol:  2,
+			subscribe: "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n",
+			message:   "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+		},
+		{
+			protocol:  3,
+			subscribe: ">3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n",
+			message:   ">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run("RESP"+strconv.Itoa(tt.protocol), func(t *testing.T) {
+			ps := NewPubSub()
+			client, r := pipeClient(t, tt.protocol)
+
+			ps.Subscribe(client, resp.BulkArray("news").Array)
+			if n := ps.Publish("news", "hello"); n != 1 {
+				t.Fatalf("PUBLISH reached %d subscribers, want 1", n)
+			}
+
+			if n := ps.Publish("other", "hello"); n != 0 {
+				t.Fatalf("PUBLISH to another channel reached %d subscribers, want 0", n)
+			}
+
+			expect(t, r, tt.subscribe)
+			expect(t, r, tt.message)
+		})
+	}
+}
+
+// TestPublishSlowSubscriber checks that a subscriber that stops reading
+// neither blocks the publisher nor delays the other subscribers, and that it
+// is disconnected once its queue is full.
+func TestPublishSlowSubscriber(t *testing.T) {
+	ps := NewPubSub()
+	fast, fastReader := pipeClient(t, 2)
+	slow, slowReader := pipeClient(t, 2)
+	ps.Subscribe(fast, resp.BulkArray("news").Array)
+	ps.Subscribe(slow, resp.BulkArray("news").Array)
+
+	// The fast subscriber reads every message before the next one is
+	// published, so only the slow one overflows its queue.
+	received := make(chan resp.Value)
+	go func() {
+		reader := &resp.Resp{Reader: fastReader}
+		for {
+			msg, err := reader.Read()
+			if err != nil {
+				close(received)
+				return
+			}
+
+			received <- msg
+		}
+	}()
+	<-received
+
+	published := make(chan error, 1)
+	go func() {
+		for i := range clientQueueLen + 10 {
+			ps.Publish("news", strconv.Itoa(i))
+			msg, ok := <-received
+			if !ok || msg.Array[2].String() != strconv.Itoa(i) {
+				published <- fmt.Errorf("message %d is %v", i, msg)
+				return
+			}
+		}
+
+		published <- nil
+	}()
+
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatalf("fast subscriber: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("PUBLISH blocked on the slow subscriber")
+	}
+
+	// The slow subscriber's connection was closed, so reading it ends after
+	// what was written before.
+	if _, err := io.Copy(io.Discard, slowReader); err != nil && err != io.ErrClosedPipe {
+		t.Fatalf("slow subscriber: %v", err)
+	}
+}
+
+func TestPatternPublish(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels []string
+		patterns []string
+		publish  string
+		want     int
+	}{
+		{name: "pattern", patterns: []string{"news.*"}, publish: "news.tech", want: 1},
+		{name: "no match", patterns: []string{"news.*"}, publish: "sport", want: 0},
+		{name: "two patterns", patterns: []string{"news.*", "*.tech"}, publish: "news.tech", want: 2},
+		{name: "channel and pattern", channels: []string{"news.tech"}, patterns: []string{"news.*"}, publish: "news.tech", want: 2},
+		{name: "same pattern twice", patterns: []string{"news.*", "news.*"}, publish: "news.tech", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := NewPubSub()
+			client, _ := pipeClient(t, 2)
+			ps.Subscribe(client, resp.BulkArray(tt.channels...).Array)
+			ps.PSubscribe(client, resp.BulkArray(tt.patterns...).Array)
+
+			if got := ps.Publish(tt.publish, "hello"); got != tt.want {
+				t.Fatalf("PUBLISH reached %d subscribers, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPUnsubscribe(t *testing.T) {
+	ps := NewPubSub()
+	client, r := pipeClient(t, 2)
+
+	ps.PSubscribe(client, resp.BulkArray("news.*", "*.tech").Array)
+	ps.Publish("news.tech", "hello")
+	ps.PUnsubscribe(client, resp.BulkArray("news.*").Array)
+	ps.Publish("news.sport", "ignored")
+	ps.PUnsubscribe(client, nil)
+	ps.PUnsubscribe(client, nil)
+
+	if n := ps.Publish("news.tech", "ignored"); n != 0 {
+		t.Fatalf("PUBLISH reached %d subscribers after PUNSUBSCRIBE, want 0", n)
+	}
+
+	expect(t, r, "*3\r\n$10\r\npsubscribe\r\n$6\r\nnews.*\r\n:1\r\n")
+	expect(t, r, "*3\r\n$10\r\npsubscribe\r\n$6\r\n*.tech\r\n:2\r\n")
+	// Patterns are matched in no particular order.
+	first := "*4\r\n$8\r\npmessage\r\n$6\r\nnews.*\r\n$9\r\nnews.tech\r\n$5\r\nhello\r\n"
+	second := "*4\r\n$8\r\npmessage\r\n$6\r\n*.tech\r\n$9\r\nnews.tech\r\n$5\r\nhello\r\n"
+	if peek, _ := r.Peek(len(first)); string(peek) == second {
+		first, second = second, first
+	}
+	expect(t, r, first)
+	expect(t, r, second)
+	expect(t, r, "*3\r\n$12\r\npunsubscribe\r\n$6\r\nnews.*\r\n:1\r\n")
+	expect(t, r, "*3\r\n$12\r\npunsubscribe\r\n$6\r\n*.tech\r\n:0\r\n")
+	expect(t, r, "*3\r\n$12\r\npunsubscribe\r\n$-1\r\n:0\r\n")
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	ps := NewPubSub()
+	a, _ := pipeClient(t, 2)
+	b, _ := pipeClient(t, 2)
+	c, _ := pipeClient(t, 2)
+	ps.Subscribe(a, resp.BulkArray("news.tech", "news.sport").Array)
+	ps.PSubscribe(a, resp.BulkArray("news.*").Array)
+	ps.Subscribe(b, resp.BulkArray("news.tech").Array)
+	ps.PSubscribe(b, resp.BulkArray("news.*", "*.tech").Array)
+	ps.SSubscribe(c, resp.BulkArray("shard.1").Array)
+
+	tests := []struct {
+		name string
+		args []string
+		want resp.Value
+	}{
+		{"channels", []string{"CHANNELS"}, resp.BulkArray("news.sport", "news.tech")},
+		{"channels matching", []string{"channels", "news.t*"}, resp.BulkArray("news.tech")},
+		{"channels matching nothing", []string{"CHANNELS", "sport.*"}, resp.Array()},
+		{"channels with two patterns", []string{"CHANNELS", "a", "b"}, resp.Err("ERR wrong number of arguments for 'pubsub|channels' command")},
+		{"numsub", []string{"NUMSUB", "news.tech", "news.sport", "shard.1"}, resp.Map(resp.Bulk("news.tech"), resp.Int(2), resp.Bulk("news.sport"), resp.Int(1), resp.Bulk("shard.1"), resp.Int(0))},
+		{"numsub without channels", []string{"NUMSUB"}, resp.Map()},
+		{"numpat counts unique patterns", []string{"NUMPAT"}, resp.Int(2)},
+		{"numpat with an argument", []string{"NUMPAT", "news.*"}, resp.Err("ERR wrong number of arguments for 'pubsub|numpat' command")},
+		{"shardchannels", []string{"SHARDCHANNELS"}, resp.BulkArray("shard.1")},
+		{"shardnumsub", []string{"SHARDNUMSUB", "shard.1", "news.tech"}, resp.Map(resp.Bulk("shard.1"), resp.Int(1), resp.Bulk("news.tech"), resp.Int(0))},
+		{"unknown subcommand", []string{"HELP"}, resp.Err("ERR unknown subcommand 'HELP'. Try PUBSUB HELP.")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ps.introspect(resp.BulkArray(tt.args...).Array)
+			// Channels are listed in no particular order.
+			if got.Typ == resp.ArrayType {
+				slices.SortFunc(got.Array, func(a, b resp.Value) int { return strings.Compare(a.String(), b.String()) })
+			}
+
+			if !sameReply(got, tt.want) {
+				t.Fatalf("got %q, want %q", got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+			}
+		})
+	}
+
+	ps.RemoveClient(b)
+	if got := ps.introspect(resp.BulkArray("NUMPAT").Array); !sameReply(got, resp.Int(1)) {
+		t.Fatalf("NUMPAT is %q once the only subscriber of a pattern left, want 1", got.MarshalProtocol(3))
+	}
+}
+
+func TestSameSlot(t *testing.T) {
+	tests := []struct {
+		channels []string
+		want     bool
+	}{
+		{channels: nil, want: true},
+		{channels: []string{"news"}, want: true},
+		{channels: []string{"hello", "foo{hello}", "{hello}bar"}, want: true},
+		{channels: []string{"hello", "foo"}, want: false},
+		{channels: []string{"{a}x", "{a}y", "{b}z"}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := sameSlot(resp.BulkArray(tt.channels...).Array); got != tt.want {
+			t.Errorf("sameSlot(%q) is %v, want %v", tt.channels, got, tt.want)
+		}
+	}
+}
+
+// TestShardChannels checks that shard channels are a namespace of their own,
+// counted apart from the classic subscriptions.
+func TestShardChannels(t *testing.T) {
+	ps := NewPubSub()
+	client, r := pipeClient(t, 3)
+	ps.Subscribe(client, resp.BulkArray("news").Array)
+	ps.SSubscribe(client, resp.BulkArray("news", "{news}.tech").Array)
+
+	if n := ps.SPublish("news", "shard"); n != 1 {
+		t.Fatalf("SPUBLISH reached %d subscribers, want 1", n)
+	}
+
+	if n := ps.SPublish("other", "shard"); n != 0 {
+		t.Fatalf("SPUBLISH to another channel reached %d subscribers, want 0", n)
+	}
+
+	ps.SUnsubscribe(client, nil)
+	if n := ps.Publish("news", "classic"); n != 1 {
+		t.Fatalf("PUBLISH reached %d subscribers after SUNSUBSCRIBE, want 1", n)
+	}
+
+	expect(t, r, ">3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	expect(t, r, ">3\r\n$10\r\nssubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	expect(t, r, ">3\r\n$10\r\nssubscribe\r\n$11\r\n{news}.tech\r\n:2\r\n")
+	expect(t, r, ">3\r\n$8\r\nsmessage\r\n$4\r\nnews\r\n$5\r\nshard\r\n")
+	// Shard channels are unsubscribed in no particular order.
+	unsubscribed := []string{
+		">3\r\n$12\r\nsunsubscribe\r\n$4\r\nnews\r\n:",
+		">3\r\n$12\r\nsunsubscribe\r\n$11\r\n{news}.tech\r\n:",
+	}
+	if peek, _ := r.Peek(len(unsubscribed[1])); string(peek) == unsubscribed[1] {
+		unsubscribed[0], unsubscribed[1] = unsubscribed[1], unsubscribed[0]
+	}
+	expect(t, r, unsubscribed[0]+"1\r\n")
+	expect(t, r, unsubscribed[1]+"0\r\n")
+	expect(t, r, ">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$7\r\nclassic\r\n")
+}
+
+// TestSSubscribeAcrossSlots checks that shard channels of different slots can
+// be subscribed to together outside of cluster mode.
+func TestSSubscribeAcrossSlots(t *testing.T) {
+	ctx := context.Background()
+	conn := dialTestServer(t)
+
+	if err := conn.Send(resp.BulkArray("SSUBSCRIBE", "hello", "foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, channel := range []string{"hello", "foo"} {
+		reply, err := conn.Receive(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := resp.Array(resp.Bulk("ssubscribe"), resp.Bulk(channel), resp.Int(i+1))
+		if !sameReply(reply, want) {
+			t.Fatalf("got %q, want %q", reply.MarshalProtocol(3), want.MarshalProtocol(3))
+		}
+	}
+}
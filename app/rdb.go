@@ -2,18 +2,25 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"time"
 
-	"github.com/codecrafters-io/redis-starter-go/internal/resp"
+	"github.com/codecrafters-io/redis-starter-go/resp"
 )
 
 const (
 	opCodeRESIZEDB     byte = 251
 	opCodeEXPIRETIMEMS byte = 252
 	opCodeEXPIRETIME   byte = 253
+	opCodeSELECTDB     byte = 254
 	opCodeEOF          byte = 255
+
+	typeString byte = 0
+
+	rdbHeader = "REDIS0011"
 )
 
 func sliceIndex(data []byte, sep byte) int {
@@ -26,13 +33,63 @@ func sliceIndex(data []byte, sep byte) int {
 	return -1
 }
 
+// parseTable returns the key-value pairs of the database, starting with the
+// sizes of its hash tables.
 func parseTable(bytes []byte) []byte {
 	start := sliceIndex(bytes, opCodeRESIZEDB)
-	end := sliceIndex(bytes, opCodeEOF)
-	return bytes[start+1 : end]
+	return bytes[start+1:]
+}
+
+// readLength decodes the length at data[*j] and moves *j past it. encoded is
+// set when what follows is a string stored as an integer, n then being the
+// size of that integer.
+func readLength(data []byte, j *int) (n int, encoded bool) {
+	b := data[*j]
+	switch b >> 6 {
+	case 0:
+		*j++
+		return int(b & 0x3f), false
+	case 1:
+		n = int(b&0x3f)<<8 | int(data[*j+1])
+		*j += 2
+		return n, false
+	case 2:
+		n = int(binary.BigEndian.Uint32(data[*j+1 : *j+5]))
+		*j += 5
+		return n, false
+	default:
+		*j++
+		return int(b & 0x3f), true
+	}
+}
+
+func readString(data []byte, j *int) ([]byte, error) {
+	n, encoded := readLength(data, j)
+	if !encoded {
+		str := data[*j : *j+n]
+		*j += n
+		return str, nil
+	}
+
+	var i int64
+	switch n {
+	case 0:
+		i = int64(int8(data[*j]))
+		*j++
+	case 1:
+		i = int64(int16(binary.LittleEndian.Uint16(data[*j : *j+2])))
+		*j += 2
+	case 2:
+		i = int64(int32(binary.LittleEndian.Uint32(data[*j : *j+4])))
+		*j += 4
+	default:
+		return nil, errors.New("compressed strings are not supported")
+	}
+
+	return []byte(strconv.FormatInt(i, 10)), nil
 }
 
-func readFile(path string) error {
+func readFile(path string) (err error) {
 	c, err := os.ReadFile(path)
 	if err != nil {
 		return err
@@ -42,51 +99,70 @@ func readFile(path string) error {
 		return nil
 	}
 
+	// A truncated file makes the slicing below go out of range.
+	defer func() {
+		if recover() != nil {
+			err = errors.New("invalid RDB file")
+		}
+	}()
+
 	type Expiry struct {
 		Option      byte
 		UntilExpiry string
 	}
 
 	content := parseTable(c)
-	var j byte = 2
-	for i := byte(0); i < content[0]; i++ {
+	j := 0
+	size, _ := readLength(content, &j)
+	readLength(content, &j)
+	for i := 0; i < size; i++ {
 		expireOption := content[j]
 		expiry := Expiry{Option: expireOption}
 
+		var until time.Duration
 		if expireOption == opCodeEXPIRETIMEMS {
 			i64 := binary.LittleEndian.Uint64(content[j+1 : j+9])
 			unixTimeUTC := time.Unix(0, int64(i64)*int64(time.Millisecond)).UTC()
 			j += 9
-			expiry.UntilExpiry = strconv.Itoa(int(time.Until(unixTimeUTC).Seconds()))
+			until = time.Until(unixTimeUTC)
+			expiry.UntilExpiry = strconv.FormatInt(until.Milliseconds(), 10)
 		}
 
 		if expireOption == opCodeEXPIRETIME {
 			i32 := binary.LittleEndian.Uint32(content[j+1 : j+5])
 			unixTimeUTC := time.Unix(int64(i32), 0)
 			j += 5
-			expiry.UntilExpiry = strconv.Itoa(int(time.Until(unixTimeUTC).Seconds()))
+			until = time.Until(unixTimeUTC)
+			expiry.UntilExpiry = strconv.Itoa(int(until.Seconds()))
 		}
 
 		// Skip Value Type Byte
 		j++
 
-		kLen := content[j]
-		key := content[j+1 : j+1+kLen]
-		j += kLen + 1
+		key, err := readString(content, &j)
+		if err != nil {
+			return err
+		}
+
+		value, err := readString(content, &j)
+		if err != nil {
+			return err
+		}
 
-		vLen := content[j]
-		value := content[j+1 : j+1+vLen]
-		j += vLen + 1
+		// Keys that expired while the server was down aren't loaded.
+		if expiry.UntilExpiry != "" && until <= 0 {
+			continue
+		}
 
 		args := make([]resp.Value, 2)
-		args[0] = resp.Value{Typ: resp.BULK_TYPE, Bulk: string(key)}
-		args[1] = resp.Value{Typ: resp.BULK_TYPE, Bulk: string(value)}
+		args[0] = resp.BulkBytes(key)
+		args[1] = resp.BulkBytes(value)
 
 		switch expiry.Option {
 		case opCodeEXPIRETIMEMS:
-			args = append(args, resp.Value{Typ: resp.BULK_TYPE, Bulk: "PX"}, resp.Value{Typ: resp.BULK_TYPE, Bulk: expiry.UntilExpiry})
+			args = append(args, resp.Bulk("PX"), resp.Bulk(expiry.UntilExpiry))
 		case opCodeEXPIRETIME:
-			args = append(args, resp.Value{Typ: resp.BULK_TYPE, Bulk: "EX"}, resp.Value{Typ: resp.BULK_TYPE, Bulk: expiry.UntilExpiry})
+			args = append(args, resp.Bulk("EX"), resp.Bulk(expiry.UntilExpiry))
 		}
 
 		set(args)
@@ -94,3 +170,84 @@ func readFile(path string) error {
 
 	return nil
 }
+
+// unsavedKeys counts the keys writeFile can't save: lists, sorted sets and
+// streams. Popping the last item of a list leaves it empty rather than
+// deleting it, so empty lists don't count.
+func unsavedKeys() int {
+	n := 0
+	lists.mu.Lock()
+	for _, list := range lists.lists {
+		if len(list.items) > 0 {
+			n++
+		}
+	}
+	lists.mu.Unlock()
+
+	setsmu.Lock()
+	n += len(sets)
+	setsmu.Unlock()
+
+	streams.mu.RLock()
+	n += len(streams.entries)
+	streams.mu.RUnlock()
+
+	return n
+}
+
+// writeFile saves the string keys, with their expiry, in the RDB format read
+// by readFile. It refuses to save when other keys exist, as they would be
+// lost, unless force is set. The file is replaced atomically, so a failed
+// save leaves the previous one intact.
+func writeFile(path string, force bool) error {
+	if n := unsavedKeys(); n > 0 {
+		if !force {
+			return fmt.Errorf("%d list, sorted set or stream keys can't be saved, only strings can", n)
+		}
+
+		fmt.Printf("Dropping %d list, sorted set or stream keys that can't be saved\n", n)
+	}
+
+	SETsMu.RLock()
+	buf := []byte(rdbHeader)
+	buf = append(buf, opCodeSELECTDB, 0, opCodeRESIZEDB)
+	buf = appendLength(buf, len(SETs))
+	buf = appendLength(buf, len(SETsExpiry))
+	for key, value := range SETs {
+		if at, ok := SETsExpiry[key]; ok {
+			buf = append(buf, opCodeEXPIRETIMEMS)
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(at.UnixMilli()))
+		}
+
+		buf = append(buf, typeString)
+		buf = appendString(buf, key)
+		buf = appendString(buf, value)
+	}
+	SETsMu.RUnlock()
+
+	// A zero checksum tells the loader not to verify it.
+	buf = append(buf, opCodeEOF)
+	buf = binary.LittleEndian.AppendUint64(buf, 0)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func appendLength(buf []byte, n int) []byte {
+	switch {
+	case n < 1<<6:
+		return append(buf, byte(n))
+	case n < 1<<14:
+		return append(buf, byte(n>>8)|0x40, byte(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, 0x80), uint32(n))
+	}
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendLength(buf, len(s)), s...)
+}
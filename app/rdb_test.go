@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	long := string(make([]byte, 20000))
+
+	call("FLUSHALL")
+	call("SET", "test:rdb:plain", "value")
+	call("SET", "test:rdb:binary", "\x00\r\n\xff")
+	call("SET", "test:rdb:long", long)
+	call("SET", "test:rdb:expiring", "soon", "PX", "100000")
+	if err := writeFile(path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	call("FLUSHALL")
+	if err := readFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"GET", "test:rdb:plain"}, resp.Bulk("value")},
+		{[]string{"GET", "test:rdb:binary"}, resp.Bulk("\x00\r\n\xff")},
+		{[]string{"GET", "test:rdb:long"}, resp.Bulk(long)},
+		{[]string{"GET", "test:rdb:expiring"}, resp.Bulk("soon")},
+	}
+
+	for _, tt := range tests {
+		if got := call(tt.args...); !sameReply(got, tt.want) {
+			t.Fatalf("%v: got %q, want %q", tt.args, got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+		}
+	}
+
+	if keys := call("KEYS", "*"); len(keys.Array) != len(tests) {
+		t.Fatalf("loaded %d keys, want %d", len(keys.Array), len(tests))
+	}
+
+	SETsMu.RLock()
+	_, expiring := SETsExpiry["test:rdb:expiring"]
+	_, plain := SETsExpiry["test:rdb:plain"]
+	SETsMu.RUnlock()
+	if !expiring || plain {
+		t.Fatalf("loaded expiries: expiring %v, plain %v", expiring, plain)
+	}
+}
+
+func TestSaveUnsavedKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands [][]string
+		unsaved  int
+	}{
+		{"strings", [][]string{{"SET", "test:rdb:string", "v"}}, 0},
+		{"list", [][]string{{"RPUSH", "test:rdb:list", "a"}}, 1},
+		{"emptied list", [][]string{{"RPUSH", "test:rdb:list", "a"}, {"BLPOP", "test:rdb:list", "1"}}, 0},
+		{"sorted set", [][]string{{"ZADD", "test:rdb:zset", "1", "a"}}, 1},
+		{"stream", [][]string{{"XADD", "test:rdb:stream", "*", "f", "v"}}, 1},
+		{"all", [][]string{
+			{"RPUSH", "test:rdb:list", "a"},
+			{"ZADD", "test:rdb:zset", "1", "a"},
+			{"GEOADD", "test:rdb:geo", "13.361389", "38.115556", "Palermo"},
+			{"XADD", "test:rdb:stream", "*", "f", "v"},
+		}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dump.rdb")
+			call("FLUSHALL")
+			call("SET", "test:rdb:saved", "before")
+			if err := writeFile(path, false); err != nil {
+				t.Fatal(err)
+			}
+
+			call("SET", "test:rdb:saved", "after")
+			for _, args := range tt.commands {
+				call(args...)
+			}
+
+			if n := unsavedKeys(); n != tt.unsaved {
+				t.Fatalf("%d unsaved keys, want %d", n, tt.unsaved)
+			}
+
+			// A refused save leaves the previous file in place.
+			want := "after"
+			if err := writeFile(path, false); (err != nil) != (tt.unsaved > 0) {
+				t.Fatalf("saving returned %v with %d unsaved keys", err, tt.unsaved)
+			} else if err != nil {
+				want = "before"
+			}
+
+			call("FLUSHALL")
+			if err := readFile(path); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := call("GET", "test:rdb:saved"); !sameReply(got, resp.Bulk(want)) {
+				t.Fatalf("loaded %q, want %q", got.MarshalProtocol(3), want)
+			}
+
+			// FORCE saves the strings anyway.
+			for _, args := range tt.commands {
+				call(args...)
+			}
+
+			if err := writeFile(path, true); err != nil {
+				t.Fatal(err)
+			}
+
+			call("FLUSHALL")
+			if err := readFile(path); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := unsavedKeys(); n != 0 {
+				t.Fatalf("loaded %d keys other than strings", n)
+			}
+
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Fatalf("the temporary file is left behind: %v", err)
+			}
+		})
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"bufio"
 	"io"
 
-	"github.com/codecrafters-io/redis-starter-go/internal/resp"
+	"github.com/codecrafters-io/redis-starter-go/resp"
 )
 
 func NewResp(rd io.Reader) *resp.Resp {
@@ -2,19 +2,24 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/codecrafters-io/redis-starter-go/internal/resp"
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/resp"
 )
 
 type ReplicaConfig struct {
@@ -25,12 +30,9 @@ type ReplicaConfig struct {
 type Slave struct {
 	conn   net.Conn
 	offset int
-}
-
-type SubscribeChan struct {
-	name        string
-	channel     chan string
-	subscribers int
+	// acked is the offset last acknowledged with REPLCONF ACK, or -1 while an
+	// acknowledgement is awaited.
+	acked atomic.Int64
 }
 
 type User struct {
@@ -40,15 +42,33 @@ type User struct {
 }
 
 type Server struct {
-	configs        map[string]string
-	replconf       ReplicaConfig
-	slaves         []*Slave
-	listener       net.Listener
-	broadcastch    chan []byte
-	subscribeChans map[string]*SubscribeChan
-	offset         int
-	users          map[string]User
-	watched        map[string]bool
+	configs     map[string]string
+	replconf    ReplicaConfig
+	listener    net.Listener
+	broadcastch chan []byte
+	pubsub      *PubSub
+	functions   *Functions
+	offset      int
+	users       map[string]User
+	limits      resp.Limits
+
+	clientsMu sync.Mutex
+	clients   map[*Client]bool
+
+	// slaves is appended to by the PSYNC connections while propagateLoop and
+	// the shutdown read it.
+	slavesMu sync.Mutex
+	slaves   []*Slave
+
+	shutdownMu sync.Mutex
+	// shutdownAbort is closed by SHUTDOWN ABORT to cancel a shutdown waiting
+	// for replicas. It is nil when no shutdown is in progress.
+	shutdownAbort chan struct{}
+	// shuttingDown is set, while holding the keyspace exclusively, for the
+	// duration of a shutdown. Write commands are refused meanwhile.
+	shuttingDown atomic.Bool
+	// closing is set once the server is closing the client connections.
+	closing atomic.Bool
 }
 
 var server *Server
@@ -56,6 +76,7 @@ var server *Server
 func main() {
 	server = NewServer()
 	initRDB(server.configs["dir"], server.configs["dbfilename"])
+	go server.handleSignals()
 	server.Start()
 }
 
@@ -64,8 +85,27 @@ func NewServer() *Server {
 	dbfilename := flag.String("dbfilename", "dump.rdb", "the name of the RDB file")
 	port := flag.String("port", "6379", "port number")
 	replicaof := flag.String("replicaof", "", "start redis in replica mode")
+	protoMaxBulkLen := flag.String("proto-max-bulk-len", "512mb", "the largest bulk string accepted from clients")
+	protoMaxMultibulkLen := flag.Int("proto-max-multibulk-len", 1024*1024, "the largest number of arguments accepted in a command")
+	clientQueryBufferLimit := flag.String("client-query-buffer-limit", "1gb", "the largest command accepted from a client")
+	functions := flag.String("functions", "", "comma separated list of the built-in function libraries to load, such as \"bank\"")
+	clusterEnabled := flag.String("cluster-enabled", "no", "run as a cluster node, which restricts multi-key commands to a single slot")
+	save := flag.String("save", "", "save the dataset to the RDB file on shutdown when not empty, such as \"3600 1\"")
+	shutdownTimeout := flag.Int("shutdown-timeout", 10, "the number of seconds to wait for replicas to catch up on shutdown")
 	flag.Parse()
 
+	maxBulkLen, err := parseMemory(*protoMaxBulkLen)
+	if err != nil {
+		fmt.Println("Invalid proto-max-bulk-len:", err)
+		os.Exit(1)
+	}
+
+	queryBufferLimit, err := parseMemory(*clientQueryBufferLimit)
+	if err != nil {
+		fmt.Println("Invalid client-query-buffer-limit:", err)
+		os.Exit(1)
+	}
+
 	master := strings.Split(*replicaof, " ")
 	replconf := ReplicaConfig{}
 	if len(master) == 2 {
@@ -74,17 +114,30 @@ func NewServer() *Server {
 	}
 
 	server := &Server{
-		configs:        make(map[string]string),
-		replconf:       replconf,
-		broadcastch:    make(chan []byte),
-		subscribeChans: map[string]*SubscribeChan{},
-		users:          make(map[string]User),
-		watched:        make(map[string]bool),
+		configs:     make(map[string]string),
+		replconf:    replconf,
+		broadcastch: make(chan []byte),
+		pubsub:      NewPubSub(),
+		functions:   NewFunctions(),
+		users:       make(map[string]User),
+		clients:     make(map[*Client]bool),
+		limits: resp.Limits{
+			MaxBulkLen:       maxBulkLen,
+			MaxMultibulkLen:  *protoMaxMultibulkLen,
+			MaxLineLen:       maxInlineLen,
+			QueryBufferLimit: queryBufferLimit,
+		},
 	}
 
 	server.configs["port"] = *port
 	server.configs["dir"] = *dir
 	server.configs["dbfilename"] = *dbfilename
+	server.configs["proto-max-bulk-len"] = strconv.Itoa(maxBulkLen)
+	server.configs["proto-max-multibulk-len"] = strconv.Itoa(*protoMaxMultibulkLen)
+	server.configs["client-query-buffer-limit"] = strconv.Itoa(queryBufferLimit)
+	server.configs["cluster-enabled"] = *clusterEnabled
+	server.configs["save"] = *save
+	server.configs["shutdown-timeout"] = strconv.Itoa(*shutdownTimeout)
 
 	defaultUser := User{
 		username: "default",
@@ -92,10 +145,68 @@ func NewServer() *Server {
 	}
 
 	server.users["default"] = defaultUser
+	for _, name := range strings.Split(*functions, ",") {
+		if name == "" {
+			continue
+		}
+
+		lib, ok := builtinLibraries[name]
+		if !ok {
+			fmt.Println("Unknown function library:", name)
+			os.Exit(1)
+		}
+
+		server.functions.Register(lib)
+	}
 
 	return server
 }
 
+// maxInlineLen is the longest inline command or protocol header line.
+const maxInlineLen = 64 * 1024
+
+// parseMemory parses a size such as "512mb", accepting the b, kb, mb and gb
+// units of the Redis configuration file.
+func parseMemory(s string) (int, error) {
+	s = strings.ToLower(s)
+	unit := 1
+	for _, suffix := range []struct {
+		name string
+		unit int
+	}{{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1}} {
+		if strings.HasSuffix(s, suffix.name) {
+			s, unit = strings.TrimSuffix(s, suffix.name), suffix.unit
+			break
+		}
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a positive size", s)
+	}
+
+	return n * unit, nil
+}
+
+// readLimits returns the limits applied to the next request of a client.
+// Like Redis, clients that haven't authenticated may only send small
+// requests, so that they can't make the server allocate memory before AUTH.
+func (s *Server) readLimits(authenticated bool) resp.Limits {
+	limits := s.limits
+	if !authenticated {
+		limits.MaxBulkLen = min(limits.MaxBulkLen, 16*1024)
+		limits.MaxMultibulkLen = min(limits.MaxMultibulkLen, 10)
+	}
+
+	return limits
+}
+
+// clusterEnabled reports whether the server runs as a cluster node, where
+// the keys of a command must all hash to the same slot.
+func (s *Server) clusterEnabled() bool {
+	return s.configs["cluster-enabled"] == "yes"
+}
+
 func initRDB(dir string, dbfilename string) {
 	path := dir + "/" + dbfilename
 	err := readFile(path)
@@ -121,17 +232,31 @@ func (s *Server) Start() {
 	}
 
 	s.Accept()
+
+	// The process exits once the shutdown that closed the listener is done
+	// closing the clients.
+	select {}
 }
 
+// Accept serves connections until the listener is closed by a shutdown.
+// Other errors, such as running out of file descriptors, are retried with a
+// growing delay.
 func (s *Server) Accept() {
+	l := s.listener
+	var delay time.Duration
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := l.Accept()
 
-		if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
 			fmt.Println("Error accepting connection: ", err.Error())
-			os.Exit(1)
+			time.Sleep(delay)
+			continue
 		}
 
+		delay = 0
 		go s.Handle(conn)
 	}
 }
@@ -139,15 +264,168 @@ func (s *Server) Accept() {
 type Queue struct {
 	active bool
 	items  []resp.Value
+	// dirty is set when a command is rejected while queueing, which makes
+	// EXEC discard the whole transaction.
+	dirty bool
+}
+
+func (q *Queue) reset() {
+	q.active = false
+	q.items = q.items[:0]
+	q.dirty = false
+}
+
+const (
+	clientQueueLen     = 4096
+	clientOutputBufLen = 16 * 1024
+	// clientDrainTimeout bounds how long the messages queued for a closing
+	// client may take to be sent.
+	clientDrainTimeout = time.Second
+)
+
+type Client struct {
+	id   int64
+	name string
+	conn net.Conn
+	// out buffers replies until the client has no more pipelined commands
+	// waiting to be read, see flushReader.
+	out           *bufio.Writer
+	queue         chan []byte
+	channels      map[string]bool
+	patterns      map[string]bool
+	shardChannels map[string]bool
+	watched       map[string]uint64
+	// protocol is the RESP version negotiated with HELLO. It is read when
+	// publishing, concurrently with the connection's own goroutine.
+	protocol atomic.Int32
+	// written is closed once the write loop has sent the whole queue.
+	written chan struct{}
+	// done is closed once the connection is served no more.
+	done chan struct{}
+}
+
+var nextClientID atomic.Int64
+
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		id:            nextClientID.Add(1),
+		conn:          conn,
+		out:           bufio.NewWriterSize(conn, clientOutputBufLen),
+		channels:      make(map[string]bool),
+		patterns:      make(map[string]bool),
+		shardChannels: make(map[string]bool),
+		watched:       make(map[string]uint64),
+		done:          make(chan struct{}),
+	}
+	c.protocol.Store(2)
+	return c
+}
+
+func (c *Client) Protocol() int {
+	return int(c.protocol.Load())
+}
+
+// Write sends p to the client. Once the client has subscribed, its replies go
+// through the same queue as published messages so that they stay ordered.
+func (c *Client) Write(p []byte) (int, error) {
+	if c.queue == nil {
+		return c.out.Write(p)
+	}
+
+	c.queue <- bytes.Clone(p)
+	return len(p), nil
+}
+
+// Flush sends the buffered replies to the client.
+func (c *Client) Flush() error {
+	return c.out.Flush()
+}
+
+func (c *Client) startQueue() {
+	if c.queue != nil {
+		return
+	}
+
+	if err := c.Flush(); err != nil {
+		fmt.Println("Error while writing the message:", err)
+	}
+
+	c.queue = make(chan []byte, clientQueueLen)
+	c.written = make(chan struct{})
+	go c.writeLoop()
+}
+
+func (c *Client) writeLoop() {
+	defer close(c.written)
+
+	for msg := range c.queue {
+		if _, err := c.conn.Write(msg); err != nil {
+			fmt.Println("Error while writing the message:", err)
+			c.conn.Close()
+			for range c.queue {
+			}
+
+			return
+		}
+	}
+}
+
+// enqueue queues msg without blocking. A subscriber that can't keep up is
+// disconnected, like Redis does when its pubsub output buffer limit is hit.
+func (c *Client) enqueue(msg []byte) {
+	select {
+	case c.queue <- msg:
+	default:
+		fmt.Println("Disconnecting slow subscriber:", c.conn.RemoteAddr())
+		c.conn.Close()
+	}
+}
+
+func (c *Client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+func (c *Client) shardSubscriptions() int {
+	return len(c.shardChannels)
+}
+
+func (c *Client) Close() {
+	c.Flush()
+	server.pubsub.RemoveClient(c)
+	watches.Unwatch(c)
+	if c.queue != nil {
+		// Let the queued messages go out before the connection is closed,
+		// without waiting on a client that stopped reading.
+		c.conn.SetWriteDeadline(time.Now().Add(clientDrainTimeout))
+		close(c.queue)
+		<-c.written
+	}
+}
+
+// flushReader reads a client's commands, flushing its buffered replies first
+// whenever reading would block on the connection. Replies to a pipeline are
+// thus sent together once all of its commands have been processed.
+type flushReader struct {
+	client *Client
+}
+
+func (r flushReader) Read(p []byte) (int, error) {
+	if err := r.client.Flush(); err != nil {
+		return 0, err
+	}
+
+	return r.client.conn.Read(p)
 }
 
 func (s *Server) Handle(conn net.Conn) {
 	defer conn.Close()
-	res := NewResp(conn)
 	queue := Queue{active: false, items: make([]resp.Value, 0)}
-	subscribes := make(map[string]*SubscribeChan)
-	subscribedMode := false
-	unsubscribeChans := make(map[string]chan struct{})
+	client := NewClient(conn)
+	s.addClient(client)
+	defer s.removeClient(client)
+	defer client.Close()
+	res := NewResp(flushReader{client})
+	writer := NewWriter(client)
 
 	user := User{}
 	defaultUser := server.users["default"]
@@ -156,18 +434,28 @@ func (s *Server) Handle(conn net.Conn) {
 	}
 
 	for {
-		value, err := res.Read()
-		if errors.Is(err, io.EOF) {
+		res.Limits = s.readLimits(user.username != "")
+		value, err := res.ReadRequest()
+		var protocolErr *resp.ProtocolError
+		if err != nil && s.closing.Load() {
+			writer.Write(resp.Err("ERR Server is shutting down"))
+			break
+		} else if errors.Is(err, io.EOF) {
 			fmt.Println("Client closed the connections:", conn.RemoteAddr())
 			break
+		} else if errors.As(err, &protocolErr) {
+			fmt.Println("Closing client after a protocol error:", conn.RemoteAddr(), err)
+			writer.Write(resp.Err("ERR " + protocolErr.Error()))
+			break
 		} else if err != nil {
 			fmt.Println("Error while reading the message:", err)
 			break
 		}
 
-		if value.Typ != "array" {
+		if value.Typ != resp.ArrayType {
 			fmt.Println("Invalid request, expected array")
-			continue
+			writer.Write(resp.Err("ERR Protocol error: expected an array of bulk strings"))
+			break
 		}
 
 		if len(value.Array) < 1 {
@@ -175,93 +463,143 @@ func (s *Server) Handle(conn net.Conn) {
 			continue
 		}
 
-		writer := NewWriter(conn)
-		command := strings.ToUpper(value.Array[0].Bulk)
-		if user.username == "" && command != "AUTH" {
-			writer.Write(resp.Value{Typ: resp.ERROR_TYPE, Str: "NOAUTH Authentication required."})
+		writer.Protocol = client.Protocol()
+		// RESP3 clients get published messages as push replies, so they can keep
+		// running regular commands while subscribed.
+		subscribedMode := client.subscriptions()+client.shardSubscriptions() > 0 && client.Protocol() == 2
+		command := strings.ToUpper(value.Array[0].String())
+		if user.username == "" && command != "AUTH" && command != "HELLO" {
+			writer.Write(resp.Err("NOAUTH Authentication required."))
 			continue
 		}
 
 		if subscribedMode && !slices.Contains(SubscribedModeCommands, command) {
-			writer.Write(resp.Value{Typ: resp.ERROR_TYPE, Str: fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", command)})
+			writer.Write(resp.Errf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", command))
 			continue
 		}
 
-		isWriteCommand := slices.Contains(WriteCommands, command)
-		if isWriteCommand && len(value.Array) > 1 {
-			key := value.Array[1].Bulk
-			if _, ok := server.watched[key]; ok {
-				server.watched[key] = true
-			}
+		// Acknowledgements get no reply, they are only recorded for a shutdown
+		// waiting for the replicas.
+		if command == "REPLCONF" && len(value.Array) == 3 && strings.ToUpper(value.Array[1].String()) == "ACK" {
+			s.replicaAck(conn, value.Array[2].String())
+			continue
+		}
+
+		if queue.active && !slices.Contains(TransactionCommands, command) {
+			writer.Write(queueCommand(&queue, value))
+			continue
 		}
 
+		isWrite := isWriteCommand(value.Array)
+
 		switch command {
 		case "MULTI":
 			if queue.active {
-				writer.Write(resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR MULTI calls can not be nested"})
+				writer.Write(resp.Err("ERR MULTI calls can not be nested"))
 				continue
 			}
 			writer.Write(multi(&queue))
 		case "EXEC":
-			writer.Write(exec(&queue))
-			clear(server.watched)
+			writer.Write(exec(&queue, client))
 		case "DISCARD":
 			writer.Write(discard(&queue))
-			clear(server.watched)
+			watches.Unwatch(client)
 		case "WATCH":
 			if queue.active {
-				writer.Write(resp.Value{Typ: resp.ERROR_TYPE, Str: "ERR WATCH inside MULTI is not allowed"})
+				queue.dirty = true
+				writer.Write(resp.Err("ERR WATCH inside MULTI is not allowed"))
 				continue
 			}
 
-			writer.Write(watch(value.Array[1:]))
+			writer.Write(watch(value.Array[1:], client))
 		case "UNWATCH":
-			clear(server.watched)
-			writer.Write(resp.Value{Typ: resp.STRING_TYPE, Str: "OK"})
+			watches.Unwatch(client)
+			writer.Write(resp.OK())
 		case "SUBSCRIBE":
-			subscribedMode = true
-			out := subscribe(value.Array[1:], subscribes)
-			if err := writer.Write(out); err != nil || out.Typ == resp.ERROR_TYPE {
-				log.Println(err)
-				break
+			if len(value.Array) < 2 {
+				writer.Write(resp.Err("ERR wrong number of arguments for 'subscribe' command"))
+				continue
 			}
 
-			chName := value.Array[1].Bulk
-			unsubscribeChans[chName] = make(chan struct{})
-			go receiveMessages(s.subscribeChans[chName], unsubscribeChans[chName], writer)
+			s.pubsub.Subscribe(client, value.Array[1:])
 		case "UNSUBSCRIBE":
-			subscribedMode = false
-			writer.Write(unsubscribe(value.Array[1:], subscribes, unsubscribeChans))
+			s.pubsub.Unsubscribe(client, value.Array[1:])
+		case "PSUBSCRIBE":
+			if len(value.Array) < 2 {
+				writer.Write(resp.Err("ERR wrong number of arguments for 'psubscribe' command"))
+				continue
+			}
+
+			s.pubsub.PSubscribe(client, value.Array[1:])
+		case "PUNSUBSCRIBE":
+			s.pubsub.PUnsubscribe(client, value.Array[1:])
+		case "SSUBSCRIBE":
+			if len(value.Array) < 2 {
+				writer.Write(resp.Err("ERR wrong number of arguments for 'ssubscribe' command"))
+				continue
+			}
+
+			if s.clusterEnabled() && !sameSlot(value.Array[1:]) {
+				writer.Write(resp.Err("CROSSSLOT Keys in request don't hash to the same slot"))
+				continue
+			}
+
+			s.pubsub.SSubscribe(client, value.Array[1:])
+		case "SUNSUBSCRIBE":
+			if s.clusterEnabled() && !sameSlot(value.Array[1:]) {
+				writer.Write(resp.Err("CROSSSLOT Keys in request don't hash to the same slot"))
+				continue
+			}
+
+			s.pubsub.SUnsubscribe(client, value.Array[1:])
 		case "PING":
 			writer.Write(ping(subscribedMode))
+		case "SHUTDOWN":
+			writer.Write(s.shutdownCommand(value.Array[1:], client))
 		case "AUTH":
 			r := authenticate(value.Array[1:])
-			if r.Typ != resp.ERROR_TYPE {
+			if r.Typ != resp.ErrorType {
+				user = defaultUser
+			}
+
+			writer.Write(r)
+		case "HELLO":
+			r, authenticated := hello(value.Array[1:], client, user.username != "")
+			if authenticated {
 				user = defaultUser
 			}
 
+			writer.Protocol = client.Protocol()
 			writer.Write(r)
 		default:
 			handler, ok := Handlers[command]
 			if !ok {
 				fmt.Println("Invalid command: ", command)
-				writer.Write(resp.Value{Typ: resp.ERROR_TYPE, Str: fmt.Sprintf("ERR unknown command %v", command)})
+				writer.Write(resp.Errf("ERR unknown command %v", command))
 				continue
 			}
 
-			if queue.active {
-				queue.items = append(queue.items, value)
-				writer.Write(resp.Value{Typ: resp.STRING_TYPE, Str: "QUEUED"})
-				continue
+			var ret resp.Value
+			if command == "FCALL" || command == "FCALL_RO" {
+				ret = callFunction(handler, value.Array[1:])
+			} else if isWrite {
+				var ok bool
+				if ret, ok = s.executeWrite(handler, value.Array[1:]); !ok {
+					writer.Write(ret)
+					continue
+				}
+			} else {
+				ret = ExecuteCommand(handler, value.Array[1:])
 			}
 
-			if err = writer.Write(ExecuteCommand(handler, value.Array[1:])); err != nil {
+			if err = writer.Write(ret); err != nil {
 				fmt.Println("Error while writing the message:", err)
 				continue
 			}
 		}
 
-		if isWriteCommand || (command == "REPLCONF" && strings.ToUpper(value.Array[1].Bulk) == "GETACK") && len(s.slaves) > 0 {
+		isPublishCommand := slices.Contains(PublishCommands, command)
+		if (isWrite || isPublishCommand) && s.replconf.host == "" || (command == "REPLCONF" && strings.ToUpper(value.Array[1].String()) == "GETACK") && len(s.replicas()) > 0 {
 			s.broadcastch <- value.Marshal()
 		}
 
@@ -276,6 +614,12 @@ func (s *Server) Handle(conn net.Conn) {
 				break
 			}
 
+			// The FULLRESYNC reply is still buffered and must go out first.
+			if err = client.Flush(); err != nil {
+				fmt.Println("Response with RDB file error")
+				break
+			}
+
 			length := strconv.Itoa(len(data))
 			_, err = conn.Write([]byte("$" + length + "\r\n" + string(data)))
 			if err != nil {
@@ -283,162 +627,102 @@ func (s *Server) Handle(conn net.Conn) {
 				break
 			}
 
-			s.slaves = append(s.slaves, &Slave{conn, 0})
+			s.addReplica(conn)
 		}
 	}
 }
 
+// ExecuteCommand runs a handler under the shared keyspace lock, so that it never
+// interleaves with a transaction.
 func ExecuteCommand(execute func([]resp.Value) resp.Value, args []resp.Value) resp.Value {
+	keyspace.RLock()
+	defer keyspace.RUnlock()
+
 	return execute(args)
 }
 
-func (s *Server) connectToMaster() {
-	conn, err := net.Dial("tcp", s.replconf.host+":"+s.replconf.port)
-	if err != nil {
-		fmt.Println("Couldn't connect to the master at ", s.replconf.host+":"+s.replconf.port)
-		return
-	}
-
-	reader := bufio.NewReader(conn)
-
-	msg := resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{
-		{
-			Typ:  "bulk",
-			Bulk: "ping",
-		},
-	}}
+// executeWrite runs a write command like ExecuteCommand, unless a shutdown is
+// in progress. ok is false when the command was refused.
+func (s *Server) executeWrite(execute func([]resp.Value) resp.Value, args []resp.Value) (ret resp.Value, ok bool) {
+	keyspace.RLock()
+	defer keyspace.RUnlock()
 
-	_, err = conn.Write(msg.Marshal())
-	if err != nil {
-		fmt.Println("Error while writing handshake 1/3")
-		os.Exit(1)
+	if s.shuttingDown.Load() {
+		return resp.Err("ERR Server is shutting down"), false
 	}
 
-	_, err = reader.ReadString('\n')
-	if err != nil {
-		fmt.Println("Error while writing handshake 1/3")
-		os.Exit(1)
-	}
+	return execute(args), true
+}
 
-	msg = resp.Value{
-		Typ: resp.ARRAY_TYPE,
-		Array: []resp.Value{
-			{
-				Typ:  "bulk",
-				Bulk: "REPLCONF",
-			},
-			{
-				Typ:  "bulk",
-				Bulk: "listening-port",
-			},
-			{
-				Typ:  "bulk",
-				Bulk: s.configs["port"],
-			},
-		},
+// propagateTransaction sends the write commands of a transaction to the
+// replicas wrapped in MULTI/EXEC, so that they are applied as one unit.
+func (s *Server) propagateTransaction(items []resp.Value) {
+	if s.replconf.host != "" {
+		return
 	}
 
-	_, err = conn.Write(msg.Marshal())
-	if err != nil {
-		fmt.Println("Error while writing handshake 2a/3")
-		os.Exit(1)
+	var writes []byte
+	for _, item := range items {
+		command := strings.ToUpper(item.Array[0].String())
+		if isWriteCommand(item.Array) || slices.Contains(PublishCommands, command) {
+			writes = append(writes, item.Marshal()...)
+		}
 	}
 
-	_, err = reader.ReadString('\n')
-	if err != nil {
-		fmt.Println(err)
-		fmt.Println("Error while reading response 2a/3")
-		os.Exit(1)
+	if len(writes) == 0 {
+		return
 	}
 
-	msg = resp.Value{
-		Typ: resp.ARRAY_TYPE,
-		Array: []resp.Value{
-			{
-				Typ:  "bulk",
-				Bulk: "REPLCONF",
-			},
-			{
-				Typ:  "bulk",
-				Bulk: "capa",
-			},
-			{
-				Typ:  "bulk",
-				Bulk: "psync2",
-			},
-		},
-	}
+	msg := resp.BulkArray("MULTI").Marshal()
+	msg = append(msg, writes...)
+	msg = append(msg, resp.BulkArray("EXEC").Marshal()...)
+	s.broadcastch <- msg
+}
 
-	_, err = conn.Write(msg.Marshal())
-	if err != nil {
-		fmt.Println("Error while writing handshake 2b/3")
-		os.Exit(1)
-	}
+func (s *Server) connectToMaster() {
+	ctx := context.Background()
+	addr := s.replconf.host + ":" + s.replconf.port
 
-	_, err = reader.ReadString('\n')
+	conn, err := client.Dial(ctx, client.Options{Addr: addr})
 	if err != nil {
-		fmt.Println("Error while reading response 2b/3")
-		os.Exit(1)
+		fmt.Println("Couldn't connect to the master at ", addr)
+		return
 	}
 
-	msg = resp.Value{
-		Typ: resp.ARRAY_TYPE,
-		Array: []resp.Value{
-			{
-				Typ:  "bulk",
-				Bulk: "PSYNC",
-			},
-			{
-				Typ:  "bulk",
-				Bulk: "?",
-			},
-			{
-				Typ:  "bulk",
-				Bulk: "-1",
-			},
-		},
+	handshake := [][]string{
+		{"PING"},
+		{"REPLCONF", "listening-port", s.configs["port"]},
+		{"REPLCONF", "capa", "psync2"},
+		{"PSYNC", "?", "-1"},
 	}
 
-	_, err = conn.Write(msg.Marshal())
-	if err != nil {
-		fmt.Println("Error while writing handshake 3/3")
-		os.Exit(1)
-	}
+	for i, args := range handshake {
+		reply, err := conn.Do(ctx, args...)
+		if err == nil && reply.Typ == resp.ErrorType {
+			err = errors.New(reply.String())
+		}
 
-	_, err = reader.ReadString('\n')
-	if err != nil {
-		fmt.Println("Error while reading response 3/3")
-		os.Exit(1)
+		if err != nil {
+			fmt.Printf("Error during handshake %d/%d: %v\n", i+1, len(handshake), err)
+			os.Exit(1)
+		}
 	}
 
 	// Read the RDB (ignore it for now)
-	response, _ := reader.ReadString('\n')
-	if response[0] != '$' {
-		fmt.Printf("Invalid response\n")
-		os.Exit(1)
-	}
-
-	rdbSize, _ := strconv.Atoi(response[1 : len(response)-2])
-	buffer := make([]byte, rdbSize)
-	receivedSize, err := reader.Read(buffer)
-	if err != nil {
+	if _, err := conn.ReadRDB(ctx); err != nil {
 		fmt.Printf("Invalid RDB received %v\n", err)
 		os.Exit(1)
 	}
 
-	if rdbSize != receivedSize {
-		fmt.Printf("Size mismatch - got: %d, want: %d\n", receivedSize, rdbSize)
-	}
-
 	go s.HandleMaster(conn)
 }
 
-func (s *Server) HandleMaster(masterConn net.Conn) {
+func (s *Server) HandleMaster(masterConn *client.Conn) {
 	defer masterConn.Close()
-	resp := NewResp(masterConn)
+	queue := Queue{}
 
 	for {
-		value, err := resp.Read()
+		value, err := masterConn.Receive(context.Background())
 
 		if errors.Is(err, io.EOF) {
 			fmt.Println("Client closed the connections:", masterConn.RemoteAddr())
@@ -448,7 +732,7 @@ func (s *Server) HandleMaster(masterConn net.Conn) {
 			break
 		}
 
-		if value.Typ != "array" {
+		if value.Typ != resp.ArrayType {
 			fmt.Println("Invalid request, expected array")
 			continue
 		}
@@ -458,21 +742,35 @@ func (s *Server) HandleMaster(masterConn net.Conn) {
 			continue
 		}
 
-		command := strings.ToUpper(value.Array[0].Bulk)
+		command := strings.ToUpper(value.Array[0].String())
+		switch command {
+		case "MULTI":
+			queue.active = true
+			s.offset += len(value.Marshal())
+			continue
+		case "EXEC":
+			keyspace.runExclusive(func() { runTransaction(queue.items) })
+			queue.reset()
+			s.offset += len(value.Marshal())
+			continue
+		}
+
 		handler, ok := Handlers[command]
 		if !ok {
 			fmt.Println("Invalid command: ", command)
 			break
 		}
 
-		if command == "REPLCONF" {
-			writer := NewWriter(masterConn)
-			if err = writer.Write(handler(value.Array[1:])); err != nil {
+		if queue.active {
+			queue.items = append(queue.items, value)
+		} else if command == "REPLCONF" {
+			masterConn.Send(handler(value.Array[1:]))
+			if err = masterConn.Flush(context.Background()); err != nil {
 				fmt.Println("Error while writing the message:", err)
 				continue
 			}
 		} else {
-			handler(value.Array[1:])
+			ExecuteCommand(handler, value.Array[1:])
 		}
 
 		s.offset += len(value.Marshal())
@@ -483,7 +781,7 @@ func (s *Server) propagateLoop() {
 	for {
 		msg := <-s.broadcastch
 
-		for _, slave := range s.slaves {
+		for _, slave := range s.replicas() {
 			_, err := slave.conn.Write(msg)
 			if err != nil {
 				fmt.Println("Error broadcasting message to server:" + slave.conn.RemoteAddr().String())
@@ -493,17 +791,3 @@ func (s *Server) propagateLoop() {
 		}
 	}
 }
-
-func receiveMessages(subscribeChan *SubscribeChan, unsubscribeChan chan struct{}, writer *resp.Writer) {
-	for {
-		select {
-		case msg := <-subscribeChan.channel:
-			response := resp.Value{Typ: resp.ARRAY_TYPE, Array: []resp.Value{{Typ: resp.BULK_TYPE, Bulk: "message"}}}
-			response.Array = append(response.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: subscribeChan.name})
-			response.Array = append(response.Array, resp.Value{Typ: resp.BULK_TYPE, Bulk: msg})
-			writer.Write(response)
-		case <-unsubscribeChan:
-			return
-		}
-	}
-}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// replicate connects to the test server as a replica would and returns the
+// replication stream once the server propagates to it.
+func replicate(t *testing.T) *bufio.Reader {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", startTestServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		server.slavesMu.Lock()
+		server.slaves = slices.DeleteFunc(slices.Clone(server.slaves), func(slave *Slave) bool {
+			return slave.conn.RemoteAddr().String() == conn.LocalAddr().String()
+		})
+		server.slavesMu.Unlock()
+		conn.Close()
+	})
+
+	replicas := len(server.replicas())
+	if _, err := conn.Write(resp.BulkArray("PSYNC", "?", "-1").Marshal()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "+FULLRESYNC") {
+		t.Fatalf("got %q, %v in reply to PSYNC", line, err)
+	}
+
+	header, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+	if err != nil {
+		t.Fatalf("invalid RDB header %q", header)
+	}
+
+	if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The replica is added once the RDB file is sent.
+	for len(server.replicas()) == replicas {
+		runtime.Gosched()
+	}
+
+	return r
+}
+
+func TestPublishPropagation(t *testing.T) {
+	publish := string(resp.BulkArray("PUBLISH", "news", "hello").Marshal())
+	tests := []struct {
+		name     string
+		commands [][]string
+		want     string
+	}{
+		{name: "publish", commands: [][]string{{"PUBLISH", "news", "hello"}}, want: publish},
+		{
+			name:     "spublish",
+			commands: [][]string{{"SPUBLISH", "news", "hello"}},
+			want:     string(resp.BulkArray("SPUBLISH", "news", "hello").Marshal()),
+		},
+		{name: "reads are not propagated", commands: [][]string{{"GET", "test:propagation"}, {"PUBLISH", "news", "hello"}}, want: publish},
+		{
+			name:     "transaction",
+			commands: [][]string{{"MULTI"}, {"GET", "test:propagation"}, {"PUBLISH", "news", "hello"}, {"EXEC"}},
+			want:     "*1\r\n$5\r\nMULTI\r\n" + publish + "*1\r\n$4\r\nEXEC\r\n",
+		},
+	}
+
+	ctx := context.Background()
+	stream := replicate(t)
+	conn := dialTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, args := range tt.commands {
+				if reply, err := conn.Do(ctx, args...); err != nil || reply.Typ == resp.ErrorType {
+					t.Fatalf("%v: got %v, %v", args, reply, err)
+				}
+			}
+
+			expect(t, stream, tt.want)
+		})
+	}
+}
+
+// TestReplicaPublish checks that a replica delivers the messages its master
+// propagates to its own subscribers.
+func TestReplicaPublish(t *testing.T) {
+	const channel = "test:replica:news"
+	publish := string(resp.BulkArray("PUBLISH", channel, "hello").Marshal())
+	tests := []struct {
+		name   string
+		stream string
+		want   string
+	}{
+		{name: "publish", stream: publish, want: "*3\r\n$7\r\nmessage\r\n$17\r\n" + channel + "\r\n$5\r\nhello\r\n"},
+		{
+			name:   "spublish",
+			stream: string(resp.BulkArray("SPUBLISH", channel, "hello").Marshal()),
+			want:   "*3\r\n$8\r\nsmessage\r\n$17\r\n" + channel + "\r\n$5\r\nhello\r\n",
+		},
+		{
+			name:   "transaction",
+			stream: "*1\r\n$5\r\nMULTI\r\n" + publish + "*1\r\n$4\r\nEXEC\r\n",
+			want:   "*3\r\n$7\r\nmessage\r\n$17\r\n" + channel + "\r\n$5\r\nhello\r\n",
+		},
+	}
+
+	startTestServer(t)
+	subscriber, r := pipeClient(t, 2)
+	server.pubsub.Subscribe(subscriber, resp.BulkArray(channel).Array)
+	server.pubsub.SSubscribe(subscriber, resp.BulkArray(channel).Array)
+	t.Cleanup(func() { server.pubsub.RemoveClient(subscriber) })
+	expect(t, r, "*3\r\n$9\r\nsubscribe\r\n$17\r\n"+channel+"\r\n:1\r\n")
+	expect(t, r, "*3\r\n$10\r\nssubscribe\r\n$17\r\n"+channel+"\r\n:1\r\n")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	masterConn, err := client.Dial(context.Background(), client.Options{Addr: l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master := <-accepted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.HandleMaster(masterConn)
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := master.Write([]byte(tt.stream)); err != nil {
+				t.Fatal(err)
+			}
+
+			expect(t, r, tt.want)
+		})
+	}
+
+	master.Close()
+	<-done
+}
+
+func TestTransaction(t *testing.T) {
+	const key = "test:transaction"
+	type step struct {
+		args []string
+		want resp.Value
+	}
+
+	queued := resp.Simple("QUEUED")
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"exec", []step{
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"SET", key, "1"}, queued},
+			{[]string{"INCR", key}, queued},
+			{[]string{"PING"}, queued},
+			{[]string{"EXEC"}, resp.Array(resp.OK(), resp.Int(2), resp.Simple("PONG"))},
+		}},
+		{"empty", []step{
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"EXEC"}, resp.Array()},
+		}},
+		{"runtime errors don't abort", []step{
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"SET", key, "text"}, queued},
+			{[]string{"INCR", key}, queued},
+			{[]string{"GET", key}, queued},
+			{[]string{"EXEC"}, resp.Array(resp.OK(), resp.Err("ERR value is not an integer or out of range"), resp.Bulk("text"))},
+		}},
+		{"syntax error", []step{
+			{[]string{"SET", key, "1"}, resp.OK()},
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"INCR", key}, queued},
+			{[]string{"SET", key, "v", "BAD", "1"}, resp.Err("ERR syntax error")},
+			{[]string{"EXEC"}, resp.Err("EXECABORT Transaction discarded because of previous errors.")},
+			{[]string{"GET", key}, resp.Bulk("1")},
+		}},
+		{"arity error", []step{
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"GET"}, resp.Err("ERR wrong number of arguments for 'get' command")},
+			{[]string{"EXEC"}, resp.Err("EXECABORT Transaction discarded because of previous errors.")},
+		}},
+		{"subscribe", []step{
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"SUBSCRIBE", "news"}, resp.Err("ERR Command not allowed inside a transaction")},
+			{[]string{"EXEC"}, resp.Err("EXECABORT Transaction discarded because of previous errors.")},
+			{[]string{"PUBSUB", "NUMSUB", "news"}, resp.Array(resp.Bulk("news"), resp.Int(0))},
+		}},
+		{"discard", []step{
+			{[]string{"SET", key, "1"}, resp.OK()},
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"INCR", key}, queued},
+			{[]string{"DISCARD"}, resp.OK()},
+			{[]string{"GET", key}, resp.Bulk("1")},
+			{[]string{"EXEC"}, resp.Err("ERR EXEC without MULTI")},
+		}},
+		{"discard after an error", []step{
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"GET"}, resp.Err("ERR wrong number of arguments for 'get' command")},
+			{[]string{"DISCARD"}, resp.OK()},
+			{[]string{"MULTI"}, resp.OK()},
+			{[]string{"EXEC"}, resp.Array()},
+		}},
+		{"without multi", []step{
+			{[]string{"EXEC"}, resp.Err("ERR EXEC without MULTI")},
+			{[]string{"DISCARD"}, resp.Err("ERR DISCARD without MULTI")},
+		}},
+	}
+
+	ctx := context.Background()
+	conn := dialTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, step := range tt.steps {
+				got, err := conn.Do(ctx, step.args...)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !sameReply(got, step.want) {
+					t.Fatalf("step %d %v: got %q, want %q", i, step.args, got.MarshalProtocol(2), step.want.MarshalProtocol(2))
+				}
+			}
+		})
+	}
+}
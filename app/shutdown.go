@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// shutdownGracePeriod bounds how long clients get to receive the shutdown
+// error before the process exits.
+const shutdownGracePeriod = time.Second
+
+type ShutdownOptions struct {
+	// save and noSave override the save configuration.
+	save   bool
+	noSave bool
+	// now skips waiting for the replicas.
+	now bool
+	// force exits even if the dataset couldn't be saved.
+	force bool
+	// dropUnsaved saves the strings and drops the keys that can't be saved,
+	// rather than failing the save.
+	dropUnsaved bool
+}
+
+var errShutdownAborted = errors.New("shutdown was aborted")
+
+func (s *Server) addClient(client *Client) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	s.clients[client] = true
+}
+
+func (s *Server) removeClient(client *Client) {
+	s.clientsMu.Lock()
+	delete(s.clients, client)
+	s.clientsMu.Unlock()
+
+	close(client.done)
+}
+
+// addReplica starts propagating the writes to the replica connected on conn.
+func (s *Server) addReplica(conn net.Conn) {
+	s.slavesMu.Lock()
+	defer s.slavesMu.Unlock()
+
+	s.slaves = append(s.slaves, &Slave{conn: conn})
+}
+
+// replicas returns the replicas connected so far.
+func (s *Server) replicas() []*Slave {
+	s.slavesMu.Lock()
+	defer s.slavesMu.Unlock()
+
+	return slices.Clip(s.slaves)
+}
+
+// replicaAck records the offset acknowledged by the replica connected on conn.
+func (s *Server) replicaAck(conn net.Conn, offset string) {
+	n, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil {
+		return
+	}
+
+	for _, slave := range s.replicas() {
+		if slave.conn == conn {
+			slave.acked.Store(n)
+		}
+	}
+}
+
+// handleSignals shuts the server down on SIGTERM and SIGINT, as SHUTDOWN
+// without options would, except that the save drops the keys that can't be
+// saved: nobody is there to retry with FORCE, so failing would leave the
+// server running.
+func (s *Server) handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	for sig := range signals {
+		fmt.Printf("Received %v, scheduling shutdown...\n", sig)
+		if err := s.shutdown(ShutdownOptions{dropUnsaved: true}, nil); err != nil {
+			fmt.Println("Error trying to shut down the server, it keeps running:", err)
+		}
+	}
+}
+
+// shutdownCommand runs SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]. It only
+// returns when the shutdown fails or is aborted, the process exits otherwise.
+func (s *Server) shutdownCommand(args []resp.Value, client *Client) resp.Value {
+	var opts ShutdownOptions
+	abort := false
+	for _, arg := range args {
+		switch strings.ToUpper(arg.String()) {
+		case "SAVE":
+			opts.save = true
+		case "NOSAVE":
+			opts.noSave = true
+		case "NOW":
+			opts.now = true
+		case "FORCE":
+			opts.force = true
+		case "ABORT":
+			abort = true
+		default:
+			return resp.Err("ERR syntax error")
+		}
+	}
+
+	if opts.save && opts.noSave || abort && len(args) > 1 {
+		return resp.Err("ERR syntax error")
+	}
+
+	if abort {
+		if !s.abortShutdown() {
+			return resp.Err("ERR No shutdown in progress.")
+		}
+
+		return resp.OK()
+	}
+
+	if err := s.shutdown(opts, client); err != nil {
+		fmt.Println("Error trying to shut down the server:", err)
+		return resp.Err("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+
+	return resp.OK()
+}
+
+func (s *Server) abortShutdown() bool {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+
+	if s.shutdownAbort == nil {
+		return false
+	}
+
+	close(s.shutdownAbort)
+	s.shutdownAbort = nil
+	return true
+}
+
+// shutdown stops accepting connections and refuses writes, waits for the
+// replicas to catch up, saves the dataset and exits. It returns if the
+// shutdown is aborted or the dataset can't be saved, and the server then
+// resumes. client is the connection that asked for the shutdown, if any.
+func (s *Server) shutdown(opts ShutdownOptions, client *Client) error {
+	s.shutdownMu.Lock()
+	if s.shutdownAbort != nil {
+		s.shutdownMu.Unlock()
+		return errors.New("a shutdown is already in progress")
+	}
+
+	abort := make(chan struct{})
+	s.shutdownAbort = abort
+	s.shutdownMu.Unlock()
+
+	defer func() {
+		s.shutdownMu.Lock()
+		if s.shutdownAbort == abort {
+			s.shutdownAbort = nil
+		}
+		s.shutdownMu.Unlock()
+	}()
+
+	// Setting the flag with the keyspace held waits for the writes being run,
+	// and the ones coming next check it under the keyspace lock.
+	keyspace.runExclusive(func() {
+		s.shuttingDown.Store(true)
+	})
+	s.listener.Close()
+
+	err := s.finishShutdown(opts, client, abort)
+	s.resume()
+	return err
+}
+
+// finishShutdown waits for the replicas, then saves the dataset and exits.
+// It only returns on failure.
+func (s *Server) finishShutdown(opts ShutdownOptions, client *Client, abort chan struct{}) error {
+	if !opts.now && len(s.replicas()) > 0 {
+		if err := s.waitForReplicas(abort); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-abort:
+		return errShutdownAborted
+	default:
+	}
+
+	// Writes are refused, so the dataset doesn't change while it is saved.
+	if opts.save || !opts.noSave && s.configs["save"] != "" {
+		path := s.configs["dir"] + "/" + s.configs["dbfilename"]
+		fmt.Println("Saving the dataset to", path)
+		if err := writeFile(path, opts.force || opts.dropUnsaved); err != nil {
+			if !opts.force {
+				return err
+			}
+
+			fmt.Println("Error saving the dataset, exiting anyway:", err)
+		}
+	}
+
+	s.exit(client)
+	return nil
+}
+
+// resume accepts connections and writes again after a failed or aborted
+// shutdown.
+func (s *Server) resume() {
+	l, err := net.Listen("tcp", s.listener.Addr().String())
+	if err != nil {
+		fmt.Println("Failed to listen again after the shutdown was cancelled:", err)
+	} else {
+		s.listener = l
+		go s.Accept()
+	}
+
+	s.shuttingDown.Store(false)
+}
+
+// waitForReplicas asks the replicas to acknowledge the replication stream and
+// waits until all of them did, or until shutdown-timeout. As the stream is
+// processed in order, a replica answering has applied every write sent
+// before.
+func (s *Server) waitForReplicas(abort chan struct{}) error {
+	replicas := s.replicas()
+	for _, slave := range replicas {
+		slave.acked.Store(-1)
+	}
+
+	s.broadcastch <- resp.BulkArray("REPLCONF", "GETACK", "*").Marshal()
+
+	timeout, _ := strconv.Atoi(s.configs["shutdown-timeout"])
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		lagging := 0
+		for _, slave := range replicas {
+			if slave.acked.Load() < 0 {
+				lagging++
+			}
+		}
+
+		if lagging == 0 {
+			return nil
+		}
+
+		select {
+		case <-abort:
+			return errShutdownAborted
+		case <-deadline:
+			fmt.Printf("%d replicas didn't catch up before shutdown-timeout\n", lagging)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// exit closes the clients with an error and exits with status 0.
+func (s *Server) exit(self *Client) {
+	s.closing.Store(true)
+
+	s.clientsMu.Lock()
+	clients := make([]*Client, 0, len(s.clients))
+	for client := range s.clients {
+		if client != self {
+			clients = append(clients, client)
+		}
+	}
+	s.clientsMu.Unlock()
+
+	// Interrupting the reads makes each connection reply with an error and
+	// close.
+	for _, client := range clients {
+		client.conn.SetReadDeadline(time.Now())
+	}
+
+	deadline := time.After(shutdownGracePeriod)
+	for _, client := range clients {
+		select {
+		case <-client.done:
+		case <-deadline:
+		}
+	}
+
+	if self != nil {
+		self.Flush()
+	}
+
+	fmt.Println("Redis is now ready to exit, bye bye...")
+	os.Exit(0)
+}
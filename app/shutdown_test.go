@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+func TestShutdownSyntax(t *testing.T) {
+	tests := []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SAVE", "NOSAVE"}, resp.Err("ERR syntax error")},
+		{[]string{"NOW", "LATER"}, resp.Err("ERR syntax error")},
+		{[]string{"ABORT", "NOW"}, resp.Err("ERR syntax error")},
+		{[]string{"FORCE", "ABORT"}, resp.Err("ERR syntax error")},
+		{[]string{"ABORT"}, resp.Err("ERR No shutdown in progress.")},
+		{[]string{"abort"}, resp.Err("ERR No shutdown in progress.")},
+	}
+
+	startTestServer(t)
+	for _, tt := range tests {
+		if got := server.shutdownCommand(resp.BulkArray(tt.args...).Array, nil); !sameReply(got, tt.want) {
+			t.Errorf("SHUTDOWN %v: got %q, want %q", tt.args, got.MarshalProtocol(3), tt.want.MarshalProtocol(3))
+		}
+	}
+}
+
+// TestShutdownRefusesWrites checks the commands run while a shutdown waits
+// for the replicas or saves the dataset.
+func TestShutdownRefusesWrites(t *testing.T) {
+	const key = "test:shutdown:writes"
+	refused := resp.Err("ERR Server is shutting down")
+	queued := resp.Simple("QUEUED")
+	steps := []struct {
+		args []string
+		want resp.Value
+	}{
+		{[]string{"SET", key, "2"}, refused},
+		{[]string{"INCR", key}, refused},
+		{[]string{"RPUSH", key + ":list", "a"}, refused},
+		{[]string{"GET", key}, resp.Bulk("1")},
+		{[]string{"PING"}, resp.Simple("PONG")},
+		{[]string{"MULTI"}, resp.OK()},
+		{[]string{"GET", key}, queued},
+		{[]string{"EXEC"}, resp.Array(resp.Bulk("1"))},
+		{[]string{"MULTI"}, resp.OK()},
+		{[]string{"GET", key}, queued},
+		{[]string{"SET", key, "2"}, queued},
+		{[]string{"EXEC"}, refused},
+		{[]string{"GET", key}, resp.Bulk("1")},
+	}
+
+	ctx := context.Background()
+	conn := dialTestServer(t)
+	if _, err := conn.Do(ctx, "SET", key, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	keyspace.runExclusive(func() { server.shuttingDown.Store(true) })
+	t.Cleanup(func() { server.shuttingDown.Store(false) })
+	for i, step := range steps {
+		got, err := conn.Do(ctx, step.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !sameReply(got, step.want) {
+			t.Fatalf("step %d %v: got %q, want %q", i, step.args, got.MarshalProtocol(2), step.want.MarshalProtocol(2))
+		}
+	}
+}
+
+// TestShutdownFailedSave checks that the server keeps running when the
+// dataset can't be saved without FORCE.
+func TestShutdownFailedSave(t *testing.T) {
+	startTestServer(t)
+	dir, save := server.configs["dir"], server.configs["save"]
+	server.configs["dir"], server.configs["save"] = t.TempDir(), "3600 1"
+	t.Cleanup(func() { server.configs["dir"], server.configs["save"] = dir, save })
+
+	call("FLUSHALL")
+	call("RPUSH", "test:shutdown:list", "a")
+	got := server.shutdownCommand(nil, nil)
+	if want := resp.Err("ERR Errors trying to SHUTDOWN. Check logs."); !sameReply(got, want) {
+		t.Fatalf("got %q, want %q", got.MarshalProtocol(3), want.MarshalProtocol(3))
+	}
+
+	// The server accepts connections and writes again.
+	reply, err := dialTestServer(t).Do(context.Background(), "SET", "test:shutdown:after", "1")
+	if err != nil || !sameReply(reply, resp.OK()) {
+		t.Fatalf("SET after the failed shutdown returned %v, %v", reply, err)
+	}
+}
+
+// TestShutdownAbort aborts a shutdown waiting for a replica that never
+// acknowledges the replication stream.
+func TestShutdownAbort(t *testing.T) {
+	stream := replicate(t)
+	conn := dialTestServer(t)
+	ctx := context.Background()
+
+	done := make(chan error, 1)
+	go func() { done <- server.shutdown(ShutdownOptions{noSave: true}, nil) }()
+	// Left alone, the shutdown would exit the tests after shutdown-timeout.
+	t.Cleanup(func() { server.abortShutdown() })
+	expect(t, stream, string(resp.BulkArray("REPLCONF", "GETACK", "*").Marshal()))
+	for !server.shuttingDown.Load() {
+		runtime.Gosched()
+	}
+
+	type step struct {
+		args []string
+		want resp.Value
+	}
+
+	run := func(steps []step) {
+		t.Helper()
+
+		for i, step := range steps {
+			got, err := conn.Do(ctx, step.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !sameReply(got, step.want) {
+				t.Fatalf("step %d %v: got %q, want %q", i, step.args, got.MarshalProtocol(2), step.want.MarshalProtocol(2))
+			}
+		}
+	}
+
+	run([]step{
+		{[]string{"SET", "test:shutdown:abort", "1"}, resp.Err("ERR Server is shutting down")},
+		{[]string{"SHUTDOWN", "NOSAVE"}, resp.Err("ERR Errors trying to SHUTDOWN. Check logs.")},
+		{[]string{"SHUTDOWN", "ABORT"}, resp.OK()},
+	})
+
+	if err := <-done; !errors.Is(err, errShutdownAborted) {
+		t.Fatalf("the shutdown returned %v, want %v", err, errShutdownAborted)
+	}
+
+	run([]step{
+		{[]string{"SET", "test:shutdown:abort", "1"}, resp.OK()},
+		{[]string{"SHUTDOWN", "ABORT"}, resp.Err("ERR No shutdown in progress.")},
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import "sync"
+
+// Watches keeps a modification version for every key that is watched by at
+// least one client. Writes bump the version of the keys they touch, and a
+// transaction is aborted when a version differs from the one seen by WATCH.
+type Watches struct {
+	mu   sync.Mutex
+	keys map[string]*watchedKey
+}
+
+type watchedKey struct {
+	version  uint64
+	watchers int
+}
+
+var watches = &Watches{keys: make(map[string]*watchedKey)}
+
+// Watch starts tracking key for client, remembering its current version.
+// Watching a key that doesn't exist is allowed: creating it later bumps the
+// version just like any other write.
+func (w *Watches) Watch(client *Client, key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := client.watched[key]; ok {
+		return
+	}
+
+	wk, ok := w.keys[key]
+	if !ok {
+		wk = &watchedKey{}
+		w.keys[key] = wk
+	}
+
+	wk.watchers++
+	client.watched[key] = wk.version
+}
+
+// Unwatch forgets every key watched by client.
+func (w *Watches) Unwatch(client *Client) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key := range client.watched {
+		if wk, ok := w.keys[key]; ok {
+			wk.watchers--
+			if wk.watchers == 0 {
+				delete(w.keys, key)
+			}
+		}
+	}
+
+	clear(client.watched)
+}
+
+// Dirty reports whether any key watched by client was modified since WATCH.
+func (w *Watches) Dirty(client *Client) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, version := range client.watched {
+		if wk, ok := w.keys[key]; ok && wk.version != version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// touchKey must be called by every command, expiration or flush that modifies key.
+func touchKey(key string) {
+	watches.mu.Lock()
+	defer watches.mu.Unlock()
+
+	if wk, ok := watches.keys[key]; ok {
+		wk.version++
+	}
+}
+
+func touchAllKeys() {
+	watches.mu.Lock()
+	defer watches.mu.Unlock()
+
+	for _, wk := range watches.keys {
+		wk.version++
+	}
+}
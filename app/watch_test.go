@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+func TestWatch(t *testing.T) {
+	const key = "test:watch:key"
+	tests := []struct {
+		name string
+		// setup runs on the watching connection before WATCH key.
+		setup [][]string
+		// self runs on the watching connection after WATCH, and other on
+		// another connection.
+		self  [][]string
+		other [][]string
+		// wait is slept before running the transaction.
+		wait    time.Duration
+		aborted bool
+	}{
+		{name: "untouched"},
+		{name: "modified", other: [][]string{{"SET", key, "2"}}, aborted: true},
+		{name: "modified by itself", self: [][]string{{"SET", key, "2"}}, aborted: true},
+		{name: "other key", other: [][]string{{"SET", "test:watch:other", "2"}}},
+		{name: "created", setup: [][]string{{"FLUSHALL"}}, other: [][]string{{"SET", key, "2"}}, aborted: true},
+		{name: "read", other: [][]string{{"GET", key}}},
+		{name: "flushall", other: [][]string{{"FLUSHALL"}}, aborted: true},
+		{name: "list", setup: [][]string{{"FLUSHALL"}}, other: [][]string{{"RPUSH", key, "a"}}, aborted: true},
+		{name: "sorted set", setup: [][]string{{"FLUSHALL"}}, other: [][]string{{"ZADD", key, "1", "a"}}, aborted: true},
+		{name: "stream", setup: [][]string{{"FLUSHALL"}}, other: [][]string{{"XADD", key, "*", "f", "v"}}, aborted: true},
+		{name: "incr", other: [][]string{{"INCR", key}}, aborted: true},
+		{name: "failed write", setup: [][]string{{"SET", key, "text"}}, other: [][]string{{"INCR", key}}},
+		{name: "expired", setup: [][]string{{"SET", key, "1", "PX", "10"}}, wait: 50 * time.Millisecond, aborted: true},
+		{name: "expiry cleared by set", setup: [][]string{{"SET", key, "1", "PX", "10"}, {"SET", key, "1"}}, wait: 50 * time.Millisecond},
+		{name: "unwatched", self: [][]string{{"UNWATCH"}}, other: [][]string{{"SET", key, "2"}}},
+	}
+
+	ctx := context.Background()
+	conn, other := dialTestServer(t), dialTestServer(t)
+	do := func(t *testing.T, conn *client.Conn, args ...string) resp.Value {
+		t.Helper()
+
+		reply, err := conn.Do(ctx, args...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+
+		return reply
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			do(t, conn, "SET", key, "1")
+			for _, args := range tt.setup {
+				do(t, conn, args...)
+			}
+
+			do(t, conn, "WATCH", key)
+			for _, args := range tt.self {
+				do(t, conn, args...)
+			}
+
+			for _, args := range tt.other {
+				do(t, other, args...)
+			}
+
+			time.Sleep(tt.wait)
+			do(t, conn, "MULTI")
+			do(t, conn, "SET", "test:watch:result", "1")
+
+			want := resp.Array(resp.OK())
+			if tt.aborted {
+				want = resp.NullArray()
+			}
+
+			if got := do(t, conn, "EXEC"); !sameReply(got, want) {
+				t.Fatalf("EXEC replied %q, want %q", got.MarshalProtocol(2), want.MarshalProtocol(2))
+			}
+		})
+	}
+}
+
+// TestWatchPerConnection checks that EXEC forgets the watched keys, and only
+// those of its own connection.
+func TestWatchPerConnection(t *testing.T) {
+	const key = "test:watch:connection"
+	ctx := context.Background()
+	a, b := dialTestServer(t), dialTestServer(t)
+
+	steps := []struct {
+		conn *client.Conn
+		args []string
+		want resp.Value
+	}{
+		{a, []string{"WATCH", key}, resp.OK()},
+		{b, []string{"WATCH", key}, resp.OK()},
+		{a, []string{"MULTI"}, resp.OK()},
+		{a, []string{"SET", key, "a"}, resp.Simple("QUEUED")},
+		{a, []string{"EXEC"}, resp.Array(resp.OK())},
+		// a's own transaction modified the key b watches.
+		{b, []string{"MULTI"}, resp.OK()},
+		{b, []string{"SET", key, "b"}, resp.Simple("QUEUED")},
+		{b, []string{"EXEC"}, resp.NullArray()},
+		// Both EXECs cleared the watches, so writes don't abort anything.
+		{a, []string{"SET", key, "c"}, resp.OK()},
+		{b, []string{"MULTI"}, resp.OK()},
+		{b, []string{"GET", key}, resp.Simple("QUEUED")},
+		{b, []string{"EXEC"}, resp.Array(resp.Bulk("c"))},
+	}
+
+	for i, step := range steps {
+		got, err := step.conn.Do(ctx, step.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !sameReply(got, step.want) {
+			t.Fatalf("step %d %v: got %q, want %q", i, step.args, got.MarshalProtocol(2), step.want.MarshalProtocol(2))
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Package client talks to the server, or any server speaking RESP, with a
+// pool of connections shared by concurrent goroutines.
+//
+//	c := client.New(client.Options{Addr: "localhost:6379"})
+//	defer c.Close()
+//
+//	err := c.Set(ctx, "key", "value", 0)
+//	value, err := c.Get(ctx, "key")
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+type Options struct {
+	// Addr is the host:port of the server, localhost:6379 by default.
+	Addr string
+	// Username and Password authenticate every new connection. The username
+	// defaults to "default" when only a password is set.
+	Username string
+	Password string
+	// Protocol is the RESP version to speak, 2 or 3. Connections switch to
+	// RESP3 with HELLO.
+	Protocol int
+	// PoolSize caps the number of open connections, 10 by default.
+	PoolSize int
+	// DialTimeout bounds connecting to the server, 5 seconds by default.
+	DialTimeout time.Duration
+	// Timeout bounds each exchange with the server when the context has no
+	// deadline. Zero means no timeout, which blocking commands rely on.
+	Timeout time.Duration
+	// OnPush is called with the push messages received while waiting for a
+	// reply, such as Pub/Sub messages on a RESP3 connection. They are
+	// dropped when it is nil.
+	OnPush func(resp.Value)
+}
+
+func (o Options) withDefaults() Options {
+	if o.Addr == "" {
+		o.Addr = "localhost:6379"
+	}
+
+	if o.Protocol == 0 {
+		o.Protocol = 2
+	}
+
+	if o.PoolSize <= 0 {
+		o.PoolSize = 10
+	}
+
+	if o.DialTimeout == 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+
+	return o
+}
+
+func (o Options) username() string {
+	if o.Username == "" {
+		return "default"
+	}
+
+	return o.Username
+}
+
+// Client is a pool of connections. It is safe for concurrent use.
+type Client struct {
+	opts Options
+	// slots holds one token per connection that may be open, so that
+	// getting a connection blocks once PoolSize of them are in use.
+	slots  chan struct{}
+	mu     sync.Mutex
+	idle   []*Conn
+	closed bool
+}
+
+func New(opts Options) *Client {
+	opts = opts.withDefaults()
+
+	return &Client{
+		opts:  opts,
+		slots: make(chan struct{}, opts.PoolSize),
+	}
+}
+
+// Conn takes a connection from the pool, dialing a new one when none is idle.
+// It must be given back with Put.
+func (c *Client) Conn(ctx context.Context) (*Conn, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.slots
+		return nil, ErrClosed
+	}
+
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	conn, err := Dial(ctx, c.opts)
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Put gives conn back to the pool. Broken connections are closed instead.
+func (c *Client) Put(conn *Conn) {
+	defer func() { <-c.slots }()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || conn.err != nil {
+		conn.Close()
+		return
+	}
+
+	c.idle = append(c.idle, conn)
+}
+
+// Close closes the idle connections. Connections in use are closed when they
+// are given back.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, conn := range c.idle {
+		conn.Close()
+	}
+	c.idle = nil
+
+	return nil
+}
+
+// Do runs a command on a pooled connection, see Conn.Do.
+func (c *Client) Do(ctx context.Context, args ...string) (resp.Value, error) {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return resp.Value{}, err
+	}
+	defer c.Put(conn)
+
+	return conn.Do(ctx, args...)
+}
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+func (c *Client) Ping(ctx context.Context) error {
+	return ok(c.Do(ctx, "PING"))
+}
+
+// Get returns the value of key, or Nil when it doesn't exist.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	return String(c.Do(ctx, "GET", key))
+}
+
+// Set sets key to value. The key expires after expiration unless it is zero.
+func (c *Client) Set(ctx context.Context, key, value string, expiration time.Duration) error {
+	if expiration > 0 {
+		return ok(c.Do(ctx, "SET", key, value, "PX", strconv.FormatInt(expiration.Milliseconds(), 10)))
+	}
+
+	return ok(c.Do(ctx, "SET", key, value))
+}
+
+func (c *Client) Incr(ctx context.Context, key string) (int, error) {
+	return Int(c.Do(ctx, "INCR", key))
+}
+
+func (c *Client) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return Strings(c.Do(ctx, "KEYS", pattern))
+}
+
+func (c *Client) Type(ctx context.Context, key string) (string, error) {
+	return String(c.Do(ctx, "TYPE", key))
+}
+
+func (c *Client) RPush(ctx context.Context, key string, values ...string) (int, error) {
+	return Int(c.Do(ctx, append([]string{"RPUSH", key}, values...)...))
+}
+
+func (c *Client) LPush(ctx context.Context, key string, values ...string) (int, error) {
+	return Int(c.Do(ctx, append([]string{"LPUSH", key}, values...)...))
+}
+
+// LPop removes and returns the first element of the list, or Nil when the
+// list is empty.
+func (c *Client) LPop(ctx context.Context, key string) (string, error) {
+	return String(c.Do(ctx, "LPOP", key))
+}
+
+// BLPop waits up to timeout for an element to pop from the list and returns
+// it. It returns Nil on timeout, while zero waits forever.
+func (c *Client) BLPop(ctx context.Context, timeout time.Duration, key string) (string, error) {
+	ret, err := Strings(c.Do(ctx, "BLPOP", key, strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64)))
+	if err != nil {
+		return "", err
+	}
+
+	if len(ret) != 2 {
+		return "", fmt.Errorf("client: unexpected BLPOP reply of %d elements", len(ret))
+	}
+
+	return ret[1], nil
+}
+
+func (c *Client) LRange(ctx context.Context, key string, start, stop int) ([]string, error) {
+	return Strings(c.Do(ctx, "LRANGE", key, strconv.Itoa(start), strconv.Itoa(stop)))
+}
+
+func (c *Client) LLen(ctx context.Context, key string) (int, error) {
+	return Int(c.Do(ctx, "LLEN", key))
+}
+
+// XAdd appends an entry made of alternated fields and values to the stream
+// and returns its ID. Use "*" as id to let the server generate it.
+func (c *Client) XAdd(ctx context.Context, stream, id string, fieldsAndValues ...string) (string, error) {
+	return String(c.Do(ctx, append([]string{"XADD", stream, id}, fieldsAndValues...)...))
+}
+
+func (c *Client) XLen(ctx context.Context, stream string) (int, error) {
+	return Int(c.Do(ctx, "XLEN", stream))
+}
+
+// ZAdd adds member to the sorted set, or updates its score, and returns the
+// number of members added.
+func (c *Client) ZAdd(ctx context.Context, key string, score float64, member string) (int, error) {
+	return Int(c.Do(ctx, "ZADD", key, resp.FormatDouble(score), member))
+}
+
+// ZScore returns the score of member, or Nil when it isn't in the set.
+func (c *Client) ZScore(ctx context.Context, key, member string) (float64, error) {
+	return Float(c.Do(ctx, "ZSCORE", key, member))
+}
+
+func (c *Client) ZRange(ctx context.Context, key string, start, stop int) ([]string, error) {
+	return Strings(c.Do(ctx, "ZRANGE", key, strconv.Itoa(start), strconv.Itoa(stop)))
+}
+
+func (c *Client) ZCard(ctx context.Context, key string) (int, error) {
+	return Int(c.Do(ctx, "ZCARD", key))
+}
+
+// ZRem removes member from the sorted set and returns the number of members
+// removed.
+func (c *Client) ZRem(ctx context.Context, key, member string) (int, error) {
+	return Int(c.Do(ctx, "ZREM", key, member))
+}
+
+// Publish sends message to channel and returns the number of clients that
+// received it.
+func (c *Client) Publish(ctx context.Context, channel, message string) (int, error) {
+	return Int(c.Do(ctx, "PUBLISH", channel, message))
+}
+
+// FCall calls a function loaded with FUNCTION LOAD.
+func (c *Client) FCall(ctx context.Context, function string, keys []string, args ...string) (resp.Value, error) {
+	return c.fcall(ctx, "FCALL", function, keys, args)
+}
+
+// FCallRO calls a function flagged no-writes, which replicas can run too.
+func (c *Client) FCallRO(ctx context.Context, function string, keys []string, args ...string) (resp.Value, error) {
+	return c.fcall(ctx, "FCALL_RO", function, keys, args)
+}
+
+func (c *Client) fcall(ctx context.Context, command, function string, keys, args []string) (resp.Value, error) {
+	cmd := append([]string{command, function, strconv.Itoa(len(keys))}, keys...)
+	v, err := c.Do(ctx, append(cmd, args...)...)
+	if err != nil {
+		return v, err
+	}
+
+	return v, replyError(v)
+}
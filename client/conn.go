@@ -0,0 +1,263 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// Conn is a single connection to the server. It isn't safe for concurrent
+// use: commands sent on a connection are answered in order, so only one
+// goroutine at a time may send and receive on it.
+type Conn struct {
+	conn   net.Conn
+	reader *resp.Resp
+	writer *bufio.Writer
+	buf    []byte
+	opts   Options
+	// protocol is the RESP version negotiated with HELLO.
+	protocol int
+	// err is set once the connection can't be used anymore, for instance
+	// after an I/O error or a cancelled read left a reply half consumed.
+	err error
+}
+
+// Dial connects to opts.Addr, then authenticates and switches protocol as
+// configured in opts.
+func Dial(ctx context.Context, opts Options) (*Conn, error) {
+	opts = opts.withDefaults()
+
+	dialer := net.Dialer{Timeout: opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		conn:     netConn,
+		reader:   &resp.Resp{Reader: bufio.NewReader(netConn)},
+		writer:   bufio.NewWriter(netConn),
+		opts:     opts,
+		protocol: 2,
+	}
+
+	if err := c.handshake(ctx); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// handshake sends HELLO when RESP3 is requested, or AUTH when only
+// credentials are configured.
+func (c *Conn) handshake(ctx context.Context) error {
+	var args []string
+	switch {
+	case c.opts.Protocol == 3:
+		args = []string{"HELLO", "3"}
+		if c.opts.Password != "" {
+			args = append(args, "AUTH", c.opts.username(), c.opts.Password)
+		}
+	case c.opts.Password != "":
+		args = []string{"AUTH", c.opts.username(), c.opts.Password}
+	default:
+		return nil
+	}
+
+	v, err := c.Do(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	if err := replyError(v); err != nil {
+		return err
+	}
+
+	if c.opts.Protocol == 3 {
+		c.protocol = 3
+	}
+
+	return nil
+}
+
+// Protocol returns the RESP version spoken on the connection.
+func (c *Conn) Protocol() int {
+	return c.protocol
+}
+
+// Do sends a command and waits for its reply. Error replies are returned as
+// values of type resp.ErrorType, the error is only set when the command
+// couldn't be sent or its reply couldn't be read.
+func (c *Conn) Do(ctx context.Context, args ...string) (resp.Value, error) {
+	if err := c.Send(resp.BulkArray(args...)); err != nil {
+		return resp.Value{}, err
+	}
+
+	if err := c.Flush(ctx); err != nil {
+		return resp.Value{}, err
+	}
+
+	return c.Receive(ctx)
+}
+
+// Send buffers v to be written by the next Flush.
+func (c *Conn) Send(v resp.Value) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.buf = v.AppendTo(c.buf[:0])
+	if _, err := c.writer.Write(c.buf); err != nil {
+		return c.fail(err)
+	}
+
+	return nil
+}
+
+// Flush writes the buffered commands.
+func (c *Conn) Flush(ctx context.Context) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	stop := c.watch(ctx)
+	err := c.writer.Flush()
+	if !stop() {
+		return c.fail(ctx.Err())
+	}
+
+	if err != nil {
+		return c.fail(err)
+	}
+
+	return nil
+}
+
+// Receive reads the next reply. Push messages that arrive before it are
+// passed to Options.OnPush.
+func (c *Conn) Receive(ctx context.Context) (resp.Value, error) {
+	for {
+		v, err := c.receive(ctx)
+		if err != nil || v.Typ != resp.PushType || c.opts.OnPush == nil {
+			return v, err
+		}
+
+		c.opts.OnPush(v)
+	}
+}
+
+// receive reads the next value, replies and push messages alike.
+func (c *Conn) receive(ctx context.Context) (resp.Value, error) {
+	if c.err != nil {
+		return resp.Value{}, c.err
+	}
+
+	stop := c.watch(ctx)
+	v, err := c.reader.Read()
+	if !stop() {
+		return resp.Value{}, c.fail(ctx.Err())
+	}
+
+	if err != nil {
+		return resp.Value{}, c.fail(err)
+	}
+
+	return v, nil
+}
+
+// ReadRDB reads the snapshot a master sends after replying FULLRESYNC to
+// PSYNC. It is sent like a bulk string, but without the trailing CRLF.
+func (c *Conn) ReadRDB(ctx context.Context) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	stop := c.watch(ctx)
+	rdb, err := c.readRDB()
+	if !stop() {
+		return nil, c.fail(ctx.Err())
+	}
+
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	return rdb, nil
+}
+
+func (c *Conn) readRDB() ([]byte, error) {
+	line, err := c.reader.Reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[0] != resp.BULK || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("invalid RDB header %q", line)
+	}
+
+	size, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid RDB size %q", line)
+	}
+
+	rdb := make([]byte, size)
+	if _, err := io.ReadFull(c.reader.Reader, rdb); err != nil {
+		return nil, err
+	}
+
+	return rdb, nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+
+	return c.conn.Close()
+}
+
+// RemoteAddr returns the address of the server.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// fail marks the connection as broken, since a reply may have been left
+// half read or a command half written.
+func (c *Conn) fail(err error) error {
+	if c.err == nil {
+		c.err = err
+	}
+
+	return err
+}
+
+// watch bounds the next I/O by the deadline of ctx, or by Options.Timeout
+// when ctx has none, and interrupts it if ctx is cancelled. The returned
+// function reports false when ctx was done before the I/O completed.
+func (c *Conn) watch(ctx context.Context) func() bool {
+	deadline, ok := ctx.Deadline()
+	if !ok && c.opts.Timeout > 0 {
+		deadline = time.Now().Add(c.opts.Timeout)
+	}
+	c.conn.SetDeadline(deadline)
+
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	return func() bool {
+		return stop() || ctx.Err() == nil
+	}
+}
+
+// ErrClosed is returned when using a closed connection or client.
+var ErrClosed = errors.New("client: connection closed")
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// Pipeline queues commands to send them at once, saving a round trip per
+// command. Unlike a transaction, other clients' commands may run in between.
+type Pipeline struct {
+	client *Client
+	cmds   []resp.Value
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{client: c}
+}
+
+// Queue adds a command to the pipeline.
+func (p *Pipeline) Queue(args ...string) {
+	p.cmds = append(p.cmds, resp.BulkArray(args...))
+}
+
+// Len returns the number of queued commands.
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec sends the queued commands on one connection and returns their replies
+// in order. Error replies are returned as values, so that one failing
+// command doesn't hide the replies of the others. The pipeline is emptied.
+func (p *Pipeline) Exec(ctx context.Context) ([]resp.Value, error) {
+	cmds := p.cmds
+	p.cmds = nil
+
+	conn, err := p.client.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.client.Put(conn)
+
+	return conn.pipeline(ctx, cmds)
+}
+
+func (c *Conn) pipeline(ctx context.Context, cmds []resp.Value) ([]resp.Value, error) {
+	for _, cmd := range cmds {
+		if err := c.Send(cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Flush(ctx); err != nil {
+		return nil, err
+	}
+
+	replies := make([]resp.Value, len(cmds))
+	for i := range replies {
+		v, err := c.Receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		replies[i] = v
+	}
+
+	return replies, nil
+}
+
+// ErrTxFailed is returned by Transaction when a watched key was modified, so
+// EXEC didn't run the queued commands. The transaction can be retried.
+var ErrTxFailed = errors.New("client: transaction failed, a watched key was modified")
+
+// Tx is the transaction being prepared by the function given to Transaction.
+type Tx struct {
+	conn *Conn
+	cmds []resp.Value
+}
+
+// Do runs a command right away, before the transaction starts. It is meant
+// to read the watched keys.
+func (tx *Tx) Do(ctx context.Context, args ...string) (resp.Value, error) {
+	return tx.conn.Do(ctx, args...)
+}
+
+// Queue adds a command to run atomically with MULTI/EXEC.
+func (tx *Tx) Queue(args ...string) {
+	tx.cmds = append(tx.cmds, resp.BulkArray(args...))
+}
+
+// Transaction watches keys, calls fn to queue commands, then runs them with
+// MULTI/EXEC and returns their replies. If one of keys is modified before
+// EXEC, nothing runs and ErrTxFailed is returned.
+//
+//	replies, err := c.Transaction(ctx, func(tx *client.Tx) error {
+//		balance, err := client.Int(tx.Do(ctx, "GET", "balance"))
+//		if err != nil {
+//			return err
+//		}
+//
+//		tx.Queue("SET", "balance", strconv.Itoa(balance-10))
+//		return nil
+//	}, "balance")
+func (c *Client) Transaction(ctx context.Context, fn func(tx *Tx) error, keys ...string) ([]resp.Value, error) {
+	conn, err := c.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Put(conn)
+
+	if len(keys) > 0 {
+		if err := ok(conn.Do(ctx, append([]string{"WATCH"}, keys...)...)); err != nil {
+			return nil, err
+		}
+	}
+
+	tx := &Tx{conn: conn}
+	if err := fn(tx); err != nil {
+		if len(keys) > 0 {
+			conn.Do(ctx, "UNWATCH")
+		}
+
+		return nil, err
+	}
+
+	cmds := make([]resp.Value, 0, len(tx.cmds)+2)
+	cmds = append(cmds, resp.BulkArray("MULTI"))
+	cmds = append(cmds, tx.cmds...)
+	cmds = append(cmds, resp.BulkArray("EXEC"))
+
+	replies, err := conn.pipeline(ctx, cmds)
+	if err != nil {
+		return nil, err
+	}
+
+	// A command refused while queueing makes EXEC fail with EXECABORT, but
+	// its own error is more telling.
+	for _, v := range replies[:len(replies)-1] {
+		if err := replyError(v); err != nil {
+			return nil, err
+		}
+	}
+
+	exec := replies[len(replies)-1]
+	switch exec.Typ {
+	case resp.NullArrayType, resp.NullType:
+		return nil, ErrTxFailed
+	case resp.ArrayType:
+		return exec.Array, nil
+	default:
+		if err := replyError(exec); err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("client: unexpected %v reply", exec.Typ)
+	}
+}
@@ -0,0 +1,251 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// fakeServer answers ECHO, MULTI, EXEC, WATCH and UNWATCH like the server
+// would, replying to each request with its own write so that replies
+// arrive in as many pieces as there were commands. WATCH of a key named
+// "stale" makes the next EXEC fail as if the key had been modified.
+func fakeServer(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go serveFake(conn)
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func serveFake(conn net.Conn) {
+	defer conn.Close()
+
+	reader := &resp.Resp{Reader: bufio.NewReader(conn)}
+	var (
+		multi  bool
+		stale  bool
+		queued []resp.Value
+	)
+
+	for {
+		request, err := reader.ReadRequest()
+		if err != nil {
+			return
+		}
+
+		args := make([]string, len(request.Array))
+		for i, arg := range request.Array {
+			args[i] = arg.String()
+		}
+
+		var reply resp.Value
+		switch command := strings.ToUpper(args[0]); {
+		case command == "MULTI":
+			multi = true
+			reply = resp.OK()
+		case command == "EXEC":
+			if stale {
+				reply = resp.NullArray()
+			} else {
+				reply = resp.Array(queued...)
+			}
+			multi, stale, queued = false, false, nil
+		case command == "WATCH":
+			stale = stale || args[len(args)-1] == "stale"
+			reply = resp.OK()
+		case command == "UNWATCH":
+			stale = false
+			reply = resp.OK()
+		case command == "ECHO" && len(args) == 2:
+			if multi {
+				queued = append(queued, resp.Bulk(args[1]))
+				reply = resp.Simple("QUEUED")
+			} else {
+				reply = resp.Bulk(args[1])
+			}
+		default:
+			reply = resp.Errf("ERR unknown command '%s'", args[0])
+		}
+
+		if _, err := conn.Write(reply.Marshal()); err != nil {
+			return
+		}
+	}
+}
+
+func TestPipelineExec(t *testing.T) {
+	ctx := context.Background()
+	c := New(Options{Addr: fakeServer(t)})
+	defer c.Close()
+
+	p := c.Pipeline()
+	for i := range 100 {
+		if i == 50 {
+			p.Queue("NOPE")
+		}
+
+		p.Queue("ECHO", strconv.Itoa(i))
+	}
+
+	replies, err := p.Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Len() != 0 {
+		t.Fatalf("pipeline still holds %d commands after Exec", p.Len())
+	}
+
+	if len(replies) != 101 {
+		t.Fatalf("got %d replies, want 101", len(replies))
+	}
+
+	for i, reply := range replies {
+		want := strconv.Itoa(i)
+		switch {
+		case i == 50:
+			if reply.Typ != resp.ErrorType {
+				t.Fatalf("reply %d is %v, want an error", i, reply)
+			}
+
+			continue
+		case i > 50:
+			want = strconv.Itoa(i - 1)
+		}
+
+		if got := reply.String(); reply.Typ != resp.BulkType || got != want {
+			t.Fatalf("reply %d is %q, want %q", i, got, want)
+		}
+	}
+
+	// The connection went back to the pool in sync with the server.
+	if got, err := String(c.Do(ctx, "ECHO", "after")); err != nil || got != "after" {
+		t.Fatalf("got %q, %v after the pipeline, want \"after\"", got, err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  []string
+		queue [][]string
+		want  []string
+		err   error
+	}{
+		{
+			name:  "replies in order",
+			queue: [][]string{{"ECHO", "a"}, {"ECHO", "b"}, {"ECHO", "c"}},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "watched",
+			keys:  []string{"balance"},
+			queue: [][]string{{"ECHO", "a"}},
+			want:  []string{"a"},
+		},
+		{
+			name: "empty",
+			want: []string{},
+		},
+		{
+			name:  "modified watched key",
+			keys:  []string{"stale"},
+			queue: [][]string{{"ECHO", "a"}},
+			err:   ErrTxFailed,
+		},
+		{
+			name:  "refused command",
+			queue: [][]string{{"ECHO", "a"}, {"NOPE"}},
+			err:   Error("ERR unknown command 'NOPE'"),
+		},
+	}
+
+	ctx := context.Background()
+	c := New(Options{Addr: fakeServer(t), PoolSize: 1})
+	defer c.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies, err := c.Transaction(ctx, func(tx *Tx) error {
+				for _, args := range tt.queue {
+					tx.Queue(args...)
+				}
+
+				return nil
+			}, tt.keys...)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, len(replies))
+			for i, reply := range replies {
+				got[i] = reply.String()
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionFnError(t *testing.T) {
+	ctx := context.Background()
+	c := New(Options{Addr: fakeServer(t), PoolSize: 1})
+	defer c.Close()
+
+	errAbort := errors.New("abort")
+	_, err := c.Transaction(ctx, func(tx *Tx) error {
+		if got, err := String(tx.Do(ctx, "ECHO", "read")); err != nil || got != "read" {
+			t.Errorf("got %q, %v from Tx.Do, want \"read\"", got, err)
+		}
+
+		tx.Queue("ECHO", "never sent")
+		return errAbort
+	}, "stale")
+
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("got error %v, want %v", err, errAbort)
+	}
+
+	// UNWATCH cleared the stale watch, so the next transaction runs.
+	replies, err := c.Transaction(ctx, func(tx *Tx) error {
+		tx.Queue("ECHO", "ran")
+		return nil
+	})
+	if err != nil || len(replies) != 1 || replies[0].String() != "ran" {
+		t.Fatalf("got %v, %v, want [ran]", replies, err)
+	}
+}
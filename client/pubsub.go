@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// Message is a message published to a channel the receiver subscribed to.
+type Message struct {
+	// Kind is "message", "pmessage" for pattern subscriptions or "smessage"
+	// for shard channels.
+	Kind string
+	// Pattern is the pattern that matched Channel, for pmessage only.
+	Pattern string
+	Channel string
+	Payload string
+}
+
+// PubSub receives the messages published to its subscriptions. It holds a
+// connection of its own, outside of the pool, until it is closed. Like Conn,
+// it isn't safe for concurrent use.
+type PubSub struct {
+	conn *Conn
+}
+
+// Subscribe opens a receiver subscribed to channels.
+func (c *Client) Subscribe(ctx context.Context, channels ...string) (*PubSub, error) {
+	return c.newPubSub(ctx, "SUBSCRIBE", channels)
+}
+
+// PSubscribe opens a receiver subscribed to the channels matching patterns.
+func (c *Client) PSubscribe(ctx context.Context, patterns ...string) (*PubSub, error) {
+	return c.newPubSub(ctx, "PSUBSCRIBE", patterns)
+}
+
+func (c *Client) newPubSub(ctx context.Context, command string, names []string) (*PubSub, error) {
+	conn, err := Dial(ctx, c.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &PubSub{conn: conn}
+	if err := ps.send(ctx, command, names); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+func (ps *PubSub) Subscribe(ctx context.Context, channels ...string) error {
+	return ps.send(ctx, "SUBSCRIBE", channels)
+}
+
+func (ps *PubSub) PSubscribe(ctx context.Context, patterns ...string) error {
+	return ps.send(ctx, "PSUBSCRIBE", patterns)
+}
+
+// SSubscribe subscribes to shard channels, which must all hash to the same
+// slot.
+func (ps *PubSub) SSubscribe(ctx context.Context, channels ...string) error {
+	return ps.send(ctx, "SSUBSCRIBE", channels)
+}
+
+// Unsubscribe unsubscribes from channels, or from every channel when none is
+// given.
+func (ps *PubSub) Unsubscribe(ctx context.Context, channels ...string) error {
+	return ps.send(ctx, "UNSUBSCRIBE", channels)
+}
+
+func (ps *PubSub) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	return ps.send(ctx, "PUNSUBSCRIBE", patterns)
+}
+
+func (ps *PubSub) SUnsubscribe(ctx context.Context, channels ...string) error {
+	return ps.send(ctx, "SUNSUBSCRIBE", channels)
+}
+
+// send doesn't wait for the confirmations, they are skipped by Receive as
+// messages may arrive before them.
+func (ps *PubSub) send(ctx context.Context, command string, names []string) error {
+	if err := ps.conn.Send(resp.BulkArray(append([]string{command}, names...)...)); err != nil {
+		return err
+	}
+
+	return ps.conn.Flush(ctx)
+}
+
+// Receive waits for the next message. Subscription confirmations are
+// skipped, and a refused subscription is returned as an Error.
+func (ps *PubSub) Receive(ctx context.Context) (*Message, error) {
+	for {
+		// Messages are pushes in RESP3, so they are read without going
+		// through Options.OnPush.
+		v, err := ps.conn.receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := replyError(v); err != nil {
+			return nil, err
+		}
+
+		if v.Typ != resp.ArrayType && v.Typ != resp.PushType || len(v.Array) == 0 {
+			return nil, fmt.Errorf("client: unexpected %v reply", v.Typ)
+		}
+
+		elems := v.Array
+		switch kind := strings.ToLower(elems[0].String()); kind {
+		case "message", "smessage":
+			if len(elems) == 3 {
+				return &Message{Kind: kind, Channel: elems[1].String(), Payload: elems[2].String()}, nil
+			}
+		case "pmessage":
+			if len(elems) == 4 {
+				return &Message{Kind: kind, Pattern: elems[1].String(), Channel: elems[2].String(), Payload: elems[3].String()}, nil
+			}
+		case "subscribe", "psubscribe", "ssubscribe", "unsubscribe", "punsubscribe", "sunsubscribe", "pong":
+			continue
+		}
+
+		return nil, fmt.Errorf("client: unexpected %q message", elems[0].String())
+	}
+}
+
+// Close closes the connection of the receiver, which ends its subscriptions.
+func (ps *PubSub) Close() error {
+	return ps.conn.Close()
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// Error is an error reply sent by the server, such as "ERR no such key".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Nil is returned by the typed helpers when the server replies with null,
+// for instance on GET of a missing key.
+var Nil = errors.New("client: nil reply")
+
+func replyError(v resp.Value) error {
+	if v.Typ == resp.ErrorType {
+		return Error(v.String())
+	}
+
+	return nil
+}
+
+// String converts the reply of Do to a string. It is meant to wrap the call:
+//
+//	name, err := client.String(c.Do(ctx, "CLIENT", "GETNAME"))
+func String(v resp.Value, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+
+	switch v.Typ {
+	case resp.ErrorType:
+		return "", replyError(v)
+	case resp.NullType, resp.NullArrayType:
+		return "", Nil
+	case resp.SimpleStringType, resp.BulkType, resp.VerbatimType, resp.BigNumberType:
+		return v.String(), nil
+	case resp.IntegerType:
+		return strconv.Itoa(v.Int), nil
+	case resp.DoubleType:
+		return resp.FormatDouble(v.Double), nil
+	default:
+		return "", fmt.Errorf("client: unexpected %v reply", v.Typ)
+	}
+}
+
+// Int converts the reply of Do to an int.
+func Int(v resp.Value, err error) (int, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	switch v.Typ {
+	case resp.ErrorType:
+		return 0, replyError(v)
+	case resp.NullType, resp.NullArrayType:
+		return 0, Nil
+	case resp.IntegerType:
+		return v.Int, nil
+	case resp.SimpleStringType, resp.BulkType:
+		return strconv.Atoi(v.String())
+	default:
+		return 0, fmt.Errorf("client: unexpected %v reply", v.Typ)
+	}
+}
+
+// Float converts the reply of Do to a float64. Scores are bulk strings in
+// RESP2 and doubles in RESP3, both are accepted.
+func Float(v resp.Value, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	switch v.Typ {
+	case resp.ErrorType:
+		return 0, replyError(v)
+	case resp.NullType, resp.NullArrayType:
+		return 0, Nil
+	case resp.DoubleType:
+		return v.Double, nil
+	case resp.IntegerType:
+		return float64(v.Int), nil
+	case resp.SimpleStringType, resp.BulkType:
+		return strconv.ParseFloat(v.String(), 64)
+	default:
+		return 0, fmt.Errorf("client: unexpected %v reply", v.Typ)
+	}
+}
+
+// Strings converts the reply of Do to a slice of strings. Null elements are
+// returned as empty strings.
+func Strings(v resp.Value, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	switch v.Typ {
+	case resp.ErrorType:
+		return nil, replyError(v)
+	case resp.NullType, resp.NullArrayType:
+		return nil, Nil
+	case resp.ArrayType, resp.SetType, resp.PushType:
+		ss := make([]string, len(v.Array))
+		for i, elem := range v.Array {
+			s, err := String(elem, nil)
+			if err != nil && err != Nil {
+				return nil, err
+			}
+
+			ss[i] = s
+		}
+
+		return ss, nil
+	default:
+		return nil, fmt.Errorf("client: unexpected %v reply", v.Typ)
+	}
+}
+
+// ok checks that the reply of Do is a simple string, usually OK.
+func ok(v resp.Value, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if v.Typ != resp.SimpleStringType {
+		if err := replyError(v); err != nil {
+			return err
+		}
+
+		return fmt.Errorf("client: unexpected %v reply", v.Typ)
+	}
+
+	return nil
+}
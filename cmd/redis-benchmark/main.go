@@ -0,0 +1,135 @@
+// Command redis-benchmark loads the server with parallel clients sending the
+// commands of each test, and reports the throughput and the latency
+// percentiles. Its flags and output follow redis-benchmark.
+//
+//	go run ./cmd/redis-benchmark -c 50 -n 100000 -P 16 -r 10000 -t set,get
+//	go run ./cmd/redis-benchmark -q --csv > results.csv
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type config struct {
+	host      string
+	port      int
+	password  string
+	user      string
+	clients   int
+	requests  int
+	dataSize  int
+	pipeline  int
+	keyspace  int
+	tests     string
+	quiet     bool
+	csv       bool
+	precision int
+}
+
+func (cfg config) addr() string {
+	return net.JoinHostPort(cfg.host, strconv.Itoa(cfg.port))
+}
+
+// test is a benchmark of one command. In args, __rand_int__ is replaced by a
+// random number below -r, and __data__ by the payload of -d bytes.
+type test struct {
+	name string
+	args []string
+}
+
+// tests run in this order, whatever the order given to -t.
+var tests = []test{
+	{"PING", []string{"PING"}},
+	{"SET", []string{"SET", "key:__rand_int__", "__data__"}},
+	{"GET", []string{"GET", "key:__rand_int__"}},
+	{"INCR", []string{"INCR", "counter:__rand_int__"}},
+	{"LPUSH", []string{"LPUSH", "mylist", "__data__"}},
+	{"RPUSH", []string{"RPUSH", "mylist", "__data__"}},
+	{"LPOP", []string{"LPOP", "mylist"}},
+	{"ZADD", []string{"ZADD", "myzset", "0", "element:__rand_int__"}},
+	{"XADD", []string{"XADD", "mystream", "*", "myfield", "__data__"}},
+	{"PUBLISH", []string{"PUBLISH", "mychannel", "__data__"}},
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.host, "h", "127.0.0.1", "server hostname")
+	flag.IntVar(&cfg.port, "p", 6379, "server port")
+	flag.StringVar(&cfg.password, "a", "", "password for the server")
+	flag.StringVar(&cfg.user, "user", "", "username to authenticate with, with -a")
+	flag.IntVar(&cfg.clients, "c", 50, "number of parallel connections")
+	flag.IntVar(&cfg.requests, "n", 100000, "total number of requests")
+	flag.IntVar(&cfg.dataSize, "d", 3, "data size of SET/GET value in bytes")
+	flag.IntVar(&cfg.pipeline, "P", 1, "pipeline <numreq> requests")
+	flag.IntVar(&cfg.keyspace, "r", 0, "use random keys in the range [0, keyspacelen)")
+	flag.StringVar(&cfg.tests, "t", "", "only run the comma separated list of tests")
+	flag.BoolVar(&cfg.quiet, "q", false, "quiet, just show query/sec values")
+	flag.BoolVar(&cfg.csv, "csv", false, "output in CSV format")
+	flag.IntVar(&cfg.precision, "precision", 3, "number of decimal places in latency output")
+	flag.Parse()
+
+	if cfg.clients < 1 || cfg.requests < 1 || cfg.pipeline < 1 || cfg.dataSize < 0 || cfg.keyspace < 0 {
+		fmt.Fprintln(os.Stderr, "redis-benchmark: -c, -n and -P must be positive, -d and -r can't be negative")
+		os.Exit(1)
+	}
+
+	selected, err := selectTests(cfg.tests)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "redis-benchmark:", err)
+		os.Exit(1)
+	}
+
+	if cfg.csv {
+		printCSVHeader()
+	}
+
+	for _, t := range selected {
+		r, err := run(cfg, t)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "redis-benchmark: %s: %v\n", t.name, err)
+			os.Exit(1)
+		}
+
+		switch {
+		case cfg.csv:
+			printCSV(cfg, t, r)
+		case cfg.quiet:
+			printQuiet(cfg, t, r)
+		default:
+			printReport(cfg, t, r)
+		}
+	}
+}
+
+// selectTests returns the tests named in list, or all of them when it's
+// empty.
+func selectTests(list string) ([]test, error) {
+	if list == "" {
+		return tests, nil
+	}
+
+	names := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if !slices.ContainsFunc(tests, func(t test) bool { return t.name == name }) {
+			return nil, fmt.Errorf("unknown test %q", name)
+		}
+
+		names[name] = true
+	}
+
+	var selected []test
+	for _, t := range tests {
+		if names[t.name] {
+			selected = append(selected, t)
+		}
+	}
+
+	return selected, nil
+}
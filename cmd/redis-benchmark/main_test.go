@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSelectTests(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+		err  string
+	}{
+		{list: "", want: []string{"PING", "SET", "GET", "INCR", "LPUSH", "RPUSH", "LPOP", "ZADD", "XADD", "PUBLISH"}},
+		{list: "get,set", want: []string{"SET", "GET"}},
+		{list: " Ping , xadd ", want: []string{"PING", "XADD"}},
+		{list: "set,set", want: []string{"SET"}},
+		{list: "set,hset", err: `unknown test "HSET"`},
+		{list: "set,", err: `unknown test ""`},
+	}
+
+	for _, tt := range tests {
+		selected, err := selectTests(tt.list)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("selectTests(%q) returned %v, want %s", tt.list, err, tt.err)
+			}
+
+			continue
+		}
+
+		var names []string
+		for _, test := range selected {
+			names = append(names, test.name)
+		}
+
+		if err != nil || !slices.Equal(names, tt.want) {
+			t.Errorf("selectTests(%q) is %v, %v, want %v", tt.list, names, err, tt.want)
+		}
+	}
+}
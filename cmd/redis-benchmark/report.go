@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+func printReport(cfg config, t test, r result) {
+	fmt.Printf("====== %s ======\n", t.name)
+	fmt.Printf("  %d requests completed in %.2f seconds\n", r.requests, r.elapsed.Seconds())
+	fmt.Printf("  %d parallel clients\n", cfg.clients)
+	fmt.Printf("  %d bytes payload\n", cfg.dataSize)
+	fmt.Printf("  pipeline: %d\n", cfg.pipeline)
+	fmt.Println()
+
+	// The percentiles get closer to 100 by halving the distance left, so
+	// that the tail of the distribution is detailed.
+	fmt.Println("Latency by percentile distribution:")
+	for p := 0.0; ; p += (100 - p) / 2 {
+		if p > 99.9 {
+			p = 100
+		}
+
+		latency := r.percentile(p)
+		count := sort.Search(len(r.latencies), func(i int) bool { return r.latencies[i] > latency })
+		fmt.Printf("%.3f%% <= %s milliseconds (cumulative count %d)\n", p, ms(cfg, latency), count)
+
+		if p == 100 {
+			break
+		}
+	}
+	fmt.Println()
+
+	fmt.Println("Summary:")
+	fmt.Printf("  throughput summary: %.2f requests per second\n", r.throughput())
+	fmt.Println("  latency summary (msec):")
+	fmt.Printf("    %9s %9s %9s %9s %9s %9s %9s\n", "avg", "min", "p50", "p95", "p99", "p99.9", "max")
+	fmt.Printf("    %9s %9s %9s %9s %9s %9s %9s\n",
+		ms(cfg, r.average()), ms(cfg, r.percentile(0)), ms(cfg, r.percentile(50)), ms(cfg, r.percentile(95)),
+		ms(cfg, r.percentile(99)), ms(cfg, r.percentile(99.9)), ms(cfg, r.percentile(100)))
+	fmt.Println()
+}
+
+func printQuiet(cfg config, t test, r result) {
+	fmt.Printf("%s: %.2f requests per second, p50=%s msec\n", t.name, r.throughput(), ms(cfg, r.percentile(50)))
+}
+
+func printCSVHeader() {
+	fmt.Println(`"test","rps","avg_latency_ms","min_latency_ms","p50_latency_ms","p95_latency_ms","p99_latency_ms","p99.9_latency_ms","max_latency_ms"`)
+}
+
+func printCSV(cfg config, t test, r result) {
+	fmt.Printf("%q,\"%.2f\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\",\"%s\"\n",
+		t.name, r.throughput(), ms(cfg, r.average()), ms(cfg, r.percentile(0)), ms(cfg, r.percentile(50)),
+		ms(cfg, r.percentile(95)), ms(cfg, r.percentile(99)), ms(cfg, r.percentile(99.9)), ms(cfg, r.percentile(100)))
+}
+
+// ms formats d in milliseconds with the precision of --precision.
+func ms(cfg config, d time.Duration) string {
+	return fmt.Sprintf("%.*f", cfg.precision, float64(d)/float64(time.Millisecond))
+}
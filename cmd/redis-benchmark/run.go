@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+type result struct {
+	requests int
+	elapsed  time.Duration
+	// latencies holds the latency of every request, sorted.
+	latencies []time.Duration
+}
+
+func (r result) throughput() float64 {
+	return float64(r.requests) / r.elapsed.Seconds()
+}
+
+// percentile returns the latency below which p percent of the requests
+// completed.
+func (r result) percentile(p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(r.latencies)))) - 1
+	return r.latencies[min(max(i, 0), len(r.latencies)-1)]
+}
+
+func (r result) average() time.Duration {
+	var total time.Duration
+	for _, latency := range r.latencies {
+		total += latency
+	}
+
+	return total / time.Duration(len(r.latencies))
+}
+
+// run sends the requests of t from cfg.clients connections. Each client takes
+// up to cfg.pipeline requests at a time, sends them at once, and times every
+// request from the send to the arrival of its reply.
+func run(cfg config, t test) (result, error) {
+	ctx := context.Background()
+	opts := client.Options{Addr: cfg.addr(), Username: cfg.user, Password: cfg.password}
+
+	conns := make([]*client.Conn, cfg.clients)
+	for i := range conns {
+		conn, err := client.Dial(ctx, opts)
+		if err != nil {
+			return result{}, err
+		}
+		defer conn.Close()
+
+		conns[i] = conn
+	}
+
+	var (
+		remaining atomic.Int64
+		wg        sync.WaitGroup
+		errOnce   sync.Once
+		runErr    error
+	)
+	remaining.Store(int64(cfg.requests))
+	latencies := make([][]time.Duration, len(conns))
+	data := strings.Repeat("x", cfg.dataSize)
+
+	start := time.Now()
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var err error
+			latencies[i], err = runClient(ctx, conn, cfg, t, data, &remaining)
+			if err != nil {
+				errOnce.Do(func() { runErr = err })
+				remaining.Store(0)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	if runErr != nil {
+		return result{}, runErr
+	}
+
+	r := result{requests: cfg.requests, elapsed: elapsed, latencies: slices.Concat(latencies...)}
+	slices.Sort(r.latencies)
+	return r, nil
+}
+
+func runClient(ctx context.Context, conn *client.Conn, cfg config, t test, data string, remaining *atomic.Int64) ([]time.Duration, error) {
+	var latencies []time.Duration
+	for {
+		n := claim(remaining, cfg.pipeline)
+		if n == 0 {
+			return latencies, nil
+		}
+
+		for range n {
+			if err := conn.Send(resp.BulkArray(expand(t.args, cfg.keyspace, data)...)); err != nil {
+				return nil, err
+			}
+		}
+
+		sent := time.Now()
+		if err := conn.Flush(ctx); err != nil {
+			return nil, err
+		}
+
+		for range n {
+			v, err := conn.Receive(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			if v.Typ == resp.ErrorType {
+				return nil, fmt.Errorf("error from server: %s", v.String())
+			}
+
+			latencies = append(latencies, time.Since(sent))
+		}
+	}
+}
+
+// claim takes up to n of the remaining requests and returns how many it got.
+func claim(remaining *atomic.Int64, n int) int {
+	for {
+		left := remaining.Load()
+		if left <= 0 {
+			return 0
+		}
+
+		take := min(int64(n), left)
+		if remaining.CompareAndSwap(left, left-take) {
+			return int(take)
+		}
+	}
+}
+
+// expand fills the placeholders of args. Without a keyspace, every request
+// uses the same key, like redis-benchmark does.
+func expand(args []string, keyspace int, data string) []string {
+	ret := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case arg == "__data__":
+			ret[i] = data
+		case strings.Contains(arg, "__rand_int__"):
+			n := 0
+			if keyspace > 0 {
+				n = rand.IntN(keyspace)
+			}
+
+			ret[i] = strings.Replace(arg, "__rand_int__", fmt.Sprintf("%012d", n), 1)
+		default:
+			ret[i] = arg
+		}
+	}
+
+	return ret
+}
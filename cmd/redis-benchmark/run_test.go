@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+func TestPercentile(t *testing.T) {
+	r := result{latencies: []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1},
+		{10, 1},
+		{11, 2},
+		{50, 5},
+		{95, 10},
+		{99.9, 10},
+		{100, 10},
+	}
+
+	for _, tt := range tests {
+		if got := r.percentile(tt.p); got != tt.want {
+			t.Errorf("percentile(%v) is %d, want %d", tt.p, got, tt.want)
+		}
+	}
+
+	if got := r.average(); got != 5 {
+		t.Errorf("average is %d, want 5", got)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	tests := []struct {
+		remaining, n int
+		want, left   int
+	}{
+		{10, 1, 1, 9},
+		{10, 16, 10, 0},
+		{16, 16, 16, 0},
+		{0, 16, 0, 0},
+		// run stores 0 on errors, but claim copes with anything.
+		{-3, 16, 0, -3},
+	}
+
+	for _, tt := range tests {
+		var remaining atomic.Int64
+		remaining.Store(int64(tt.remaining))
+		if got := claim(&remaining, tt.n); got != tt.want || remaining.Load() != int64(tt.left) {
+			t.Errorf("claim(%d, %d) is %d leaving %d, want %d leaving %d", tt.remaining, tt.n, got, remaining.Load(), tt.want, tt.left)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		keyspace int
+		want     []string
+	}{
+		{"no placeholder", []string{"PING"}, 10, []string{"PING"}},
+		{"data", []string{"SET", "key", "__data__"}, 0, []string{"SET", "key", "xxx"}},
+		{"same key without keyspace", []string{"GET", "key:__rand_int__"}, 0, []string{"GET", "key:000000000000"}},
+		{"single key keyspace", []string{"GET", "key:__rand_int__"}, 1, []string{"GET", "key:000000000000"}},
+		{"data is not a key", []string{"SET", "__data__:__rand_int__"}, 0, []string{"SET", "__data__:000000000000"}},
+	}
+
+	for _, tt := range tests {
+		got := expand(tt.args, tt.keyspace, "xxx")
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	for range 100 {
+		key := expand([]string{"key:__rand_int__"}, 1000, "")[0]
+		if n, err := strconv.Atoi(strings.TrimPrefix(key, "key:")); err != nil || len(key) != len("key:000000000000") || n >= 1000 {
+			t.Fatalf("random key %q is not below the keyspace", key)
+		}
+	}
+}
+
+// fakeServer answers every request with reply and counts the requests. It
+// returns a config pointing at it.
+func fakeServer(t *testing.T, reply resp.Value) (config, *atomic.Int64) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		requests atomic.Int64
+		wg       sync.WaitGroup
+	)
+	t.Cleanup(func() {
+		l.Close()
+		wg.Wait()
+	})
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+
+				r := &resp.Resp{Reader: bufio.NewReader(conn)}
+				w := bufio.NewWriter(conn)
+				for {
+					if _, err := r.ReadRequest(); err != nil {
+						return
+					}
+
+					requests.Add(1)
+					w.Write(reply.Marshal())
+					if r.Reader.Buffered() == 0 {
+						if err := w.Flush(); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return config{host: addr.IP.String(), port: addr.Port}, &requests
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name                        string
+		clients, requests, pipeline int
+	}{
+		{"one client", 1, 10, 1},
+		{"more clients than requests", 8, 3, 1},
+		{"pipeline", 4, 100, 16},
+		{"pipeline longer than the requests", 2, 5, 16},
+	}
+
+	ping := test{"PING", []string{"PING"}}
+	cfg, served := fakeServer(t, resp.Simple("PONG"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served.Store(0)
+			cfg.clients, cfg.requests, cfg.pipeline = tt.clients, tt.requests, tt.pipeline
+			r, err := run(cfg, ping)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.requests != tt.requests || len(r.latencies) != tt.requests || served.Load() != int64(tt.requests) {
+				t.Fatalf("reported %d requests with %d latencies, the server got %d, want %d", r.requests, len(r.latencies), served.Load(), tt.requests)
+			}
+
+			for i := 1; i < len(r.latencies); i++ {
+				if r.latencies[i] < r.latencies[i-1] {
+					t.Fatal("latencies are not sorted")
+				}
+			}
+		})
+	}
+}
+
+func TestRunError(t *testing.T) {
+	cfg, _ := fakeServer(t, resp.Err("ERR unknown command"))
+	cfg.clients, cfg.requests, cfg.pipeline = 2, 10, 1
+	if _, err := run(cfg, tests[0]); err == nil || err.Error() != "error from server: ERR unknown command" {
+		t.Fatalf("got %v, want the error from the server", err)
+	}
+}
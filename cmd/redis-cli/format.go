@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// format renders a reply the way redis-cli does, ending with a newline.
+func format(v resp.Value, raw bool) string {
+	if raw {
+		return formatRaw(v) + "\n"
+	}
+
+	return formatTTY(v, "")
+}
+
+// formatTTY renders v for a terminal. Nested aggregates are indented under
+// the index of their parent, each line after the first starting with indent.
+func formatTTY(v resp.Value, indent string) string {
+	switch v.Typ {
+	case resp.SimpleStringType:
+		return v.String() + "\n"
+	case resp.ErrorType:
+		return "(error) " + v.String() + "\n"
+	case resp.IntegerType:
+		return fmt.Sprintf("(integer) %d\n", v.Int)
+	case resp.DoubleType:
+		return "(double) " + resp.FormatDouble(v.Double) + "\n"
+	case resp.BooleanType:
+		if v.Bool {
+			return "(true)\n"
+		}
+
+		return "(false)\n"
+	case resp.BigNumberType:
+		return "(big number) " + v.String() + "\n"
+	case resp.BulkType:
+		return quote(v.Bytes) + "\n"
+	case resp.VerbatimType:
+		return v.String() + "\n"
+	case resp.NullType, resp.NullArrayType:
+		return "(nil)\n"
+	case resp.ArrayType, resp.PushType:
+		return formatAggregate(v.Array, indent, ')', 1, "(empty array)\n")
+	case resp.SetType:
+		return formatAggregate(v.Array, indent, '~', 1, "(empty set)\n")
+	case resp.MapType, resp.AttributeType:
+		return formatAggregate(v.Array, indent, '#', 2, "(empty hash)\n")
+	default:
+		return fmt.Sprintf("(unknown reply type %v)\n", v.Typ)
+	}
+}
+
+// formatAggregate numbers the elements of an aggregate, or its key-value
+// pairs when width is 2.
+func formatAggregate(elems []resp.Value, indent string, sep byte, width int, empty string) string {
+	n := len(elems) / width
+	if n == 0 {
+		return empty
+	}
+
+	digits := len(strconv.Itoa(n))
+	nested := indent + strings.Repeat(" ", digits+2)
+
+	var sb strings.Builder
+	for i := range n {
+		if i > 0 {
+			sb.WriteString(indent)
+		}
+
+		fmt.Fprintf(&sb, "%*d%c ", digits, i+1, sep)
+		if width == 2 {
+			key := formatTTY(elems[2*i], nested)
+			sb.WriteString(strings.TrimSuffix(key, "\n"))
+			sb.WriteString(" => ")
+		}
+
+		sb.WriteString(formatTTY(elems[width*i+width-1], nested))
+	}
+
+	return sb.String()
+}
+
+// formatRaw renders v without type hints or quoting, one element per line.
+func formatRaw(v resp.Value) string {
+	switch v.Typ {
+	case resp.IntegerType:
+		return strconv.Itoa(v.Int)
+	case resp.DoubleType:
+		return resp.FormatDouble(v.Double)
+	case resp.BooleanType:
+		if v.Bool {
+			return "(true)"
+		}
+
+		return "(false)"
+	case resp.NullType, resp.NullArrayType:
+		return ""
+	case resp.ArrayType, resp.PushType, resp.SetType, resp.MapType, resp.AttributeType:
+		lines := make([]string, len(v.Array))
+		for i, elem := range v.Array {
+			lines[i] = formatRaw(elem)
+		}
+
+		return strings.Join(lines, "\n")
+	default:
+		return v.String()
+	}
+}
+
+// quote returns b in double quotes, escaping the bytes that aren't printable
+// like redis-cli does.
+func quote(b []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range b {
+		switch c {
+		case '\\', '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		default:
+			if c >= ' ' && c <= '~' {
+				sb.WriteByte(c)
+			} else {
+				fmt.Fprintf(&sb, `\x%02x`, c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+
+	return sb.String()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		v        resp.Value
+		tty, raw string
+	}{
+		{"simple string", resp.OK(), "OK\n", "OK\n"},
+		{"error", resp.Err("ERR unknown command"), "(error) ERR unknown command\n", "ERR unknown command\n"},
+		{"integer", resp.Int(-3), "(integer) -3\n", "-3\n"},
+		{"double", resp.Double(1.5), "(double) 1.5\n", "1.5\n"},
+		{"boolean", resp.Bool(false), "(false)\n", "(false)\n"},
+		{"bulk", resp.Bulk("hello world"), "\"hello world\"\n", "hello world\n"},
+		{"null", resp.Null(), "(nil)\n", "\n"},
+		{"null array", resp.NullArray(), "(nil)\n", "\n"},
+		{"empty array", resp.Array(), "(empty array)\n", "\n"},
+		{"empty map", resp.Map(), "(empty hash)\n", "\n"},
+		{
+			"array",
+			resp.Array(resp.Bulk("a"), resp.Int(1), resp.Null()),
+			"1) \"a\"\n2) (integer) 1\n3) (nil)\n",
+			"a\n1\n\n",
+		},
+		{
+			"nested array",
+			resp.Array(resp.Bulk("1-0"), resp.Array(resp.Bulk("f"), resp.Bulk("v"))),
+			"1) \"1-0\"\n2) 1) \"f\"\n   2) \"v\"\n",
+			"1-0\nf\nv\n",
+		},
+		{
+			"indent follows the widest index",
+			resp.Array(resp.Int(1), resp.Int(2), resp.Int(3), resp.Int(4), resp.Int(5), resp.Int(6), resp.Int(7), resp.Int(8), resp.Int(9), resp.Array(resp.Int(10), resp.Int(11))),
+			" 1) (integer) 1\n 2) (integer) 2\n 3) (integer) 3\n 4) (integer) 4\n 5) (integer) 5\n 6) (integer) 6\n 7) (integer) 7\n 8) (integer) 8\n 9) (integer) 9\n10) 1) (integer) 10\n    2) (integer) 11\n",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+		},
+		{
+			"set",
+			resp.Set(resp.Bulk("a"), resp.Bulk("b")),
+			"1~ \"a\"\n2~ \"b\"\n",
+			"a\nb\n",
+		},
+		{
+			"map",
+			resp.Map(resp.Bulk("name"), resp.Bulk("bank"), resp.Bulk("flags"), resp.Array(resp.Bulk("no-writes"))),
+			"1# \"name\" => \"bank\"\n2# \"flags\" => 1) \"no-writes\"\n",
+			"name\nbank\nflags\nno-writes\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := format(tt.v, false); got != tt.tty {
+				t.Errorf("got %q, want %q", got, tt.tty)
+			}
+
+			if got := format(tt.v, true); got != tt.raw {
+				t.Errorf("raw got %q, want %q", got, tt.raw)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", `""`},
+		{"hello", `"hello"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\`, `"C:\\"`},
+		{"a\r\nb\tc", `"a\r\nb\tc"`},
+		{"\a\b", `"\a\b"`},
+		{"\x00\x7f\xff", `"\x00\x7f\xff"`},
+		{"é", `"\xc3\xa9"`},
+	}
+
+	for _, tt := range tests {
+		if got := quote([]byte(tt.in)); got != tt.want {
+			t.Errorf("quote(%q) is %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// historyMaxLen is the number of lines kept in the history file.
+const historyMaxLen = 100
+
+var errInterrupted = errors.New("interrupted")
+
+// lineEditor reads lines from the terminal with basic editing: moving the
+// cursor, deleting, and going through the history with the arrow keys.
+type lineEditor struct {
+	in       *bufio.Reader
+	history  []string
+	histfile string
+}
+
+func newLineEditor() *lineEditor {
+	e := &lineEditor{in: bufio.NewReader(os.Stdin), histfile: historyFile()}
+	if data, err := os.ReadFile(e.histfile); err == nil {
+		e.history = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	return e
+}
+
+// historyFile is REDISCLI_HISTFILE, ~/.rediscli_history by default.
+func historyFile() string {
+	if path := os.Getenv("REDISCLI_HISTFILE"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".rediscli_history")
+}
+
+// add appends line to the history and saves it.
+func (e *lineEditor) add(line string) {
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+
+	e.history = append(e.history, line)
+	if len(e.history) > historyMaxLen {
+		e.history = e.history[len(e.history)-historyMaxLen:]
+	}
+
+	if e.histfile != "" {
+		os.WriteFile(e.histfile, []byte(strings.Join(e.history, "\n")+"\n"), 0o600)
+	}
+}
+
+// readLine prompts for a line. It returns io.EOF on Ctrl-D and
+// errInterrupted on Ctrl-C.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		fmt.Print(prompt)
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	defer restore()
+
+	line, err := e.edit(prompt)
+	fmt.Print("\r\n")
+	return line, err
+}
+
+func (e *lineEditor) edit(prompt string) (string, error) {
+	var (
+		buf []rune
+		pos int
+		// index is the history entry being shown, len(e.history) for the
+		// line being typed, which is kept in current.
+		index   = len(e.history)
+		current []rune
+	)
+
+	refresh := func() {
+		fmt.Printf("\r%s%s\x1b[0K\r", prompt, string(buf))
+		if col := len([]rune(prompt)) + pos; col > 0 {
+			fmt.Printf("\x1b[%dC", col)
+		}
+	}
+
+	showHistory := func(i int) {
+		if i < 0 || i > len(e.history) || i == index {
+			return
+		}
+
+		if index == len(e.history) {
+			current = buf
+		}
+
+		index = i
+		if index == len(e.history) {
+			buf = current
+		} else {
+			buf = []rune(e.history[index])
+		}
+
+		pos = len(buf)
+		refresh()
+	}
+
+	refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			return string(buf), nil
+		case 3: // Ctrl-C
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				return "", io.EOF
+			}
+
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace, Ctrl-H
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			pos = max(pos-1, 0)
+		case 6: // Ctrl-F
+			pos = min(pos+1, len(buf))
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = buf[pos:]
+			pos = 0
+		case 12: // Ctrl-L
+			fmt.Print("\x1b[H\x1b[2J")
+		case 16: // Ctrl-P
+			showHistory(index - 1)
+			continue
+		case 14: // Ctrl-N
+			showHistory(index + 1)
+			continue
+		case 27: // Escape sequences of the arrow, home, end and delete keys
+			switch e.escape() {
+			case "[A", "OA":
+				showHistory(index - 1)
+				continue
+			case "[B", "OB":
+				showHistory(index + 1)
+				continue
+			case "[C", "OC":
+				pos = min(pos+1, len(buf))
+			case "[D", "OD":
+				pos = max(pos-1, 0)
+			case "[H", "OH", "[1~", "[7~":
+				pos = 0
+			case "[F", "OF", "[4~", "[8~":
+				pos = len(buf)
+			case "[3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+
+		refresh()
+	}
+}
+
+// escape reads the rest of an escape sequence, like "[A" for the up arrow.
+func (e *lineEditor) escape() string {
+	var seq []byte
+	for len(seq) < 4 {
+		b, err := e.in.ReadByte()
+		if err != nil {
+			break
+		}
+
+		seq = append(seq, b)
+		if len(seq) > 1 && (b >= 'A' && b <= 'Z' || b == '~') {
+			break
+		}
+	}
+
+	return string(seq)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// discardStdout hides the terminal output of the line editor until the test
+// ends.
+func discardStdout(t *testing.T) {
+	t.Helper()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+}
+
+func TestEdit(t *testing.T) {
+	const (
+		up    = "\x1b[A"
+		down  = "\x1b[B"
+		right = "\x1b[C"
+		left  = "\x1b[D"
+		home  = "\x1b[H"
+		end   = "\x1b[F"
+		del   = "\x1b[3~"
+	)
+
+	tests := []struct {
+		name    string
+		keys    string
+		history []string
+		want    string
+		err     error
+	}{
+		{name: "type", keys: "GET key\r", want: "GET key"},
+		{name: "newline", keys: "PING\n", want: "PING"},
+		{name: "backspace", keys: "GETT\x7f key\r", want: "GET key"},
+		{name: "insert", keys: "GT" + left + "E" + end + " key\r", want: "GET key"},
+		{name: "home", keys: "ET key" + home + "G\r", want: "GET key"},
+		{name: "ctrl-a and ctrl-e", keys: "ET\x01G\x05 key\r", want: "GET key"},
+		{name: "ctrl-b and ctrl-f", keys: "GT\x02E\x06 key\r", want: "GET key"},
+		{name: "delete", keys: "GXET" + left + left + left + del + "\r", want: "GET"},
+		{name: "ctrl-d deletes", keys: "GXET\x01\x06\x04\r", want: "GET"},
+		{name: "ctrl-k", keys: "GET key" + left + left + left + left + "\x0b\r", want: "GET"},
+		{name: "ctrl-u", keys: "SET GET" + left + left + left + "\x15\r", want: "GET"},
+		{name: "cursor stays in the line", keys: left + "G" + right + right + "ET\r", want: "GET"},
+		{name: "control characters are ignored", keys: "G\x07ET\r", want: "GET"},
+		{name: "unicode", keys: "GET clé" + left + "\x7f\r", want: "GET cé"},
+		{name: "previous", keys: up + up + "\r", history: []string{"PING", "GET a"}, want: "PING"},
+		{name: "past the oldest", keys: up + up + up + "\r", history: []string{"PING", "GET a"}, want: "PING"},
+		{name: "edit history", keys: up + " b\r", history: []string{"GET a"}, want: "GET a b"},
+		{name: "back to the typed line", keys: "SET" + up + down + " a\r", history: []string{"GET a"}, want: "SET a"},
+		{name: "ctrl-p and ctrl-n", keys: "\x10\x10\x0e\r", history: []string{"PING", "GET a"}, want: "GET a"},
+		{name: "ctrl-c", keys: "GET\x03", err: errInterrupted},
+		{name: "ctrl-d", keys: "\x04", err: io.EOF},
+		{name: "end of input", keys: "GET", err: io.EOF},
+	}
+
+	discardStdout(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &lineEditor{in: bufio.NewReader(strings.NewReader(tt.keys)), history: tt.history}
+			got, err := e.edit("> ")
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Fatalf("got %q, %v, want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	histfile := filepath.Join(t.TempDir(), "history")
+	e := &lineEditor{histfile: histfile}
+	for _, line := range []string{"PING", "PING", "GET a", "PING"} {
+		e.add(line)
+	}
+
+	want := "PING\nGET a\nPING\n"
+	if data, err := os.ReadFile(histfile); err != nil || string(data) != want {
+		t.Fatalf("history file holds %q, %v, want %q", data, err, want)
+	}
+
+	for i := range historyMaxLen {
+		e.add(strconv.Itoa(i))
+	}
+
+	if len(e.history) != historyMaxLen || e.history[0] != "0" {
+		t.Fatalf("kept %d lines starting with %q, want %d starting with \"0\"", len(e.history), e.history[0], historyMaxLen)
+	}
+
+	t.Setenv("REDISCLI_HISTFILE", histfile)
+	if got := newLineEditor().history; len(got) != historyMaxLen || got[historyMaxLen-1] != strconv.Itoa(historyMaxLen-1) {
+		t.Fatalf("reloaded %d lines, want %d", len(got), historyMaxLen)
+	}
+}
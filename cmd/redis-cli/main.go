@@ -0,0 +1,167 @@
+// Command redis-cli is a command-line client compatible with the flags and
+// output of redis-cli. It runs the command given as arguments, the commands
+// read from stdin when it is piped, or an interactive prompt.
+//
+//	go run ./cmd/redis-cli -p 6379 SET greeting "hello world"
+//	echo "GET greeting" | go run ./cmd/redis-cli
+//	go run ./cmd/redis-cli --latency
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+type config struct {
+	host     string
+	port     int
+	user     string
+	password string
+	db       int
+	resp3    bool
+	raw      bool
+	noRaw    bool
+	scan     bool
+	pattern  string
+	bigkeys  bool
+	latency  bool
+}
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.host, "h", "127.0.0.1", "server hostname")
+	flag.IntVar(&cfg.port, "p", 6379, "server port")
+	flag.StringVar(&cfg.password, "a", "", "password to use when connecting to the server")
+	flag.StringVar(&cfg.user, "user", "", "username to send to AUTH, with -a")
+	flag.IntVar(&cfg.db, "n", 0, "database number, only 0 as the server has a single database")
+	flag.BoolVar(&cfg.resp3, "3", false, "start the session in RESP3 protocol mode")
+	flag.BoolVar(&cfg.raw, "raw", false, "use raw formatting for replies, the default when stdout is not a tty")
+	flag.BoolVar(&cfg.noRaw, "no-raw", false, "force formatted output even when stdout is not a tty")
+	flag.BoolVar(&cfg.scan, "scan", false, "list all keys using the SCAN command")
+	flag.StringVar(&cfg.pattern, "pattern", "*", "keys pattern when using --scan or --bigkeys")
+	flag.BoolVar(&cfg.bigkeys, "bigkeys", false, "sample keys looking for keys with many elements")
+	flag.BoolVar(&cfg.latency, "latency", false, "enter a special mode continuously sampling latency")
+	flag.Parse()
+
+	// The server doesn't implement SELECT, so no other database can be used.
+	if cfg.db != 0 {
+		fmt.Fprintln(os.Stderr, "redis-cli: -n only accepts 0, the server has a single database")
+		os.Exit(1)
+	}
+
+	if cfg.password == "" {
+		cfg.password = os.Getenv("REDISCLI_AUTH")
+	}
+
+	if !cfg.noRaw && !isTerminal(os.Stdout) {
+		cfg.raw = true
+	}
+
+	var err error
+	switch {
+	case cfg.latency:
+		err = runLatency(cfg)
+	case cfg.scan:
+		err = runScan(cfg)
+	case cfg.bigkeys:
+		err = runBigKeys(cfg)
+	case flag.NArg() > 0:
+		err = runCommand(cfg, flag.Args())
+	case !isTerminal(os.Stdin):
+		err = runBatch(cfg, os.Stdin)
+	default:
+		err = runREPL(cfg)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func (cfg config) addr() string {
+	return net.JoinHostPort(cfg.host, strconv.Itoa(cfg.port))
+}
+
+// connect opens a connection. Push messages are printed as they arrive, which
+// is how RESP3 delivers Pub/Sub messages.
+func connect(cfg config) (*client.Conn, error) {
+	opts := client.Options{
+		Addr:     cfg.addr(),
+		Username: cfg.user,
+		Password: cfg.password,
+		OnPush: func(v resp.Value) {
+			fmt.Print(format(v, cfg.raw))
+		},
+	}
+
+	if cfg.resp3 {
+		opts.Protocol = 3
+	}
+
+	conn, err := client.Dial(context.Background(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to Redis at %s: %v", cfg.addr(), err)
+	}
+
+	return conn, nil
+}
+
+// runCommand runs the command given on the command line.
+func runCommand(cfg config, args []string) error {
+	conn, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return execute(conn, cfg, args)
+}
+
+// execute runs a command and prints its reply. After a subscription, it keeps
+// printing the messages until the connection is closed.
+func execute(conn *client.Conn, cfg config, args []string) error {
+	ctx := context.Background()
+
+	switch strings.ToUpper(args[0]) {
+	case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE":
+		if !cfg.raw {
+			fmt.Println("Reading messages... (press Ctrl-C to quit)")
+		}
+
+		if err := conn.Send(resp.BulkArray(args...)); err != nil {
+			return err
+		}
+
+		if err := conn.Flush(ctx); err != nil {
+			return err
+		}
+
+		// With RESP3 the messages are pushes, printed by the OnPush
+		// callback while Receive waits.
+		for {
+			v, err := conn.Receive(ctx)
+			if err != nil {
+				return err
+			}
+
+			fmt.Print(format(v, cfg.raw))
+		}
+	}
+
+	v, err := conn.Do(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(format(v, cfg.raw))
+	return nil
+}
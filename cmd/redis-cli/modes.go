@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/internal/glob"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// runScan prints the keys matching --pattern, one per line.
+func runScan(cfg config) error {
+	conn, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return scanKeys(conn, cfg.pattern, func(key string) {
+		fmt.Println(key)
+	})
+}
+
+// scanKeys calls fn with every key matching pattern. Servers that don't
+// implement SCAN are asked for KEYS instead, and the pattern is applied again
+// since not all of them filter the keys.
+func scanKeys(conn *client.Conn, pattern string, fn func(key string)) error {
+	ctx := context.Background()
+
+	cursor := "0"
+	for {
+		v, err := conn.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", "100")
+		if err != nil {
+			return err
+		}
+
+		if v.Typ == resp.ErrorType {
+			if strings.HasPrefix(v.String(), "ERR unknown command") {
+				return keys(conn, pattern, fn)
+			}
+
+			return client.Error(v.String())
+		}
+
+		if v.Typ != resp.ArrayType || len(v.Array) != 2 {
+			return fmt.Errorf("unexpected SCAN reply")
+		}
+
+		for _, key := range v.Array[1].Array {
+			fn(key.String())
+		}
+
+		cursor = v.Array[0].String()
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+func keys(conn *client.Conn, pattern string, fn func(key string)) error {
+	keys, err := client.Strings(conn.Do(context.Background(), "KEYS", pattern))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if glob.Match(pattern, key) {
+			fn(key)
+		}
+	}
+
+	return nil
+}
+
+// bigKeyTypes lists the types measured by --bigkeys, with the command giving
+// the size of a key and its unit.
+var bigKeyTypes = []struct {
+	name    string
+	command string
+	unit    string
+}{
+	{"string", "STRLEN", "bytes"},
+	{"list", "LLEN", "items"},
+	{"set", "SCARD", "members"},
+	{"hash", "HLEN", "fields"},
+	{"zset", "ZCARD", "members"},
+	{"stream", "XLEN", "entries"},
+}
+
+type bigKeyStats struct {
+	count   int
+	total   int
+	biggest string
+	size    int
+}
+
+// runBigKeys reports the biggest key of each type along with the average
+// sizes.
+func runBigKeys(cfg config) error {
+	conn, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	fmt.Println()
+	fmt.Println("# Scanning the entire keyspace to find biggest keys as well as")
+	fmt.Println("# average sizes per key type.")
+	fmt.Println()
+
+	var keys []string
+	if err := scanKeys(conn, cfg.pattern, func(key string) { keys = append(keys, key) }); err != nil {
+		return err
+	}
+
+	stats := make(map[string]*bigKeyStats)
+	for _, t := range bigKeyTypes {
+		stats[t.name] = &bigKeyStats{}
+	}
+
+	keyLen := 0
+	for i, key := range keys {
+		keyLen += len(key)
+
+		typ, err := client.String(conn.Do(context.Background(), "TYPE", key))
+		if err != nil {
+			return err
+		}
+
+		for _, t := range bigKeyTypes {
+			if t.name != typ {
+				continue
+			}
+
+			size, err := keySize(conn, t.command, key)
+			if err != nil {
+				return err
+			}
+
+			s := stats[t.name]
+			s.count++
+			s.total += size
+			if s.biggest == "" || size > s.size {
+				s.biggest, s.size = key, size
+				fmt.Printf("[%05.2f%%] Biggest %-6s found so far '%s' with %d %s\n",
+					float64(i)*100/float64(len(keys)), t.name, quote([]byte(key)), size, t.unit)
+			}
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("-------- summary -------")
+	fmt.Println()
+	fmt.Printf("Sampled %d keys in the keyspace!\n", len(keys))
+	fmt.Printf("Total key length in bytes is %d (avg len %.2f)\n", keyLen, average(keyLen, len(keys)))
+	fmt.Println()
+
+	for _, t := range bigKeyTypes {
+		if s := stats[t.name]; s.count > 0 {
+			fmt.Printf("Biggest %6s found '%s' has %d %s\n", t.name, quote([]byte(s.biggest)), s.size, t.unit)
+		}
+	}
+	fmt.Println()
+
+	for _, t := range bigKeyTypes {
+		s := stats[t.name]
+		fmt.Printf("%d %ss with %d %s (%05.2f%% of keys, avg size %.2f)\n",
+			s.count, t.name, s.total, t.unit, average(s.count*100, len(keys)), average(s.total, s.count))
+	}
+
+	return nil
+}
+
+// keySize runs command to get the size of key. Servers without STRLEN have
+// the value read with GET instead.
+func keySize(conn *client.Conn, command, key string) (int, error) {
+	v, err := conn.Do(context.Background(), command, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if command == "STRLEN" && v.Typ == resp.ErrorType && strings.HasPrefix(v.String(), "ERR unknown command") {
+		value, err := client.String(conn.Do(context.Background(), "GET", key))
+		return len(value), err
+	}
+
+	return client.Int(v, nil)
+}
+
+func average(total, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+
+	return float64(total) / float64(n)
+}
+
+// runLatency sends PING continuously and reports the round trip times in
+// milliseconds, updating the line in place on a terminal or printing a line
+// every second otherwise.
+func runLatency(cfg config) error {
+	conn, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var (
+		minLatency, maxLatency, total time.Duration
+		samples                       int
+		lastPrint                     = time.Now()
+	)
+
+	for {
+		start := time.Now()
+		if _, err := conn.Do(context.Background(), "PING"); err != nil {
+			return err
+		}
+
+		latency := time.Since(start)
+		if samples == 0 || latency < minLatency {
+			minLatency = latency
+		}
+		maxLatency = max(maxLatency, latency)
+		total += latency
+		samples++
+
+		ms := func(d time.Duration) float64 {
+			return float64(d) / float64(time.Millisecond)
+		}
+
+		if !cfg.raw {
+			fmt.Printf("\x1b[0G\x1b[2Kmin: %.2f, max: %.2f, avg: %.2f (%d samples)",
+				ms(minLatency), ms(maxLatency), ms(total)/float64(samples), samples)
+		} else if time.Since(lastPrint) >= time.Second {
+			fmt.Printf("%.2f %.2f %.2f %d\n", ms(minLatency), ms(maxLatency), ms(total)/float64(samples), samples)
+			lastPrint = time.Now()
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/client"
+	"github.com/codecrafters-io/redis-starter-go/resp"
+)
+
+// runREPL prompts for commands until Ctrl-C, Ctrl-D or QUIT. When the
+// connection is lost, the next command reconnects.
+func runREPL(cfg config) error {
+	editor := newLineEditor()
+
+	conn, err := connect(cfg)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		line, err := editor.readLine(prompt(cfg, conn))
+		if errors.Is(err, io.EOF) || errors.Is(err, errInterrupted) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		args, err := resp.SplitArgs(line)
+		if err != nil {
+			fmt.Println("Invalid argument(s)")
+			continue
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		editor.add(line)
+
+		switch strings.ToLower(args[0]) {
+		case "quit", "exit":
+			return nil
+		case "clear":
+			fmt.Print("\x1b[H\x1b[2J")
+			continue
+		}
+
+		if conn == nil {
+			if conn, err = connect(cfg); err != nil {
+				fmt.Println(err)
+				continue
+			}
+		}
+
+		if err := execute(conn, cfg, args); err != nil {
+			fmt.Println("Error:", err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func prompt(cfg config, conn *client.Conn) string {
+	if conn == nil {
+		return "not connected> "
+	}
+
+	return cfg.addr() + "> "
+}
+
+// runBatch runs the commands read from r, one per line, stopping at the first
+// connection error.
+func runBatch(cfg config, r io.Reader) error {
+	conn, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	in := bufio.NewReader(r)
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		args, err := resp.SplitArgs(strings.TrimRight(line, "\r\n"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid argument(s)")
+			continue
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if err := execute(conn, cfg, args); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// makeRaw isn't supported on this platform, so the prompt falls back to
+// reading whole lines without editing or history navigation.
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.ErrUnsupported
+}
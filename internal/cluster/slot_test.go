@@ -0,0 +1,29 @@
+package cluster
+
+import "testing"
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		// 0x31c3 is the check value of CRC16-CCITT (XMODEM).
+		{"123456789", 0x31c3},
+		{"somekey", 11058},
+		{"foo", 12182},
+		{"hello", 866},
+		{"foo{hello}", 866},
+		{"{hello}bar", 866},
+		{"foo{hello}{bar}", 866},
+		{"foo{{hello}}", int(crc16("{hello")) & (Slots - 1)},
+		// An empty tag hashes the whole key.
+		{"foo{}{hello}", int(crc16("foo{}{hello}")) & (Slots - 1)},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		if got := KeySlot(tt.key); got != tt.want {
+			t.Errorf("KeySlot(%q) is %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package geohash
+
+import "math"
+
+const mercatorMax float64 = 20037726.37
+
+// HashBits is a geohash cell: the interleaved latitude/longitude bits at the
+// given precision step (1..26).
+type HashBits struct {
+	Bits uint64
+	Step uint
+}
+
+type area struct {
+	minLong, maxLong float64
+	minLat, maxLat   float64
+}
+
+// ScoreRange is a half-open range [Min, Max) of 52-bit geo scores.
+type ScoreRange struct {
+	Min int
+	Max int
+}
+
+// EstimateStepsByRadius returns the geohash precision whose cells are large
+// enough for a search of the given radius (in meters) around latitude lat.
+func EstimateStepsByRadius(radius, lat float64) uint {
+	if radius == 0 {
+		return 26
+	}
+
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+
+	// Make sure the range is included in most of the base cases.
+	step -= 2
+
+	// Cells get narrower towards the poles, so use wider ones there.
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+
+	return uint(min(max(step, 1), 26))
+}
+
+// Encode returns the cell containing the given point at the given precision.
+func Encode(long, lat float64, step uint) HashBits {
+	latOffset := cellOffset(lat, MinLatitude, LatitudeRange, step)
+	longOffset := cellOffset(long, MinLongitude, LongitudeRange, step)
+	return HashBits{Bits: uint64(interleave(latOffset, longOffset)), Step: step}
+}
+
+func (h HashBits) decode() area {
+	latOffset := compactInt64ToInt32(int(h.Bits))
+	longOffset := compactInt64ToInt32(int(h.Bits >> 1))
+	scale := float64(uint64(1) << h.Step)
+
+	return area{
+		minLat:  MinLatitude + float64(latOffset)/scale*LatitudeRange,
+		maxLat:  MinLatitude + float64(latOffset+1)/scale*LatitudeRange,
+		minLong: MinLongitude + float64(longOffset)/scale*LongitudeRange,
+		maxLong: MinLongitude + float64(longOffset+1)/scale*LongitudeRange,
+	}
+}
+
+// move shifts the cell dx cells east (negative: west) and dy cells north
+// (negative: south), wrapping around at the edges of the grid.
+func (h HashBits) move(dx, dy int) HashBits {
+	long := h.Bits & 0xaaaaaaaaaaaaaaaa
+	lat := h.Bits & 0x5555555555555555
+	shift := 64 - h.Step*2
+
+	if dx != 0 {
+		zz := uint64(0x5555555555555555) >> shift
+		if dx > 0 {
+			long += zz + 1
+		} else {
+			long = (long | zz) - (zz + 1)
+		}
+
+		long &= uint64(0xaaaaaaaaaaaaaaaa) >> shift
+	}
+
+	if dy != 0 {
+		zz := uint64(0xaaaaaaaaaaaaaaaa) >> shift
+		if dy > 0 {
+			lat += zz + 1
+		} else {
+			lat = (lat | zz) - (zz + 1)
+		}
+
+		lat &= uint64(0x5555555555555555) >> shift
+	}
+
+	return HashBits{Bits: long | lat, Step: h.Step}
+}
+
+// ScoreRange returns the range of 52-bit scores of the points inside the cell.
+func (h HashBits) ScoreRange() ScoreRange {
+	shift := 52 - h.Step*2
+	return ScoreRange{Min: int(h.Bits << shift), Max: int((h.Bits + 1) << shift)}
+}
+
+// ScoreRangesByRadius returns the score ranges of the cells that may contain
+// points within radius meters of (long, lat).
+func ScoreRangesByRadius(long, lat, radius float64) []ScoreRange {
+	return scoreRanges(long, lat, radius, radius, radius)
+}
+
+// ScoreRangesByBox returns the score ranges of the cells that may contain
+// points inside the width x height meters box centered on (long, lat).
+func ScoreRangesByBox(long, lat, width, height float64) []ScoreRange {
+	return scoreRanges(long, lat, math.Sqrt(width*width+height*height)/2, width/2, height/2)
+}
+
+// scoreRanges mirrors Redis's geohashCalculateAreasByShapeWGS84: it picks the
+// cell containing the center at a precision suited to radius, together with
+// its 8 neighbors, and drops the neighbors that lie outside the bounding box.
+func scoreRanges(long, lat, radius, halfWidth, halfHeight float64) []ScoreRange {
+	minLong, minLat, maxLong, maxLat := boundingBox(long, lat, halfWidth, halfHeight)
+
+	step := EstimateStepsByRadius(radius, lat)
+	cell := Encode(long, lat, step)
+
+	north, south := cell.move(0, 1).decode(), cell.move(0, -1).decode()
+	east, west := cell.move(1, 0).decode(), cell.move(-1, 0).decode()
+	if step > 1 && (north.maxLat < maxLat || south.minLat > minLat || east.maxLong < maxLong || west.minLong > minLong) {
+		step--
+		cell = Encode(long, lat, step)
+	}
+
+	excludeSouth, excludeNorth, excludeWest, excludeEast := false, false, false, false
+	if step >= 2 {
+		a := cell.decode()
+		excludeSouth = a.minLat < minLat
+		excludeNorth = a.maxLat > maxLat
+		excludeWest = a.minLong < minLong
+		excludeEast = a.maxLong > maxLong
+	}
+
+	ranges := make([]ScoreRange, 0, 9)
+	for dy := -1; dy <= 1; dy++ {
+		if (dy < 0 && excludeSouth) || (dy > 0 && excludeNorth) {
+			continue
+		}
+
+		for dx := -1; dx <= 1; dx++ {
+			if (dx < 0 && excludeWest) || (dx > 0 && excludeEast) {
+				continue
+			}
+
+			r := cell.move(dx, dy).ScoreRange()
+			if !containsRange(ranges, r) {
+				ranges = append(ranges, r)
+			}
+		}
+	}
+
+	return ranges
+}
+
+func containsRange(ranges []ScoreRange, r ScoreRange) bool {
+	for _, existing := range ranges {
+		if existing == r {
+			return true
+		}
+	}
+
+	return false
+}
+
+func boundingBox(long, lat, halfWidth, halfHeight float64) (minLong, minLat, maxLong, maxLat float64) {
+	latr := lat * math.Pi / 180
+	latDelta := halfHeight / rearth * 180 / math.Pi
+	longDeltaTop := halfWidth / rearth / math.Cos(latr+halfHeight/rearth) * 180 / math.Pi
+	longDeltaBottom := halfWidth / rearth / math.Cos(latr-halfHeight/rearth) * 180 / math.Pi
+
+	longDelta := longDeltaTop
+	if lat < 0 {
+		longDelta = longDeltaBottom
+	}
+
+	return long - longDelta, lat - latDelta, long + longDelta, lat + latDelta
+}
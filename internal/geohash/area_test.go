@@ -0,0 +1,124 @@
+package geohash
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateStepsByRadius(t *testing.T) {
+	tests := []struct {
+		radius, lat float64
+		want        uint
+	}{
+		{radius: 0, lat: 0, want: 26},
+		{radius: 1000, lat: 0, want: 14},
+		{radius: 1000, lat: -70, want: 13},
+		{radius: 1000, lat: 85, want: 12},
+		{radius: 200000, lat: 37, want: 6},
+		{radius: 1e8, lat: 0, want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := EstimateStepsByRadius(tt.radius, tt.lat); got != tt.want {
+			t.Errorf("EstimateStepsByRadius(%v, %v) is %d, want %d", tt.radius, tt.lat, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeUpperBound(t *testing.T) {
+	if got := EncodeGeoScore(MaxLongitude, MaxLatitude); got != 1<<52-1 {
+		t.Fatalf("EncodeGeoScore of the upper bound is %#x, want %#x", got, 1<<52-1)
+	}
+
+	if got := Encode(MaxLongitude, MaxLatitude, 3).Bits; got != 1<<6-1 {
+		t.Fatalf("Encode of the upper bound at step 3 is %#x, want %#x", got, 1<<6-1)
+	}
+}
+
+func TestScoreRangeMatchesScore(t *testing.T) {
+	for _, p := range [][2]float64{{13.361389, 38.115556}, {-122.27652, 37.805186}, {0, 0}, {MaxLongitude, MaxLatitude}} {
+		score := EncodeGeoScore(p[0], p[1])
+		r := Encode(p[0], p[1], 26).ScoreRange()
+		if r.Min != score || r.Max != score+1 {
+			t.Errorf("cell of %v covers [%d, %d), want [%d, %d)", p, r.Min, r.Max, score, score+1)
+		}
+	}
+}
+
+// TestScoreRangesCover samples points around each center and checks that the
+// ones inside the shape have their score within one of the ranges searched.
+func TestScoreRangesCover(t *testing.T) {
+	tests := []struct {
+		name          string
+		long, lat     float64
+		width, height float64
+		// radius searches by radius when set, and by box otherwise.
+		radius float64
+	}{
+		{name: "radius", long: 15, lat: 37, radius: 200000},
+		{name: "small radius", long: 13.361389, lat: 38.115556, radius: 10},
+		{name: "one meter radius", long: 2.35, lat: 48.85, radius: 1},
+		{name: "radius across the antimeridian", long: 179.9, lat: -12, radius: 50000},
+		{name: "radius near the pole", long: -40, lat: 84.5, radius: 30000},
+		{name: "box", long: 15, lat: 37, width: 400000, height: 400000},
+		{name: "wide box", long: -73.98, lat: 40.75, width: 90000, height: 2000},
+		{name: "tall box", long: -73.98, lat: 40.75, width: 2000, height: 90000},
+		{name: "box across the antimeridian", long: -179.95, lat: 60, width: 40000, height: 40000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			byRadius := tt.radius > 0
+			halfWidth, halfHeight := tt.width/2, tt.height/2
+			ranges := ScoreRangesByBox(tt.long, tt.lat, tt.width, tt.height)
+			if byRadius {
+				halfWidth, halfHeight = tt.radius, tt.radius
+				ranges = ScoreRangesByRadius(tt.long, tt.lat, tt.radius)
+			}
+
+			center := DegPos(tt.lat, tt.long)
+			latDelta := halfHeight / rearth * 180 / math.Pi
+			longDelta := halfWidth / rearth * 180 / math.Pi / math.Cos(tt.lat*math.Pi/180)
+
+			inside := 0
+			for fy := -1.2; fy <= 1.2; fy += 0.02 {
+				for fx := -1.2; fx <= 1.2; fx += 0.02 {
+					long, lat := tt.long+fx*longDelta, tt.lat+fy*latDelta
+					if long > MaxLongitude {
+						long -= 360
+					} else if long < MinLongitude {
+						long += 360
+					}
+
+					if byRadius && Hsdist(center, DegPos(lat, long)) > tt.radius {
+						continue
+					}
+
+					if !byRadius && (LatDist(tt.lat, lat) > halfHeight || Hsdist(DegPos(lat, tt.long), DegPos(lat, long)) > halfWidth) {
+						continue
+					}
+
+					inside++
+					score := EncodeGeoScore(long, lat)
+					if !covered(ranges, score) {
+						t.Fatalf("(%v, %v) is inside the shape, but its score %d is outside of %v", long, lat, score, ranges)
+					}
+				}
+			}
+
+			if inside == 0 {
+				t.Fatal("no sampled point was inside the shape")
+			}
+		})
+	}
+}
+
+func covered(ranges []ScoreRange, score int) bool {
+	for _, r := range ranges {
+		if score >= r.Min && score < r.Max {
+			return true
+		}
+	}
+
+	return false
+}
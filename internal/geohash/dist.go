@@ -21,3 +21,8 @@ func Hsdist(p1, p2 pos) float64 {
 	return 2 * rearth * math.Asin(math.Sqrt(haversine(p2.φ-p1.φ)+
 		math.Cos(p1.φ)*math.Cos(p2.φ)*haversine(p2.ψ-p1.ψ)))
 }
+
+// LatDist returns the distance in meters between two latitudes along a meridian.
+func LatDist(lat1, lat2 float64) float64 {
+	return rearth * math.Abs((lat2-lat1)*math.Pi/180)
+}
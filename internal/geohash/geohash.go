@@ -20,11 +20,19 @@ func EncodeGeoScore(long, lat float64) int {
 }
 
 func generateScoreFrom(long, lat float64) int {
-	normalizedLong := int(1 << 26 * (long - MinLongitude) / LongitudeRange)
-	normalizedLat := int(1 << 26 * (lat - MinLatitude) / LatitudeRange)
+	normalizedLong := cellOffset(long, MinLongitude, LongitudeRange, 26)
+	normalizedLat := cellOffset(lat, MinLatitude, LatitudeRange, 26)
 	return interleave(normalizedLat, normalizedLong)
 }
 
+// cellOffset returns the cell holding value on a grid of 2^step cells
+// covering [lo, lo+span]. The upper bound falls in the last cell instead of
+// overflowing into the next bit.
+func cellOffset(value, lo, span float64, step uint) int {
+	offset := int((value - lo) / span * float64(uint64(1)<<step))
+	return min(offset, 1<<step-1)
+}
+
 func interleave(normalizedLat, normalizedLong int) int {
 	x := spreadInt32ToInt64(normalizedLat)
 	y := spreadInt32ToInt64(normalizedLong)
@@ -73,3 +81,23 @@ func compactInt64ToInt32(x int) int {
 
 	return x
 }
+
+const base32Alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// EncodeBase32 returns the standard 11 character geohash of a point. Scores use
+// a latitude range of ±85.05 degrees, so the point is re-encoded with the
+// standard ±90 degrees range first.
+func EncodeBase32(long, lat float64) string {
+	normalizedLong := cellOffset(long, MinLongitude, LongitudeRange, 26)
+	normalizedLat := cellOffset(lat, -90, 180, 26)
+	bits := interleave(normalizedLat, normalizedLong)
+
+	buf := make([]byte, 11)
+	for i := range 10 {
+		buf[i] = base32Alphabet[(bits>>(52-(i+1)*5))&0x1f]
+	}
+
+	// Scores only have 52 bits, the last character is always zero.
+	buf[10] = base32Alphabet[0]
+	return string(buf)
+}
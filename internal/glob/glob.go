@@ -0,0 +1,105 @@
+package glob
+
+// Match reports whether str matches the Redis glob-style pattern, which
+// supports '*', '?', character classes such as [abc], [^a] and [a-z], and
+// backslash escapes.
+func Match(pattern, str string) bool {
+	p, s := 0, 0
+	starP, starS := -1, 0
+
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starS = p, s
+				p++
+				continue
+			case '?':
+				p++
+				s++
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p+1, str[s]); ok {
+					p = next
+					s++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == str[s] {
+					p += 2
+					s++
+					continue
+				}
+
+				if p+1 == len(pattern) && str[s] == '\\' {
+					p++
+					s++
+					continue
+				}
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+
+		// Let the last '*' swallow one more character and retry from there.
+		if starP == -1 {
+			return false
+		}
+
+		starS++
+		p, s = starP+1, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class starting right after '[' at p and
+// returns the index following the closing ']'.
+func matchClass(pattern string, p int, c byte) (int, bool) {
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+
+	matched := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == c {
+				matched = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			start, end := pattern[p], pattern[p+2]
+			if start > end {
+				start, end = end, start
+			}
+
+			if c >= start && c <= end {
+				matched = true
+			}
+
+			p += 2
+		default:
+			if pattern[p] == c {
+				matched = true
+			}
+		}
+
+		p++
+	}
+
+	if p < len(pattern) {
+		p++
+	}
+
+	return p, matched != negate
+}
@@ -1,79 +1,261 @@
 package set
 
 import (
-	"container/heap"
 	"math"
+	"math/rand/v2"
 )
 
-type Set []SetMember
+const (
+	// maxLevel and levelP are the skiplist parameters used by Redis, which
+	// are enough for 2^64 members.
+	maxLevel = 32
+	levelP   = 0.25
+)
+
+// Set keeps its members ordered by score, and by member for equal scores.
+// Like Redis's sorted sets, it pairs a map giving the score of a member with
+// a skiplist giving the order, so that adding, removing and ranking a member
+// take O(log n).
+type Set struct {
+	scores map[string]float64
+	header *node
+	length int
+	level  int
+}
 
 type SetMember struct {
 	Member string
 	Score  float64
 }
 
-func (s Set) Len() int {
-	return len(s)
+type node struct {
+	SetMember
+	levels []level
+}
+
+// level links a node to the next one at that level. span is the number of
+// nodes between them on the first level, which gives the ranks.
+type level struct {
+	forward *node
+	span    int
 }
 
-func (s Set) Less(i, j int) bool {
+func New() *Set {
+	return &Set{
+		scores: make(map[string]float64),
+		header: &node{levels: make([]level, maxLevel)},
+		level:  1,
+	}
+}
+
+func less(a, b SetMember) bool {
 	const tolerance = 1e-9
 
-	if math.Abs(s[i].Score-s[j].Score) <= tolerance {
-		return s[i].Member < s[j].Member
+	if math.Abs(a.Score-b.Score) <= tolerance {
+		return a.Member < b.Member
 	}
 
-	return s[i].Score < s[j].Score
+	return a.Score < b.Score
 }
 
-func (s Set) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+func (s *Set) Len() int {
+	return s.length
 }
 
-func (s *Set) Push(x any) {
-	item, ok := x.(SetMember)
-	if !ok {
-		return
+// Score returns the score of member, and whether it is in the set.
+func (s *Set) Score(member string) (float64, bool) {
+	score, ok := s.scores[member]
+	return score, ok
+}
+
+// Add inserts member with the given score, or moves it if it is already in
+// the set. It reports whether the member was newly added.
+func (s *Set) Add(member string, score float64) bool {
+	old, ok := s.scores[member]
+	if ok {
+		if old == score {
+			return false
+		}
+
+		s.delete(SetMember{Member: member, Score: old})
 	}
 
-	*s = append(*s, item)
+	s.scores[member] = score
+	s.insert(SetMember{Member: member, Score: score})
+	return !ok
 }
 
-func (s *Set) Pop() any {
-	old := *s
-	n := len(old)
-	x := old[n-1]
-	*s = old[0 : n-1]
-	return x
+// Remove deletes member from the set and reports whether it was present.
+func (s *Set) Remove(member string) bool {
+	score, ok := s.scores[member]
+	if !ok {
+		return false
+	}
+
+	delete(s.scores, member)
+	s.delete(SetMember{Member: member, Score: score})
+	return true
 }
 
-func (s *Set) FindByIndex(member string) int {
-	for i, elem := range *s {
-		if elem.Member == member {
-			return i
+// Rank returns the position of member in the set, starting at 0, or -1 if it
+// is not a member.
+func (s *Set) Rank(member string) int {
+	score, ok := s.scores[member]
+	if !ok {
+		return -1
+	}
+
+	elem := SetMember{Member: member, Score: score}
+	rank := 0
+	x := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for next := x.levels[i].forward; next != nil && !less(elem, next.SetMember); next = x.levels[i].forward {
+			rank += x.levels[i].span
+			x = next
+		}
+
+		if x != s.header && x.Member == member {
+			return rank - 1
 		}
 	}
 
 	return -1
 }
 
-func (s *Set) FindByRank(member string) int {
-	idx := -1
-	elems := make([]SetMember, 0)
+// RangeByRank returns the members ranked from start to end, both included.
+func (s *Set) RangeByRank(start, end int) []SetMember {
+	start, end = max(start, 0), min(end, s.length-1)
+	if start > end {
+		return nil
+	}
+
+	ret := make([]SetMember, 0, end-start+1)
+	for x := s.byRank(start + 1); x != nil && len(ret) < cap(ret); x = x.levels[0].forward {
+		ret = append(ret, x.SetMember)
+	}
+
+	return ret
+}
+
+// RangeByScore returns the members whose score lies in [min, max).
+func (s *Set) RangeByScore(min, max float64) []SetMember {
+	x := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for next := x.levels[i].forward; next != nil && next.Score < min; next = x.levels[i].forward {
+			x = next
+		}
+	}
+
+	var ret []SetMember
+	for x = x.levels[0].forward; x != nil && x.Score < max; x = x.levels[0].forward {
+		ret = append(ret, x.SetMember)
+	}
+
+	return ret
+}
+
+// byRank returns the node at the given rank, starting at 1.
+func (s *Set) byRank(rank int) *node {
+	traversed := 0
+	x := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+
+	return nil
+}
+
+func randomLevel() int {
+	n := 1
+	for n < maxLevel && rand.Float64() < levelP {
+		n++
+	}
+
+	return n
+}
+
+func (s *Set) insert(elem SetMember) {
+	var update [maxLevel]*node
+	var rank [maxLevel]int
 
-	for i := 0; len(*s) > 0; i++ {
-		elem := heap.Pop(s).(SetMember)
-		elems = append(elems, elem)
+	x := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		if i < s.level-1 {
+			rank[i] = rank[i+1]
+		}
+
+		for next := x.levels[i].forward; next != nil && less(next.SetMember, elem); next = x.levels[i].forward {
+			rank[i] += x.levels[i].span
+			x = next
+		}
+
+		update[i] = x
+	}
+
+	n := randomLevel()
+	if n > s.level {
+		for i := s.level; i < n; i++ {
+			rank[i] = 0
+			update[i] = s.header
+			update[i].levels[i].span = s.length
+		}
+
+		s.level = n
+	}
+
+	x = &node{SetMember: elem, levels: make([]level, n)}
+	for i := 0; i < n; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+
+	// The levels above the new node now skip one more node.
+	for i := n; i < s.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	s.length++
+}
+
+func (s *Set) delete(elem SetMember) {
+	var update [maxLevel]*node
+
+	x := s.header
+	for i := s.level - 1; i >= 0; i-- {
+		for next := x.levels[i].forward; next != nil && less(next.SetMember, elem); next = x.levels[i].forward {
+			x = next
+		}
+
+		update[i] = x
+	}
+
+	x = x.levels[0].forward
+	if x == nil || x.Member != elem.Member {
+		return
+	}
 
-		if elem.Member == member {
-			idx = i
-			break
+	for i := 0; i < s.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
 		}
 	}
 
-	for _, elem := range elems {
-		heap.Push(s, elem)
+	for s.level > 1 && s.header.levels[s.level-1].forward == nil {
+		s.level--
 	}
 
-	return idx
+	s.length--
 }
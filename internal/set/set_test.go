@@ -0,0 +1,121 @@
+package set
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func members(ms []SetMember) []string {
+	ret := make([]string, len(ms))
+	for i, m := range ms {
+		ret[i] = m.Member
+	}
+
+	return ret
+}
+
+func TestRankAndRange(t *testing.T) {
+	s := New()
+	s.Add("c", 2)
+	s.Add("a", 2)
+	s.Add("z", 1)
+	s.Add("b", 3)
+	s.Add("moved", 0)
+	s.Add("moved", 2.5)
+	s.Add("removed", 1.5)
+	s.Remove("removed")
+
+	// Equal scores are ordered by member.
+	order := []string{"z", "a", "c", "moved", "b"}
+	if s.Len() != len(order) {
+		t.Fatalf("Len is %d, want %d", s.Len(), len(order))
+	}
+
+	for want, member := range order {
+		if got := s.Rank(member); got != want {
+			t.Errorf("Rank(%q) is %d, want %d", member, got, want)
+		}
+	}
+
+	if got := s.Rank("removed"); got != -1 {
+		t.Errorf("Rank of a removed member is %d, want -1", got)
+	}
+
+	tests := []struct {
+		name string
+		got  []SetMember
+		want []string
+	}{
+		{"all ranks", s.RangeByRank(0, 4), order},
+		{"middle ranks", s.RangeByRank(1, 3), []string{"a", "c", "moved"}},
+		{"clamped ranks", s.RangeByRank(-3, 10), order},
+		{"single rank", s.RangeByRank(4, 4), []string{"b"}},
+		{"empty ranks", s.RangeByRank(3, 2), nil},
+		{"ranks past the end", s.RangeByRank(5, 8), nil},
+		{"scores", s.RangeByScore(2, 3), []string{"a", "c", "moved"}},
+		{"scores exclude max", s.RangeByScore(1, 2), []string{"z"}},
+		{"all scores", s.RangeByScore(0, 10), order},
+		{"no scores", s.RangeByScore(3.5, 10), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := members(tt.got); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatchesSortedSlice applies random updates to a set and checks its ranks
+// against a sorted slice, which exercises the span bookkeeping of the skiplist.
+func TestMatchesSortedSlice(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	s := New()
+	scores := map[string]float64{}
+
+	for range 2000 {
+		member := "m" + strconv.Itoa(r.IntN(200))
+		if r.IntN(4) == 0 {
+			s.Remove(member)
+			delete(scores, member)
+		} else {
+			score := float64(r.IntN(50))
+			s.Add(member, score)
+			scores[member] = score
+		}
+	}
+
+	want := make([]SetMember, 0, len(scores))
+	for member, score := range scores {
+		want = append(want, SetMember{Member: member, Score: score})
+	}
+
+	slices.SortFunc(want, func(a, b SetMember) int {
+		if less(a, b) {
+			return -1
+		}
+
+		return 1
+	})
+
+	if s.Len() != len(want) {
+		t.Fatalf("Len is %d, want %d", s.Len(), len(want))
+	}
+
+	if got := s.RangeByRank(0, s.Len()-1); !slices.Equal(got, want) {
+		t.Fatalf("RangeByRank(0, %d) is %v, want %v", s.Len()-1, got, want)
+	}
+
+	for i, m := range want {
+		if rank := s.Rank(m.Member); rank != i {
+			t.Fatalf("Rank(%q) is %d, want %d", m.Member, rank, i)
+		}
+
+		if got := s.RangeByRank(i, i); len(got) != 1 || got[0] != m {
+			t.Fatalf("RangeByRank(%d, %d) is %v, want %v", i, i, got, m)
+		}
+	}
+}
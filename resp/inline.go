@@ -0,0 +1,114 @@
+package resp
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// readInline reads a command typed as a single line of space-separated
+// arguments, as sent by telnet or nc.
+func (r *Resp) readInline() (Value, error) {
+	line, err := r.readRawLine("too big inline request")
+	if err != nil {
+		return Value{}, err
+	}
+
+	args, err := SplitArgs(string(bytes.TrimSuffix(line[:len(line)-1], []byte{'\r'})))
+	if err != nil {
+		return Value{}, err
+	}
+
+	return BulkArray(args...), nil
+}
+
+// SplitArgs splits line into arguments following the quoting rules of Redis's
+// sdssplitargs. A quote starts a quoted section anywhere in an argument, so
+// foo"bar baz" is the single argument "foobar baz". Double quoted sections may
+// contain escapes like "\n" or "\x00", single quoted ones only "\'". A closing
+// quote must be followed by a space or the end of the line.
+func SplitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+
+		if i == len(line) {
+			return args, nil
+		}
+
+		var (
+			arg   []byte
+			quote byte
+			done  bool
+		)
+
+		for !done {
+			if i == len(line) {
+				if quote != 0 {
+					return nil, &ProtocolError{Msg: "unbalanced quotes in request"}
+				}
+
+				break
+			}
+
+			c := line[i]
+			switch {
+			case quote == '"' && c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+				b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+				arg = append(arg, byte(b))
+				i += 3
+			case quote == '"' && c == '\\' && i+1 < len(line):
+				i++
+				arg = append(arg, unescape(line[i]))
+			case quote == '\'' && c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+				i++
+				arg = append(arg, '\'')
+			case quote != 0 && c == quote:
+				if i+1 < len(line) && !isSpace(line[i+1]) {
+					return nil, &ProtocolError{Msg: "unbalanced quotes in request"}
+				}
+
+				done = true
+			case quote != 0:
+				arg = append(arg, c)
+			case c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == 0:
+				done = true
+			case c == '"' || c == '\'':
+				quote = c
+			default:
+				arg = append(arg, c)
+			}
+
+			i++
+		}
+
+		args = append(args, string(arg))
+	}
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
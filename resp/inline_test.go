@@ -0,0 +1,54 @@
+package resp
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{line: "", want: []string{}},
+		{line: "   ", want: []string{}},
+		{line: "SET key value", want: []string{"SET", "key", "value"}},
+		{line: "  spaced \t out  ", want: []string{"spaced", "out"}},
+		{line: `"hello world"`, want: []string{"hello world"}},
+		{line: `""`, want: []string{""}},
+		{line: `"a\nb\tc\\d\"e"`, want: []string{"a\nb\tc\\d\"e"}},
+		{line: `"\x41\x00\x7a"`, want: []string{"A\x00z"}},
+		{line: `"\x4g"`, want: []string{"x4g"}},
+		{line: `'it\'s'`, want: []string{"it's"}},
+		{line: `'no\nescape'`, want: []string{`no\nescape`}},
+		{line: `'say "hi"'`, want: []string{`say "hi"`}},
+		{line: `foo"bar baz"`, want: []string{"foobar baz"}},
+		{line: `key'a b' next`, want: []string{"keya b", "next"}},
+		{line: `"unterminated`, err: true},
+		{line: `'unterminated`, err: true},
+		{line: `"closed"x`, err: true},
+		{line: `foo"bar"baz`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := SplitArgs(tt.line)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %q, want an error", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
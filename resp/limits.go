@@ -0,0 +1,19 @@
+package resp
+
+// Limits protects a server from requests that would make it allocate too much
+// memory. A zero field means no limit.
+type Limits struct {
+	// MaxBulkLen is the largest bulk string accepted, like proto-max-bulk-len.
+	MaxBulkLen int
+	// MaxMultibulkLen is the largest number of elements in an array.
+	MaxMultibulkLen int
+	// MaxLineLen is the longest inline command or header line.
+	MaxLineLen int
+	// QueryBufferLimit is the largest request accepted, like
+	// client-query-buffer-limit.
+	QueryBufferLimit int
+}
+
+// maxPrealloc caps the memory reserved from a declared length before the data
+// actually arrives.
+const maxPrealloc = 64 * 1024
@@ -0,0 +1,157 @@
+package resp
+
+import (
+	"math"
+	"strconv"
+)
+
+// Marshal encodes v using RESP2.
+func (v Value) Marshal() []byte {
+	return v.AppendTo(nil)
+}
+
+// MarshalProtocol encodes v for a connection speaking the given protocol
+// version.
+func (v Value) MarshalProtocol(protocol int) []byte {
+	return v.AppendProtocol(nil, protocol)
+}
+
+// AppendTo appends the RESP2 encoding of v to buf. Nested values are written
+// in place, so encoding into a reused buffer doesn't allocate.
+func (v Value) AppendTo(buf []byte) []byte {
+	return v.AppendProtocol(buf, 2)
+}
+
+// AppendProtocol appends the encoding of v for the given protocol version to
+// buf. With RESP2, RESP3 types are sent as their closest RESP2 type: maps,
+// sets and pushes as arrays, doubles, big numbers and verbatim strings as bulk
+// strings, and booleans as integers. Attributes are dropped. The zero Value
+// appends nothing.
+func (v Value) AppendProtocol(buf []byte, protocol int) []byte {
+	resp3 := protocol >= 3
+	switch v.Typ {
+	case SimpleStringType:
+		return appendLine(buf, STRING, v.Bytes)
+	case ErrorType:
+		return appendLine(buf, ERROR, v.Bytes)
+	case IntegerType:
+		buf = append(buf, INTEGER)
+		buf = strconv.AppendInt(buf, int64(v.Int), 10)
+		return append(buf, '\r', '\n')
+	case BulkType:
+		return appendBlob(buf, BULK, v.Bytes)
+	case ArrayType:
+		return appendAggregate(buf, ARRAY, len(v.Array), v.Array, protocol)
+	case NullType:
+		if resp3 {
+			return append(buf, "_\r\n"...)
+		}
+
+		return append(buf, "$-1\r\n"...)
+	case NullArrayType:
+		if resp3 {
+			return append(buf, "_\r\n"...)
+		}
+
+		return append(buf, "*-1\r\n"...)
+	case DoubleType:
+		if !resp3 {
+			var digits [32]byte
+			return appendBlob(buf, BULK, AppendDouble(digits[:0], v.Double))
+		}
+
+		buf = append(buf, DOUBLE)
+		buf = AppendDouble(buf, v.Double)
+		return append(buf, '\r', '\n')
+	case BooleanType:
+		if !resp3 {
+			if v.Bool {
+				return append(buf, ":1\r\n"...)
+			}
+
+			return append(buf, ":0\r\n"...)
+		}
+
+		if v.Bool {
+			return append(buf, "#t\r\n"...)
+		}
+
+		return append(buf, "#f\r\n"...)
+	case VerbatimType:
+		if !resp3 {
+			return appendBlob(buf, BULK, v.Bytes[min(4, len(v.Bytes)):])
+		}
+
+		return appendBlob(buf, VERBATIM, v.Bytes)
+	case BigNumberType:
+		if !resp3 {
+			return appendBlob(buf, BULK, v.Bytes)
+		}
+
+		return appendLine(buf, BIG_NUMBER, v.Bytes)
+	case MapType:
+		return appendAggregate(buf, MAP, len(v.Array)/2, v.Array, protocol)
+	case SetType:
+		return appendAggregate(buf, SET, len(v.Array), v.Array, protocol)
+	case PushType:
+		return appendAggregate(buf, PUSH, len(v.Array), v.Array, protocol)
+	case AttributeType:
+		if !resp3 {
+			return buf
+		}
+
+		return appendAggregate(buf, ATTRIBUTE, len(v.Array)/2, v.Array, protocol)
+	default:
+		return buf
+	}
+}
+
+func appendLine(buf []byte, typ byte, line []byte) []byte {
+	buf = append(buf, typ)
+	buf = append(buf, line...)
+	return append(buf, '\r', '\n')
+}
+
+func appendBlob(buf []byte, typ byte, blob []byte) []byte {
+	buf = append(buf, typ)
+	buf = strconv.AppendInt(buf, int64(len(blob)), 10)
+	buf = append(buf, '\r', '\n')
+	buf = append(buf, blob...)
+	return append(buf, '\r', '\n')
+}
+
+// appendAggregate encodes elements behind a header of the given type. RESP2
+// only knows arrays, so every aggregate becomes a flat array.
+func appendAggregate(buf []byte, typ byte, n int, elements []Value, protocol int) []byte {
+	if protocol < 3 {
+		typ, n = ARRAY, len(elements)
+	}
+
+	buf = append(buf, typ)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	buf = append(buf, '\r', '\n')
+	for _, element := range elements {
+		buf = element.AppendProtocol(buf, protocol)
+	}
+
+	return buf
+}
+
+// FormatDouble formats f the way Redis does, spelling infinities and NaN as
+// "inf", "-inf" and "nan".
+func FormatDouble(f float64) string {
+	return string(AppendDouble(nil, f))
+}
+
+func AppendDouble(buf []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(buf, "inf"...)
+	case math.IsInf(f, -1):
+		return append(buf, "-inf"...)
+	case math.IsNaN(f):
+		return append(buf, "nan"...)
+	default:
+		return strconv.AppendFloat(buf, f, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,98 @@
+package resp
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAppendProtocolRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value Value
+		resp3 string
+		// resp2 is what a RESP2 connection reads back.
+		resp2 Value
+	}{
+		{"simple string", OK(), "+OK\r\n", OK()},
+		{"error", Err("ERR oops"), "-ERR oops\r\n", Err("ERR oops")},
+		{"integer", Int(-42), ":-42\r\n", Int(-42)},
+		{"bulk", Bulk("a\r\nb"), "$4\r\na\r\nb\r\n", Bulk("a\r\nb")},
+		{"empty array", Array(), "*0\r\n", Array()},
+		{"null", Null(), "_\r\n", Null()},
+		{"double", Double(3.5), ",3.5\r\n", Bulk("3.5")},
+		{"infinite double", Double(math.Inf(-1)), ",-inf\r\n", Bulk("-inf")},
+		{"nan", Double(math.NaN()), ",nan\r\n", Bulk("nan")},
+		{"true", Bool(true), "#t\r\n", Int(1)},
+		{"false", Bool(false), "#f\r\n", Int(0)},
+		{"verbatim", Verbatim("txt", "hi"), "=6\r\ntxt:hi\r\n", Bulk("hi")},
+		{"big number", BigNumber("12345678901234567890"), "(12345678901234567890\r\n", Bulk("12345678901234567890")},
+		{
+			"map",
+			Map(Bulk("proto"), Int(3), Bulk("modules"), Array()),
+			"%2\r\n$5\r\nproto\r\n:3\r\n$7\r\nmodules\r\n*0\r\n",
+			Array(Bulk("proto"), Int(3), Bulk("modules"), Array()),
+		},
+		{"set", Set(Bulk("a"), Bulk("b")), "~2\r\n$1\r\na\r\n$1\r\nb\r\n", BulkArray("a", "b")},
+		{
+			"push",
+			Push(Bulk("message"), Bulk("news"), Double(1)),
+			">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n,1\r\n",
+			Array(Bulk("message"), Bulk("news"), Bulk("1")),
+		},
+		{
+			"nested",
+			Array(Map(Bulk("k"), Set(Bool(true))), Null()),
+			"*2\r\n%1\r\n$1\r\nk\r\n~1\r\n#t\r\n_\r\n",
+			Array(Array(Bulk("k"), Array(Int(1))), Null()),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := tt.value.AppendProtocol(nil, 3)
+			if string(encoded) != tt.resp3 {
+				t.Fatalf("RESP3 encoding is %q, want %q", encoded, tt.resp3)
+			}
+
+			got, err := newReader(string(encoded), Limits{}).Read()
+			if err != nil {
+				t.Fatalf("reading RESP3: %v", err)
+			}
+
+			if !equal(got, tt.value) {
+				t.Fatalf("RESP3 read back %+v, want %+v", got, tt.value)
+			}
+
+			got, err = newReader(string(tt.value.AppendProtocol(nil, 2)), Limits{}).Read()
+			if err != nil {
+				t.Fatalf("reading RESP2: %v", err)
+			}
+
+			if !equal(got, tt.resp2) {
+				t.Fatalf("RESP2 read back %+v, want %+v", got, tt.resp2)
+			}
+		})
+	}
+}
+
+// RESP3 has a single null, so a null array only survives on RESP2.
+func TestAppendProtocolNullArray(t *testing.T) {
+	if got := string(NullArray().AppendProtocol(nil, 3)); got != "_\r\n" {
+		t.Fatalf("RESP3 encoding is %q, want %q", got, "_\r\n")
+	}
+
+	if got := string(NullArray().AppendProtocol(nil, 2)); got != "*-1\r\n" {
+		t.Fatalf("RESP2 encoding is %q, want %q", got, "*-1\r\n")
+	}
+}
+
+func TestAppendProtocolAppends(t *testing.T) {
+	buf := []byte("prefix")
+	buf = Bulk("x").AppendProtocol(buf, 3)
+	buf = Value{}.AppendProtocol(buf, 3)
+	buf = Attribute(Bulk("ttl"), Int(1)).AppendProtocol(buf, 2)
+
+	if want := "prefix$1\r\nx\r\n"; string(buf) != want {
+		t.Fatalf("got %q, want %q", buf, want)
+	}
+}
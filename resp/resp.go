@@ -0,0 +1,321 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// maxLength bounds the declared lengths whatever the limits, so that adding
+// the trailing "\r\n" to a bulk length or counting both halves of a map's
+// pairs can't overflow.
+const maxLength = math.MaxInt / 4
+
+type Resp struct {
+	Reader *bufio.Reader
+	// Limits bounds what a single Read accepts. The zero value accepts anything.
+	Limits Limits
+	// size is the number of bytes consumed by the Read in progress.
+	size int
+}
+
+// ProtocolError is returned when the input isn't valid RESP. The connection
+// can't be trusted to be in sync after it, so it should be closed.
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Msg
+}
+
+// readRawLine reads up to and including the next '\n', failing with msg once
+// the line gets longer than Limits.MaxLineLen.
+func (r *Resp) readRawLine(msg string) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.Reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err := r.consume(len(chunk)); err != nil {
+			return nil, err
+		}
+
+		if r.Limits.MaxLineLen > 0 && len(line) > r.Limits.MaxLineLen {
+			return nil, &ProtocolError{Msg: msg}
+		}
+
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, err
+		}
+	}
+}
+
+// consume accounts for n more bytes of the current request.
+func (r *Resp) consume(n int) error {
+	r.size += n
+	if r.Limits.QueryBufferLimit > 0 && r.size > r.Limits.QueryBufferLimit {
+		return &ProtocolError{Msg: "client query buffer limit exceeded"}
+	}
+
+	return nil
+}
+
+func (r *Resp) readLine() (line []byte, n int, err error) {
+	line, err = r.readRawLine("too big line")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n = len(line)
+	if n < 2 || line[n-2] != '\r' {
+		return nil, n, &ProtocolError{Msg: "expected '\\r\\n'"}
+	}
+
+	return line[:n-2], n, nil
+}
+
+func (r *Resp) readInteger() (int, int, error) {
+	line, n, err := r.readLine()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	i64, err := strconv.ParseInt(string(line), 10, 64)
+	if err != nil {
+		return 0, n, &ProtocolError{Msg: "invalid integer"}
+	}
+
+	return int(i64), n, nil
+}
+
+// Read reads the next reply, of any RESP2 or RESP3 type.
+func (r *Resp) Read() (Value, error) {
+	r.size = 0
+	return r.readValue()
+}
+
+// ReadRequest reads the next command sent by a client. Like Redis, a request
+// starting with '*' is an array of bulk strings, and anything else is an
+// inline command.
+func (r *Resp) ReadRequest() (Value, error) {
+	r.size = 0
+	b, err := r.Reader.Peek(1)
+	if err != nil {
+		return Value{}, err
+	}
+
+	if b[0] != ARRAY {
+		return r.readInline()
+	}
+
+	r.Reader.ReadByte()
+	if err := r.consume(1); err != nil {
+		return Value{}, err
+	}
+
+	return r.readArray(true)
+}
+
+func (r *Resp) readValue() (Value, error) {
+	_type, err := r.Reader.ReadByte()
+	if err != nil {
+		return Value{}, err
+	}
+
+	if err := r.consume(1); err != nil {
+		return Value{}, err
+	}
+
+	switch _type {
+	case BULK:
+		return r.readBulk()
+	case ARRAY:
+		return r.readArray(false)
+	case STRING:
+		line, _, err := r.readLine()
+		return Value{Typ: SimpleStringType, Bytes: line}, err
+	case ERROR:
+		line, _, err := r.readLine()
+		return Value{Typ: ErrorType, Bytes: line}, err
+	case INTEGER:
+		i, _, err := r.readInteger()
+		return Int(i), err
+	case NULL:
+		_, _, err := r.readLine()
+		return Null(), err
+	case DOUBLE:
+		return r.readDouble()
+	case BOOLEAN:
+		return r.readBoolean()
+	case BLOB_ERROR:
+		v, err := r.readBulk()
+		return Value{Typ: ErrorType, Bytes: v.Bytes}, err
+	case VERBATIM:
+		return r.readVerbatim()
+	case BIG_NUMBER:
+		line, _, err := r.readLine()
+		return Value{Typ: BigNumberType, Bytes: line}, err
+	case MAP:
+		return r.readAggregate(MapType, 2)
+	case SET:
+		return r.readAggregate(SetType, 1)
+	case ATTRIBUTE:
+		return r.readAggregate(AttributeType, 2)
+	case PUSH:
+		return r.readAggregate(PushType, 1)
+	default:
+		return Value{}, &ProtocolError{Msg: fmt.Sprintf("unknown type '%c'", _type)}
+	}
+}
+
+// readBulk reads exactly the declared number of bytes, so bulk strings may
+// contain any byte including "\r\n".
+func (r *Resp) readBulk() (Value, error) {
+	v := Value{Typ: BulkType}
+
+	n, _, err := r.readInteger()
+	if err != nil {
+		return v, err
+	}
+
+	if n == -1 {
+		return Null(), nil
+	}
+
+	if n < 0 || n > maxLength || r.Limits.MaxBulkLen > 0 && n > r.Limits.MaxBulkLen {
+		return v, &ProtocolError{Msg: "invalid bulk length"}
+	}
+
+	if err := r.consume(n + 2); err != nil {
+		return v, err
+	}
+
+	// Grow the buffer as the data arrives instead of trusting the declared
+	// length, so that a bogus header can't allocate a huge buffer on its own.
+	var buf bytes.Buffer
+	buf.Grow(min(n+2, maxPrealloc))
+	if _, err := io.CopyN(&buf, r.Reader, int64(n+2)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return v, err
+	}
+
+	bulk := buf.Bytes()
+
+	if bulk[n] != '\r' || bulk[n+1] != '\n' {
+		return v, &ProtocolError{Msg: "expected '\\r\\n' after bulk string"}
+	}
+
+	v.Bytes = bulk[:n]
+	return v, nil
+}
+
+// readArray reads the elements of an array, which must all be bulk strings
+// when bulkOnly is set.
+func (r *Resp) readArray(bulkOnly bool) (Value, error) {
+	val := Value{Typ: ArrayType}
+
+	len, _, err := r.readInteger()
+	if err != nil {
+		return val, err
+	}
+
+	if len == -1 {
+		return NullArray(), nil
+	}
+
+	if len < 0 || len > maxLength || r.Limits.MaxMultibulkLen > 0 && len > r.Limits.MaxMultibulkLen {
+		return val, &ProtocolError{Msg: "invalid multibulk length"}
+	}
+
+	val.Array = make([]Value, 0, min(len, maxPrealloc))
+	for i := 0; i < len; i++ {
+		if bulkOnly {
+			if b, err := r.Reader.Peek(1); err == nil && b[0] != BULK {
+				return val, &ProtocolError{Msg: fmt.Sprintf("expected '$', got '%c'", b[0])}
+			}
+		}
+
+		v, err := r.readValue()
+		if err != nil {
+			return val, err
+		}
+
+		val.Array = append(val.Array, v)
+	}
+
+	return val, nil
+}
+
+// readAggregate reads a RESP3 aggregate of n elements, or n key-value pairs
+// when width is 2.
+func (r *Resp) readAggregate(typ Type, width int) (Value, error) {
+	val := Value{Typ: typ}
+
+	n, _, err := r.readInteger()
+	if err != nil {
+		return val, err
+	}
+
+	if n < 0 || n > maxLength || r.Limits.MaxMultibulkLen > 0 && n*width > r.Limits.MaxMultibulkLen {
+		return val, &ProtocolError{Msg: "invalid aggregate length"}
+	}
+
+	val.Array = make([]Value, 0, min(n*width, maxPrealloc))
+	for i := 0; i < n*width; i++ {
+		v, err := r.readValue()
+		if err != nil {
+			return val, err
+		}
+
+		val.Array = append(val.Array, v)
+	}
+
+	return val, nil
+}
+
+func (r *Resp) readDouble() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	f, err := strconv.ParseFloat(string(line), 64)
+	if err != nil {
+		return Value{}, &ProtocolError{Msg: "invalid double"}
+	}
+
+	return Double(f), nil
+}
+
+func (r *Resp) readBoolean() (Value, error) {
+	line, _, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+
+	if len(line) != 1 || line[0] != 't' && line[0] != 'f' {
+		return Value{}, &ProtocolError{Msg: "invalid boolean"}
+	}
+
+	return Bool(line[0] == 't'), nil
+}
+
+func (r *Resp) readVerbatim() (Value, error) {
+	v, err := r.readBulk()
+	if err != nil {
+		return Value{}, err
+	}
+
+	if len(v.Bytes) < 4 || v.Bytes[3] != ':' {
+		return Value{}, &ProtocolError{Msg: "invalid verbatim string"}
+	}
+
+	return Value{Typ: VerbatimType, Bytes: v.Bytes}, nil
+}
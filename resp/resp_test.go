@@ -0,0 +1,140 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func newReader(input string, limits Limits) *Resp {
+	return &Resp{Reader: bufio.NewReader(strings.NewReader(input)), Limits: limits}
+}
+
+// equal compares values field by field, as reflect.DeepEqual would tell a nil
+// slice from an empty one.
+func equal(a, b Value) bool {
+	if a.Typ != b.Typ || !bytes.Equal(a.Bytes, b.Bytes) || a.Int != b.Int || a.Bool != b.Bool || len(a.Array) != len(b.Array) {
+		return false
+	}
+
+	if a.Double != b.Double && !(math.IsNaN(a.Double) && math.IsNaN(b.Double)) {
+		return false
+	}
+
+	for i := range a.Array {
+		if !equal(a.Array[i], b.Array[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkErr reports whether err is the expected error: a ProtocolError with
+// the message want when it isn't a sentinel error, or no error at all.
+func checkErr(t *testing.T, err error, want string, sentinel error) {
+	t.Helper()
+
+	switch {
+	case sentinel != nil:
+		if !errors.Is(err, sentinel) {
+			t.Fatalf("got error %v, want %v", err, sentinel)
+		}
+	case want != "":
+		var protocolErr *ProtocolError
+		if !errors.As(err, &protocolErr) || protocolErr.Msg != want {
+			t.Fatalf("got error %v, want protocol error %q", err, want)
+		}
+	case err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		limits   Limits
+		want     Value
+		err      string
+		sentinel error
+	}{
+		{name: "bulk", input: "$5\r\nhello\r\n", want: Bulk("hello")},
+		{name: "bulk with crlf", input: "$4\r\na\r\nb\r\n", want: Bulk("a\r\nb")},
+		{name: "empty bulk", input: "$0\r\n\r\n", want: Bulk("")},
+		{name: "null bulk", input: "$-1\r\n", want: Null()},
+		{name: "truncated bulk", input: "$5\r\nhel", sentinel: io.ErrUnexpectedEOF},
+		{name: "truncated header", input: "$5", sentinel: io.EOF},
+		{name: "bulk without crlf", input: "$5\r\nhelloXY", err: "expected '\\r\\n' after bulk string"},
+		{name: "bulk longer than declared", input: "$3\r\nhello\r\n", err: "expected '\\r\\n' after bulk string"},
+		{name: "oversized bulk", input: "$11\r\nhello world\r\n", limits: Limits{MaxBulkLen: 10}, err: "invalid bulk length"},
+		{name: "bulk at the limit", input: "$10\r\nhelloworld\r\n", limits: Limits{MaxBulkLen: 10}, want: Bulk("helloworld")},
+		{name: "negative bulk length", input: "$-2\r\n", err: "invalid bulk length"},
+		{name: "overflowing bulk length", input: "$9223372036854775807\r\n", err: "invalid bulk length"},
+		{name: "overflowing blob error length", input: "!9223372036854775806\r\n", err: "invalid bulk length"},
+		{name: "overflowing verbatim length", input: "=9223372036854775807\r\n", err: "invalid bulk length"},
+		{name: "bulk length out of range", input: "$92233720368547758070\r\n", err: "invalid integer"},
+		{name: "invalid bulk length", input: "$x\r\n", err: "invalid integer"},
+		{name: "header without cr", input: "$5\nhello\r\n", err: "expected '\\r\\n'"},
+		{name: "query buffer limit", input: "$20\r\n01234567890123456789\r\n", limits: Limits{QueryBufferLimit: 16}, err: "client query buffer limit exceeded"},
+		{name: "too long line", input: "+" + strings.Repeat("a", 32) + "\r\n", limits: Limits{MaxLineLen: 16}, err: "too big line"},
+		{name: "array", input: "*2\r\n$3\r\nGET\r\n:1\r\n", want: Array(Bulk("GET"), Int(1))},
+		{name: "null array", input: "*-1\r\n", want: NullArray()},
+		{name: "oversized array", input: "*3\r\n:1\r\n:2\r\n:3\r\n", limits: Limits{MaxMultibulkLen: 2}, err: "invalid multibulk length"},
+		{name: "overflowing array length", input: "*9223372036854775807\r\n", err: "invalid multibulk length"},
+		{name: "overflowing map length", input: "%4611686018427387904\r\n", err: "invalid aggregate length"},
+		{name: "overflowing map length under limits", input: "%4611686018427387904\r\n", limits: Limits{MaxMultibulkLen: 16}, err: "invalid aggregate length"},
+		{name: "overflowing set length", input: "~9223372036854775807\r\n", err: "invalid aggregate length"},
+		{name: "oversized map", input: "%2\r\n:1\r\n:2\r\n:3\r\n:4\r\n", limits: Limits{MaxMultibulkLen: 3}, err: "invalid aggregate length"},
+		{name: "invalid double", input: ",abc\r\n", err: "invalid double"},
+		{name: "invalid boolean", input: "#x\r\n", err: "invalid boolean"},
+		{name: "invalid verbatim", input: "=3\r\ntxt\r\n", err: "invalid verbatim string"},
+		{name: "unknown type", input: "?1\r\n", err: "unknown type '?'"},
+		{name: "blob error", input: "!3\r\nERR\r\n", want: Err("ERR")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newReader(tt.input, tt.limits).Read()
+			checkErr(t, err, tt.err, tt.sentinel)
+			if tt.err == "" && tt.sentinel == nil && !equal(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		limits Limits
+		want   Value
+		err    string
+	}{
+		{name: "multibulk", input: "*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\n", want: BulkArray("ECHO", "hi")},
+		{name: "inline", input: "SET key \"a b\"\r\n", want: BulkArray("SET", "key", "a b")},
+		{name: "inline without cr", input: "PING\n", want: BulkArray("PING")},
+		{name: "inline starting with a type byte", input: ":1 x\r\n", want: BulkArray(":1", "x")},
+		{name: "inline starting with a bulk header", input: "$3\r\n", want: BulkArray("$3")},
+		{name: "non bulk element", input: "*2\r\n$4\r\nECHO\r\n:1\r\n", err: "expected '$', got ':'"},
+		{name: "oversized multibulk", input: "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", limits: Limits{MaxMultibulkLen: 2}, err: "invalid multibulk length"},
+		{name: "oversized bulk", input: "*1\r\n$5\r\nhello\r\n", limits: Limits{MaxBulkLen: 4}, err: "invalid bulk length"},
+		{name: "too big inline request", input: strings.Repeat("a", 32) + "\r\n", limits: Limits{MaxLineLen: 16}, err: "too big inline request"},
+		{name: "unbalanced quotes", input: "SET \"key\r\n", err: "unbalanced quotes in request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newReader(tt.input, tt.limits).ReadRequest()
+			checkErr(t, err, tt.err, nil)
+			if tt.err == "" && !equal(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package resp
+
+import "fmt"
+
+// Type identifies the kind of a Value.
+type Type uint8
+
+const (
+	// InvalidType is the type of the zero Value, which encodes to nothing.
+	InvalidType Type = iota
+	SimpleStringType
+	ErrorType
+	IntegerType
+	BulkType
+	ArrayType
+	// NullType is the null bulk string of RESP2.
+	NullType
+	// NullArrayType is the null array of RESP2.
+	NullArrayType
+
+	// RESP3 types.
+	DoubleType
+	BooleanType
+	VerbatimType
+	BigNumberType
+	MapType
+	SetType
+	AttributeType
+	PushType
+)
+
+var typeNames = [...]string{
+	InvalidType:      "invalid",
+	SimpleStringType: "simple string",
+	ErrorType:        "error",
+	IntegerType:      "integer",
+	BulkType:         "bulk string",
+	ArrayType:        "array",
+	NullType:         "null",
+	NullArrayType:    "null array",
+	DoubleType:       "double",
+	BooleanType:      "boolean",
+	VerbatimType:     "verbatim string",
+	BigNumberType:    "big number",
+	MapType:          "map",
+	SetType:          "set",
+	AttributeType:    "attribute",
+	PushType:         "push",
+}
+
+func (t Type) String() string {
+	if int(t) < len(typeNames) {
+		return typeNames[t]
+	}
+
+	return fmt.Sprintf("Type(%d)", t)
+}
+
+// Value is a RESP value. Simple strings, errors, bulk strings and big numbers
+// keep their payload in Bytes, verbatim strings keep "fmt:text". Aggregates
+// keep their elements in Array, with keys and values alternated for maps and
+// attributes.
+type Value struct {
+	Typ    Type
+	Bytes  []byte
+	Array  []Value
+	Int    int
+	Double float64
+	Bool   bool
+}
+
+const (
+	BULK    = '$'
+	ARRAY   = '*'
+	STRING  = '+'
+	ERROR   = '-'
+	INTEGER = ':'
+
+	// RESP3 types.
+	NULL       = '_'
+	DOUBLE     = ','
+	BOOLEAN    = '#'
+	BLOB_ERROR = '!'
+	VERBATIM   = '='
+	BIG_NUMBER = '('
+	MAP        = '%'
+	SET        = '~'
+	ATTRIBUTE  = '|'
+	PUSH       = '>'
+)
+
+func Simple(s string) Value {
+	return Value{Typ: SimpleStringType, Bytes: []byte(s)}
+}
+
+// OK returns the +OK reply.
+func OK() Value {
+	return Simple("OK")
+}
+
+func Err(msg string) Value {
+	return Value{Typ: ErrorType, Bytes: []byte(msg)}
+}
+
+func Errf(format string, args ...any) Value {
+	return Value{Typ: ErrorType, Bytes: fmt.Appendf(nil, format, args...)}
+}
+
+func Int(n int) Value {
+	return Value{Typ: IntegerType, Int: n}
+}
+
+func Bulk(s string) Value {
+	return Value{Typ: BulkType, Bytes: []byte(s)}
+}
+
+func BulkBytes(b []byte) Value {
+	return Value{Typ: BulkType, Bytes: b}
+}
+
+// Array returns an array of values. With no values it is an empty array, not
+// a null one.
+func Array(values ...Value) Value {
+	return Value{Typ: ArrayType, Array: values}
+}
+
+// BulkArray returns an array of bulk strings.
+func BulkArray(ss ...string) Value {
+	values := make([]Value, len(ss))
+	for i, s := range ss {
+		values[i] = Bulk(s)
+	}
+
+	return Array(values...)
+}
+
+func Null() Value {
+	return Value{Typ: NullType}
+}
+
+func NullArray() Value {
+	return Value{Typ: NullArrayType}
+}
+
+func Double(f float64) Value {
+	return Value{Typ: DoubleType, Double: f}
+}
+
+func Bool(b bool) Value {
+	return Value{Typ: BooleanType, Bool: b}
+}
+
+// Verbatim returns a verbatim string, format being a three letters type such
+// as "txt" or "mkd".
+func Verbatim(format, text string) Value {
+	return Value{Typ: VerbatimType, Bytes: []byte(format + ":" + text)}
+}
+
+func BigNumber(digits string) Value {
+	return Value{Typ: BigNumberType, Bytes: []byte(digits)}
+}
+
+// Map returns a map from alternated keys and values.
+func Map(keysAndValues ...Value) Value {
+	return Value{Typ: MapType, Array: keysAndValues}
+}
+
+func Set(values ...Value) Value {
+	return Value{Typ: SetType, Array: values}
+}
+
+func Push(values ...Value) Value {
+	return Value{Typ: PushType, Array: values}
+}
+
+// Attribute returns attributes from alternated keys and values. They are
+// sent before the reply they describe.
+func Attribute(keysAndValues ...Value) Value {
+	return Value{Typ: AttributeType, Array: keysAndValues}
+}
+
+// String returns the payload of v as a string. For verbatim strings it is the
+// text without its format.
+func (v Value) String() string {
+	if v.Typ == VerbatimType && len(v.Bytes) >= 4 {
+		return string(v.Bytes[4:])
+	}
+
+	return string(v.Bytes)
+}
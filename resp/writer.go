@@ -0,0 +1,17 @@
+package resp
+
+import "io"
+
+type Writer struct {
+	Writer io.Writer
+	// Protocol is the RESP version spoken by the other side, RESP2 when zero.
+	Protocol int
+	// buf is reused by every Write so that encoding doesn't allocate.
+	buf []byte
+}
+
+func (w *Writer) Write(v Value) error {
+	w.buf = v.AppendProtocol(w.buf[:0], w.Protocol)
+	_, err := w.Writer.Write(w.buf)
+	return err
+}